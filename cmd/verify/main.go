@@ -0,0 +1,66 @@
+// Команда verify проверяет доказательство честной жеребьевки, опубликованное ботом при /reveal.
+//
+//	go run ./cmd/verify -commitment <отпечаток> proof.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"telegram-secret-santa/internal/fairness"
+)
+
+func main() {
+	commitment := flag.String("commitment", "", "отпечаток, опубликованный ботом при /generate")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Использование: %s -commitment <отпечаток> [proof.txt]\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Без файла доказательство читается со стандартного ввода.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *commitment == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	input := io.Reader(os.Stdin)
+	if flag.NArg() == 1 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Не удалось открыть файл: %v\n", err)
+			os.Exit(2)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Не удалось прочитать доказательство: %v\n", err)
+		os.Exit(2)
+	}
+
+	proof, err := fairness.ParseProof(string(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Некорректное доказательство: %v\n", err)
+		os.Exit(2)
+	}
+	if err := proof.Verify(*commitment); err != nil {
+		fmt.Printf("❌ Проверка не пройдена: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ Пары игры %d не менялись после публикации отпечатка (пар: %d).\n", proof.GameID, len(proof.Pairs))
+	if len(proof.Entropy) > 0 {
+		fmt.Printf("✅ Зерно жеребьевки выведено из вкладов участников (%d). Сверьте отпечатки вкладов с опубликованными в чате:\n", len(proof.Entropy))
+		for _, e := range proof.Entropy {
+			fmt.Printf("   %d: %s (отпечаток вклада %s)\n", e.UserID, e.Text, fairness.EntropyCommitment(e))
+		}
+	} else {
+		fmt.Println("ℹ️ Участники не вносили вкладов в зерно, оно выбрано ботом случайно.")
+	}
+	fmt.Println("ℹ️ Утилита не проверяет, что пары получены из этого зерна: при жеребьевке учитываются ограничения и история сезонов, которых нет в доказательстве.")
+}
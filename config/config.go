@@ -5,12 +5,35 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+
+	StorageRedis  = "redis"
+	StorageBolt   = "bolt"
+	StorageMemory = "memory"
 )
 
 type Config struct {
 	Telegram struct {
 		BotToken string
+		APIURL   string
 		Admins   []string
+		Mode     string
+	}
+	Webhook struct {
+		URL    string
+		Listen string
+		Path   string
+		Secret string
+	}
+	Storage struct {
+		Backend      string
+		Path         string
+		LegacyGameID int64
 	}
 	Redis struct {
 		Host     string
@@ -18,7 +41,9 @@ type Config struct {
 		Password string
 		DB       int
 	}
-	TriggerWords []string
+	TriggerWords    []string
+	Workers         int
+	ShutdownTimeout time.Duration
 }
 
 func LoadFromEnv() (*Config, error) {
@@ -29,6 +54,14 @@ func LoadFromEnv() (*Config, error) {
 		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN environment variable is required")
 	}
 
+	cfg.Telegram.APIURL = os.Getenv("TELEGRAM_API_URL")
+	if cfg.Telegram.APIURL == "" {
+		cfg.Telegram.APIURL = "https://api.telegram.org"
+	}
+	if !strings.HasPrefix(cfg.Telegram.APIURL, "http://") && !strings.HasPrefix(cfg.Telegram.APIURL, "https://") {
+		return nil, fmt.Errorf("TELEGRAM_API_URL must start with http:// or https://, got %q", cfg.Telegram.APIURL)
+	}
+
 	adminsStr := os.Getenv("TELEGRAM_ADMINS")
 	if adminsStr != "" {
 		cfg.Telegram.Admins = strings.Split(adminsStr, ",")
@@ -37,6 +70,57 @@ func LoadFromEnv() (*Config, error) {
 		}
 	}
 
+	cfg.Telegram.Mode = strings.ToLower(strings.TrimSpace(os.Getenv("TELEGRAM_MODE")))
+	if cfg.Telegram.Mode == "" {
+		cfg.Telegram.Mode = ModePolling
+	}
+	if cfg.Telegram.Mode != ModePolling && cfg.Telegram.Mode != ModeWebhook {
+		return nil, fmt.Errorf("TELEGRAM_MODE must be %q or %q, got %q", ModePolling, ModeWebhook, cfg.Telegram.Mode)
+	}
+
+	cfg.Webhook.URL = os.Getenv("WEBHOOK_URL")
+
+	cfg.Webhook.Listen = os.Getenv("WEBHOOK_LISTEN")
+	if cfg.Webhook.Listen == "" {
+		cfg.Webhook.Listen = ":8080"
+	}
+
+	cfg.Webhook.Path = os.Getenv("WEBHOOK_PATH")
+	if cfg.Webhook.Path == "" {
+		cfg.Webhook.Path = "/webhook"
+	}
+	if !strings.HasPrefix(cfg.Webhook.Path, "/") {
+		cfg.Webhook.Path = "/" + cfg.Webhook.Path
+	}
+
+	cfg.Webhook.Secret = os.Getenv("WEBHOOK_SECRET")
+	if cfg.Telegram.Mode == ModeWebhook && cfg.Webhook.Secret == "" {
+		return nil, fmt.Errorf("WEBHOOK_SECRET environment variable is required in webhook mode")
+	}
+
+	cfg.Storage.Backend = strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE")))
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = StorageRedis
+	}
+	switch cfg.Storage.Backend {
+	case StorageRedis, StorageBolt, StorageMemory:
+	default:
+		return nil, fmt.Errorf("STORAGE must be %q, %q or %q, got %q", StorageRedis, StorageBolt, StorageMemory, cfg.Storage.Backend)
+	}
+
+	cfg.Storage.Path = os.Getenv("STORAGE_PATH")
+	if cfg.Storage.Path == "" {
+		cfg.Storage.Path = "secret-santa.db"
+	}
+
+	if legacyStr := os.Getenv("LEGACY_GAME_ID"); legacyStr != "" {
+		legacyGameID, err := strconv.ParseInt(strings.TrimSpace(legacyStr), 10, 64)
+		if err != nil || legacyGameID == 0 {
+			return nil, fmt.Errorf("LEGACY_GAME_ID must be a chat ID like -1001234567890, got %q", legacyStr)
+		}
+		cfg.Storage.LegacyGameID = legacyGameID
+	}
+
 	cfg.Redis.Host = os.Getenv("REDIS_HOST")
 	if cfg.Redis.Host == "" {
 		cfg.Redis.Host = "localhost"
@@ -65,5 +149,23 @@ func LoadFromEnv() (*Config, error) {
 		}
 	}
 
+	cfg.Workers = 8
+	if workersStr := os.Getenv("WORKERS"); workersStr != "" {
+		workers, err := strconv.Atoi(workersStr)
+		if err != nil || workers < 1 {
+			return nil, fmt.Errorf("WORKERS must be a positive number, got %q", workersStr)
+		}
+		cfg.Workers = workers
+	}
+
+	cfg.ShutdownTimeout = 30 * time.Second
+	if timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("SHUTDOWN_TIMEOUT must be a positive duration like 30s, got %q", timeoutStr)
+		}
+		cfg.ShutdownTimeout = timeout
+	}
+
 	return cfg, nil
 }
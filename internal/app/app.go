@@ -1,11 +1,15 @@
 package app
 
 import (
+	"context"
 	"log"
 	"math/rand"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"telegram-secret-santa/config"
+	"telegram-secret-santa/internal/domain"
 	"telegram-secret-santa/internal/service"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -17,48 +21,131 @@ func Run() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	storage, err := service.NewStorage(
-		cfg.Redis.Host,
-		cfg.Redis.Port,
-		cfg.Redis.Password,
-		cfg.Redis.DB,
-	)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	storage, err := newStorage(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
 	}
-	defer storage.Close()
+	defer func() {
+		if err := storage.Close(); err != nil {
+			log.Printf("Failed to close storage: %v", err)
+		}
+		log.Printf("Storage closed, bye!")
+	}()
 
-	bot, err := service.NewSecretSantaBot(cfg.Telegram.BotToken, cfg.Telegram.Admins, storage, cfg.TriggerWords)
+	api, err := service.NewTelegramAPI(cfg.Telegram.BotToken, cfg.Telegram.APIURL)
 	if err != nil {
 		log.Fatalf("Failed to create bot: %v", err)
 	}
+	bot := service.NewSecretSantaBot(api, api.Self, cfg.Telegram.Admins, storage, cfg.TriggerWords)
 
 	rand.Seed(time.Now().UnixNano())
 
-	runBot(bot)
+	runBot(ctx, api, bot, cfg)
+}
+
+func newStorage(ctx context.Context, cfg *config.Config) (domain.StorageInterface, error) {
+	switch cfg.Storage.Backend {
+	case config.StorageBolt:
+		log.Printf("Using file storage %s", cfg.Storage.Path)
+		return service.NewBoltStorage(cfg.Storage.Path)
+	case config.StorageMemory:
+		log.Printf("Using in-memory storage: all data will be lost on shutdown")
+		return service.NewMemoryStorage(), nil
+	default:
+		return service.NewStorage(
+			ctx,
+			cfg.Redis.Host,
+			cfg.Redis.Port,
+			cfg.Redis.Password,
+			cfg.Redis.DB,
+			cfg.Storage.LegacyGameID,
+		)
+	}
 }
 
-func runBot(bot *service.SecretSantaBot) {
+// runBot принимает обновления до сигнала остановки, а затем дожидается обработчиков,
+// которые уже работают. Если они не укладываются в ShutdownTimeout, их контекст отменяется.
+// После этого очередь исходящих сообщений досылает то, что успевает за ShutdownTimeout.
+func runBot(ctx context.Context, api *tgbotapi.BotAPI, bot *service.SecretSantaBot, cfg *config.Config) {
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	outboxDone := make(chan struct{})
+	go func() {
+		bot.Outbox.Run(outboxCtx)
+		close(outboxDone)
+	}()
+
+	schedulerDone := make(chan struct{})
+	go func() {
+		bot.RunScheduler(ctx)
+		close(schedulerDone)
+	}()
+
+	d := newDispatcher(handlerCtx, cfg.Workers, bot.HandleUpdate)
+
+	if cfg.Telegram.Mode == config.ModeWebhook {
+		runWebhook(ctx, api, cfg, d)
+	} else {
+		runPolling(ctx, api, d)
+	}
+
+	log.Printf("Shutting down: waiting up to %s for in-flight updates", cfg.ShutdownTimeout)
+	drainDispatcher(d, cfg.ShutdownTimeout, cancelHandlers)
+	<-schedulerDone
+
+	stopOutbox()
+	<-outboxDone
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelFlush()
+	log.Printf("Flushing outgoing messages")
+	bot.Outbox.Flush(flushCtx)
+}
+
+func drainDispatcher(d *dispatcher, timeout time.Duration, cancelHandlers context.CancelFunc) {
+	drained := d.Close()
+	select {
+	case <-drained:
+		log.Printf("All in-flight updates processed")
+		return
+	case <-time.After(timeout):
+	}
+
+	log.Printf("Shutdown deadline exceeded, cancelling in-flight handlers")
+	cancelHandlers()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		log.Printf("Some handlers did not stop after cancellation")
+	}
+}
+
+func runPolling(ctx context.Context, api *tgbotapi.BotAPI, d *dispatcher) {
+	if _, err := api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Printf("Failed to delete webhook before polling: %v", err)
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	updates := bot.Bot.GetUpdatesChan(u)
+	updates := api.GetUpdatesChan(u)
 
 	log.Printf("Bot started and ready!")
 
-	for update := range updates {
-		if update.Message != nil {
-			if update.Message.From != nil {
-				bot.SaveUserInfo(update.Message.From)
-			}
-			if update.Message.Text != "" {
-				bot.CheckTriggerWords(update.Message)
-			}
-			if update.Message.IsCommand() {
-				bot.HandleCommand(update)
-			} else if update.Message.ForwardFrom != nil {
-				bot.HandleForwardedMessage(update.Message)
+	for {
+		select {
+		case <-ctx.Done():
+			api.StopReceivingUpdates()
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
 			}
+			d.Dispatch(update)
 		}
 	}
 }
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// dispatcher обрабатывает обновления пулом воркеров. Обновления одного чата
+// выполняются строго по очереди, разные чаты обрабатываются параллельно.
+type dispatcher struct {
+	ctx    context.Context
+	handle func(context.Context, tgbotapi.Update)
+
+	mu        sync.Mutex
+	pending   map[int64][]tgbotapi.Update
+	ready     chan int64
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newDispatcher(ctx context.Context, workers int, handle func(context.Context, tgbotapi.Update)) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &dispatcher{
+		ctx:     ctx,
+		handle:  handle,
+		pending: make(map[int64][]tgbotapi.Update),
+		ready:   make(chan int64, workers*16),
+		done:    make(chan struct{}),
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func updateKey(update tgbotapi.Update) int64 {
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+func (d *dispatcher) Dispatch(update tgbotapi.Update) {
+	key := updateKey(update)
+
+	d.mu.Lock()
+	queue, scheduled := d.pending[key]
+	d.pending[key] = append(queue, update)
+	d.mu.Unlock()
+
+	if !scheduled {
+		d.ready <- key
+	}
+}
+
+// Close перестает принимать обновления и возвращает канал, который закрывается,
+// когда все принятые обновления обработаны. После Close вызывать Dispatch нельзя.
+func (d *dispatcher) Close() <-chan struct{} {
+	d.closeOnce.Do(func() {
+		close(d.ready)
+		go func() {
+			d.wg.Wait()
+			close(d.done)
+		}()
+	})
+	return d.done
+}
+
+func (d *dispatcher) work() {
+	defer d.wg.Done()
+	for key := range d.ready {
+		for {
+			d.mu.Lock()
+			queue := d.pending[key]
+			if len(queue) == 0 {
+				delete(d.pending, key)
+				d.mu.Unlock()
+				break
+			}
+			update := queue[0]
+			d.pending[key] = queue[1:]
+			d.mu.Unlock()
+
+			d.process(update)
+		}
+	}
+}
+
+func (d *dispatcher) process(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while handling update %d: %v\n%s", update.UpdateID, r, debug.Stack())
+		}
+	}()
+	d.handle(d.ctx, update)
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func chatUpdate(updateID int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: updateID, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+func waitClosed(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher did not finish")
+	}
+}
+
+func TestDispatcherKeepsPerChatOrder(t *testing.T) {
+	const (
+		chats   = 5
+		perChat = 50
+	)
+	var (
+		mu       sync.Mutex
+		got      = make(map[int64][]int)
+		inFlight = make(map[int64]bool)
+		parallel = false
+	)
+	d := newDispatcher(context.Background(), 4, func(ctx context.Context, update tgbotapi.Update) {
+		chatID := update.Message.Chat.ID
+		mu.Lock()
+		if inFlight[chatID] {
+			t.Errorf("two updates of chat %d are handled at the same time", chatID)
+		}
+		inFlight[chatID] = true
+		for other, busy := range inFlight {
+			parallel = parallel || (busy && other != chatID)
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Duration(update.UpdateID%3) * time.Millisecond)
+
+		mu.Lock()
+		inFlight[chatID] = false
+		got[chatID] = append(got[chatID], update.UpdateID)
+		mu.Unlock()
+	})
+
+	for i := 0; i < perChat; i++ {
+		for chat := int64(1); chat <= chats; chat++ {
+			d.Dispatch(chatUpdate(i, chat))
+		}
+	}
+	waitClosed(t, d.Close())
+
+	for chat := int64(1); chat <= chats; chat++ {
+		if len(got[chat]) != perChat {
+			t.Fatalf("chat %d: handled %d updates, want %d", chat, len(got[chat]), perChat)
+		}
+		for i, updateID := range got[chat] {
+			if updateID != i {
+				t.Fatalf("chat %d: updates handled out of order: %v", chat, got[chat])
+			}
+		}
+	}
+	if !parallel {
+		t.Error("updates of different chats were never handled in parallel")
+	}
+}
+
+func TestDispatcherDrainsOnClose(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		mu      sync.Mutex
+		handled int
+	)
+	d := newDispatcher(context.Background(), 2, func(ctx context.Context, update tgbotapi.Update) {
+		<-release
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	})
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		d.Dispatch(chatUpdate(i, int64(i%3)))
+	}
+	done := d.Close()
+
+	select {
+	case <-done:
+		t.Fatal("Close finished before the accepted updates were handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	waitClosed(t, done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if handled != total {
+		t.Fatalf("handled %d updates before shutdown, want %d", handled, total)
+	}
+}
+
+func TestDispatcherSurvivesPanics(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []int
+	)
+	d := newDispatcher(context.Background(), 1, func(ctx context.Context, update tgbotapi.Update) {
+		if update.UpdateID == 1 {
+			panic("boom")
+		}
+		mu.Lock()
+		got = append(got, update.UpdateID)
+		mu.Unlock()
+	})
+	for i := 0; i < 3; i++ {
+		d.Dispatch(chatUpdate(i, 7))
+	}
+	waitClosed(t, d.Close())
+
+	if len(got) != 2 || got[0] != 0 || got[1] != 2 {
+		t.Fatalf("handled %v, want [0 2]", got)
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	maxUpdateSize       = 1 << 20
+)
+
+func runWebhook(ctx context.Context, api *tgbotapi.BotAPI, cfg *config.Config, d *dispatcher) {
+	if err := registerWebhook(api, cfg); err != nil {
+		log.Fatalf("Failed to register webhook: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Webhook.Path, newWebhookHandler(cfg.Webhook.Secret, d.Dispatch))
+
+	server := &http.Server{
+		Addr:              cfg.Webhook.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Webhook server shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("Bot started and ready! Listening for webhook updates on %s%s", cfg.Webhook.Listen, cfg.Webhook.Path)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Webhook server stopped: %v", err)
+	}
+}
+
+// registerWebhook сообщает Telegram адрес вебхука. Если WEBHOOK_URL не задан,
+// вебхук считается настроенным вручную (например, при локальной отладке).
+func registerWebhook(api *tgbotapi.BotAPI, cfg *config.Config) error {
+	if cfg.Webhook.URL == "" {
+		log.Printf("WEBHOOK_URL is not set, skipping setWebhook")
+		return nil
+	}
+
+	params := tgbotapi.Params{
+		"url":          strings.TrimSuffix(cfg.Webhook.URL, "/") + cfg.Webhook.Path,
+		"secret_token": cfg.Webhook.Secret,
+	}
+	if _, err := api.MakeRequest("setWebhook", params); err != nil {
+		return err
+	}
+	log.Printf("Webhook registered at %s", params["url"])
+	return nil
+}
+
+func newWebhookHandler(secret string, handle func(tgbotapi.Update)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			log.Printf("Webhook: rejected update with invalid secret token from %s", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+			log.Printf("Webhook: failed to decode update: %v", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		handle(update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
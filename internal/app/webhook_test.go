@@ -0,0 +1,99 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	const secret = "s3cret"
+	tests := []struct {
+		name       string
+		method     string
+		secret     string
+		body       string
+		wantStatus int
+		wantUpdate int // UpdateID переданного обновления, 0 - обновление не передается
+	}{
+		{
+			name:       "valid update",
+			method:     http.MethodPost,
+			secret:     secret,
+			body:       `{"update_id": 42, "message": {"message_id": 1, "text": "/start", "chat": {"id": 7, "type": "private"}}}`,
+			wantStatus: http.StatusOK,
+			wantUpdate: 42,
+		},
+		{
+			name:       "missing secret",
+			method:     http.MethodPost,
+			body:       `{"update_id": 42}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong secret",
+			method:     http.MethodPost,
+			secret:     "guess",
+			body:       `{"update_id": 42}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed body",
+			method:     http.MethodPost,
+			secret:     secret,
+			body:       `{"update_id": `,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "body of the wrong type",
+			method:     http.MethodPost,
+			secret:     secret,
+			body:       `{"update_id": "42"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "oversized body",
+			method:     http.MethodPost,
+			secret:     secret,
+			body:       `{"update_id": 42, "padding": "` + strings.Repeat("x", maxUpdateSize) + `"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not a POST",
+			method:     http.MethodGet,
+			secret:     secret,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled []tgbotapi.Update
+			handler := newWebhookHandler(secret, func(update tgbotapi.Update) {
+				handled = append(handled, update)
+			})
+
+			req := httptest.NewRequest(tt.method, "/webhook", strings.NewReader(tt.body))
+			if tt.secret != "" {
+				req.Header.Set(webhookSecretHeader, tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantUpdate == 0 {
+				if len(handled) != 0 {
+					t.Fatalf("handled %d updates, want none", len(handled))
+				}
+				return
+			}
+			if len(handled) != 1 || handled[0].UpdateID != tt.wantUpdate {
+				t.Fatalf("handled = %+v, want update %d", handled, tt.wantUpdate)
+			}
+		})
+	}
+}
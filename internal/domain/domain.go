@@ -1,40 +1,259 @@
 package domain
 
+import (
+	"context"
+	"time"
+)
+
 type Participant struct {
 	UserID   int64
 	Username string
 	FullName string
 }
 
+type Game struct {
+	ID    int64
+	Title string
+}
+
+type AssignmentMode string
+
+const (
+	AssignmentModeAny      AssignmentMode = "any"
+	AssignmentModeChain    AssignmentMode = "chain"
+	AssignmentModeNoMutual AssignmentMode = "nomutual"
+)
+
+type GameSettings struct {
+	Mode           AssignmentMode
+	HistorySeasons int
+	HistoryHard    bool
+}
+
+// RevealSettings - раскрытие пар в конце текущего сезона. Хранится вместе с игрой
+// и сбрасывается при /reset.
+type RevealSettings struct {
+	ScheduledAt     time.Time
+	RevealedAt      time.Time
+	SantaLookup     bool
+	SantaLookupFrom time.Time
+}
+
+type PreferenceKind string
+
+const (
+	PreferenceAvoid PreferenceKind = "avoid"
+	PreferenceLove  PreferenceKind = "love"
+)
+
+type Group struct {
+	Name      string
+	CreatorID int64
+	Members   []int64
+}
+
+type Pairing struct {
+	GiverID      int64
+	ReceiverID   int64
+	GiverName    string
+	ReceiverName string
+}
+
+type Season struct {
+	GameID     int64
+	Title      string
+	Date       time.Time
+	Pairings   []Pairing
+	Commitment string
+}
+
+// DrawRecord - сведения о текущем распределении: число пар и криптографический отпечаток
+// (commitment) пар, которые можно показывать всем, а также соль и зерно жеребьевки,
+// которые держатся в секрете до раскрытия. Published - отпечаток, объявленный в чате игры;
+// он отличается от Commitment, если распределение потом тихо исправлялось.
+type DrawRecord struct {
+	Pairs      int
+	Commitment string
+	Published  string
+	Salt       string
+	Seed       string
+	CreatedAt  time.Time
+}
+
+// PublishedCommitment возвращает отпечаток, который видели участники. У записей,
+// сохраненных до появления Published, это сам Commitment.
+func (r *DrawRecord) PublishedCommitment() string {
+	if r.Published != "" {
+		return r.Published
+	}
+	return r.Commitment
+}
+
+// Entropy - вклад участника в зерно жеребьевки и случайный nonce к его отпечатку.
+type Entropy struct {
+	Nonce string
+	Text  string
+}
+
+type Reachability struct {
+	Reachable bool
+	ErrorCode int
+	Reason    string
+	CheckedAt time.Time
+}
+
+type GiftStatus string
+
+const (
+	GiftNotStarted GiftStatus = ""
+	GiftBought     GiftStatus = "bought"
+	GiftShipped    GiftStatus = "shipped"
+	GiftDelivered  GiftStatus = "delivered"
+	GiftReceived   GiftStatus = "received"
+)
+
+type OutboundButton struct {
+	Text string
+	Data string
+}
+
+type OutboundMessage struct {
+	ID        string
+	ChatID    int64
+	Text      string
+	ParseMode string
+	Buttons   [][]OutboundButton
+	BatchID   string
+	Relay     *RelayRoute
+	Attempts  int
+	NotBefore time.Time
+	CreatedAt time.Time
+}
+
+type RelayRole string
+
+const (
+	RelayRoleSanta    RelayRole = "santa"
+	RelayRoleReceiver RelayRole = "receiver"
+)
+
+// RelayRoute - кем в игре приходится получатель анонимного сообщения; по нему
+// определяется, куда отправить ответ на это сообщение.
+type RelayRoute struct {
+	GameID int64
+	Role   RelayRole
+}
+
+type RelayMessage struct {
+	GameID    int64
+	FromID    int64
+	ToID      int64
+	FromRole  RelayRole
+	Text      string
+	CreatedAt time.Time
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending"
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed"
+)
+
+type Delivery struct {
+	ChatID    int64
+	Recipient string
+	Status    DeliveryStatus
+	ErrorCode int
+	Error     string
+	Attempts  int
+}
+
+type OutboundBatch struct {
+	ID           string
+	GameID       int64
+	Title        string
+	ReportChatID int64
+	Total        int
+	CreatedAt    time.Time
+}
+
 type StorageInterface interface {
-	SaveParticipant(p *Participant) error
-	GetParticipant(userID int64) (*Participant, error)
-	GetAllParticipants() (map[int64]*Participant, error)
-	DeleteParticipant(userID int64) error
-	SaveRestriction(userID, forbiddenUserID, creatorID int64) error
-	HasRestriction(userID, forbiddenUserID int64) (bool, error)
-	GetRestrictionCreator(userID, forbiddenUserID int64) (int64, error)
-	GetAllRestrictions() (map[int64]map[int64]bool, map[int64]map[int64]int64, error)
-	DeleteRestriction(userID, forbiddenUserID int64) error
-	DeleteAllRestrictionsForUser(userID int64) error
-	SaveAssignment(giverID, receiverID int64) error
-	GetAssignment(giverID int64) (int64, error)
-	GetAllAssignments() (map[int64]int64, error)
-	DeleteAssignment(giverID int64) error
-	DeleteAllAssignments() error
-	SaveGameState(gameActive, gameStarted bool) error
-	GetGameState() (bool, bool, error)
-	ResetGameState() error
-	SaveWish(userID int64, wish string) error
-	GetWish(userID int64) (string, error)
-	DeleteWish(userID int64) error
-	SaveTriggerMessage(triggerWord, message string) error
-	GetTriggerMessages(triggerWord string) ([]string, error)
-	GetAllTriggerWords() ([]string, error)
-	DeleteTriggerMessage(triggerWord, message string) error
-	SaveComment(receiverID, authorID int64, comment string) error
-	GetComments(receiverID int64) (map[int64]string, error)
-	DeleteComment(receiverID, authorID int64) error
-	ClearAll() error
+	SaveUser(ctx context.Context, p *Participant) error
+	GetUser(ctx context.Context, userID int64) (*Participant, error)
+	GetAllUsers(ctx context.Context) (map[int64]*Participant, error)
+	SaveGame(ctx context.Context, g *Game) error
+	GetGame(ctx context.Context, gameID int64) (*Game, error)
+	GetAllGames(ctx context.Context) (map[int64]*Game, error)
+	SaveReachability(ctx context.Context, userID int64, r *Reachability) error
+	GetReachability(ctx context.Context, userID int64) (*Reachability, error)
+	GetUserGames(ctx context.Context, userID int64) ([]int64, error)
+	SaveSelectedGame(ctx context.Context, userID, gameID int64) error
+	GetSelectedGame(ctx context.Context, userID int64) (int64, error)
+	SaveParticipant(ctx context.Context, gameID int64, p *Participant) error
+	GetParticipant(ctx context.Context, gameID, userID int64) (*Participant, error)
+	GetAllParticipants(ctx context.Context, gameID int64) (map[int64]*Participant, error)
+	DeleteParticipant(ctx context.Context, gameID, userID int64) error
+	SaveRestriction(ctx context.Context, gameID, userID, forbiddenUserID, creatorID int64) error
+	HasRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) (bool, error)
+	GetRestrictionCreator(ctx context.Context, gameID, userID, forbiddenUserID int64) (int64, error)
+	GetAllRestrictions(ctx context.Context, gameID int64) (map[int64]map[int64]bool, map[int64]map[int64]int64, error)
+	DeleteRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) error
+	DeleteAllRestrictionsForUser(ctx context.Context, gameID, userID int64) error
+	SavePreference(ctx context.Context, gameID, userID, targetID int64, kind PreferenceKind) error
+	GetAllPreferences(ctx context.Context, gameID int64) (map[int64]map[int64]PreferenceKind, error)
+	DeletePreference(ctx context.Context, gameID, userID, targetID int64) error
+	SaveGroup(ctx context.Context, gameID int64, g *Group) error
+	GetGroup(ctx context.Context, gameID int64, name string) (*Group, error)
+	GetAllGroups(ctx context.Context, gameID int64) (map[string]*Group, error)
+	DeleteGroup(ctx context.Context, gameID int64, name string) error
+	SaveAssignment(ctx context.Context, gameID, giverID, receiverID int64) error
+	GetAssignment(ctx context.Context, gameID, giverID int64) (int64, error)
+	GetAllAssignments(ctx context.Context, gameID int64) (map[int64]int64, error)
+	DeleteAssignment(ctx context.Context, gameID, giverID int64) error
+	DeleteAllAssignments(ctx context.Context, gameID int64) error
+	SaveGiftStatus(ctx context.Context, gameID, giverID int64, status GiftStatus) error
+	GetGiftStatus(ctx context.Context, gameID, giverID int64) (GiftStatus, error)
+	GetAllGiftStatuses(ctx context.Context, gameID int64) (map[int64]GiftStatus, error)
+	SaveGameState(ctx context.Context, gameID int64, gameActive, gameStarted bool) error
+	GetGameState(ctx context.Context, gameID int64) (bool, bool, error)
+	ResetGameState(ctx context.Context, gameID int64) error
+	SaveGameSettings(ctx context.Context, gameID int64, settings *GameSettings) error
+	GetGameSettings(ctx context.Context, gameID int64) (*GameSettings, error)
+	SaveDrawRecord(ctx context.Context, gameID int64, r *DrawRecord) error
+	SaveEntropy(ctx context.Context, gameID, userID int64, e *Entropy) error
+	GetAllEntropy(ctx context.Context, gameID int64) (map[int64]*Entropy, error)
+	GetDrawRecord(ctx context.Context, gameID int64) (*DrawRecord, error)
+	SaveRevealSettings(ctx context.Context, gameID int64, r *RevealSettings) error
+	GetRevealSettings(ctx context.Context, gameID int64) (*RevealSettings, error)
+	SaveSeason(ctx context.Context, season *Season) error
+	GetSeasons(ctx context.Context, gameID int64) ([]*Season, error)
+	SaveWish(ctx context.Context, gameID, userID int64, wish string) error
+	GetWish(ctx context.Context, gameID, userID int64) (string, error)
+	DeleteWish(ctx context.Context, gameID, userID int64) error
+	SaveTriggerMessage(ctx context.Context, triggerWord, message string) error
+	GetTriggerMessages(ctx context.Context, triggerWord string) ([]string, error)
+	GetAllTriggerWords(ctx context.Context) ([]string, error)
+	DeleteTriggerMessage(ctx context.Context, triggerWord, message string) error
+	SaveComment(ctx context.Context, gameID, receiverID, authorID int64, comment string) error
+	GetComments(ctx context.Context, gameID, receiverID int64) (map[int64]string, error)
+	DeleteComment(ctx context.Context, gameID, receiverID, authorID int64) error
+	EnqueueOutbound(ctx context.Context, m *OutboundMessage) error
+	// EnqueueOutboundBatch сохраняет рассылку, ее доставки и сообщения одной транзакцией.
+	EnqueueOutboundBatch(ctx context.Context, b *OutboundBatch, deliveries []*Delivery, messages []*OutboundMessage) error
+	GetDueOutbound(ctx context.Context, now time.Time, limit int) ([]*OutboundMessage, error)
+	RescheduleOutbound(ctx context.Context, m *OutboundMessage) error
+	DeleteOutbound(ctx context.Context, id string) error
+	SaveOutboundBatch(ctx context.Context, b *OutboundBatch) error
+	GetOutboundBatch(ctx context.Context, id string) (*OutboundBatch, error)
+	DeleteOutboundBatch(ctx context.Context, id string) error
+	SaveDelivery(ctx context.Context, batchID string, d *Delivery) error
+	GetDeliveries(ctx context.Context, batchID string) ([]*Delivery, error)
+	SaveRelayRoute(ctx context.Context, chatID int64, messageID int, route *RelayRoute) error
+	GetRelayRole(ctx context.Context, gameID, chatID int64, messageID int) (RelayRole, error)
+	SaveRelayMessage(ctx context.Context, m *RelayMessage) error
+	GetRelayMessages(ctx context.Context, gameID int64) ([]*RelayMessage, error)
+	ClearGame(ctx context.Context, gameID int64) error
 	Close() error
 }
@@ -0,0 +1,236 @@
+// Package fairness описывает проверяемую жеребьевку: при /generate публикуется отпечаток
+// (commitment) распределения с секретной солью, а при /reveal - сама соль и пары, так что
+// любой может убедиться, что распределение не меняли после публикации отпечатка.
+package fairness
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	proofVersion   = "secret-santa:v1"
+	seedVersion    = "secret-santa-seed:v1"
+	entropyVersion = "secret-santa-entropy:v1"
+)
+
+// Entropy - вклад участника в зерно жеребьевки. Nonce - случайная строка, которая
+// входит в отпечаток вклада, чтобы короткий вклад нельзя было подобрать по словарю.
+// У вкладов, принятых до появления nonce, он пустой.
+type Entropy struct {
+	UserID int64
+	Nonce  string
+	Text   string
+}
+
+// Proof - все, что нужно для проверки распределения. Отпечаток - SHA-256 от String().
+type Proof struct {
+	GameID  int64
+	Salt    string
+	Entropy []Entropy
+	Seed    string
+	Pairs   map[int64]int64
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// NewSalt возвращает соль, которая держится в секрете до раскрытия: без нее по отпечатку
+// нельзя перебором восстановить пары.
+func NewSalt() (string, error) {
+	return randomHex(32)
+}
+
+func RandomSeed() (string, error) {
+	return randomHex(32)
+}
+
+// NewNonce возвращает nonce для вклада участника. Он держится в секрете вместе с
+// текстом вклада и раскрывается в доказательстве.
+func NewNonce() (string, error) {
+	return randomHex(16)
+}
+
+// NormalizeEntropy приводит вклад к одной строке, чтобы он однозначно записывался в доказательство.
+func NormalizeEntropy(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// EntropyCommitment - отпечаток вклада, который публикуется в чате игры до жеребьевки.
+// Без nonce (вклады старого формата) это SHA-256 от самого текста.
+func EntropyCommitment(e Entropy) string {
+	if e.Nonce == "" {
+		sum := sha256.Sum256([]byte(NormalizeEntropy(e.Text)))
+		return hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\nnonce:%s\nuser:%d\ntext:%s\n", entropyVersion, e.Nonce, e.UserID, NormalizeEntropy(e.Text))))
+	return hex.EncodeToString(sum[:])
+}
+
+func sortEntropy(entropy []Entropy) []Entropy {
+	sorted := append([]Entropy(nil), entropy...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+	return sorted
+}
+
+// DeriveSeed выводит зерно жеребьевки из вкладов участников.
+func DeriveSeed(gameID int64, entropy []Entropy) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\ngame:%d\n", seedVersion, gameID)
+	for _, e := range sortEntropy(entropy) {
+		fmt.Fprintf(hash, "entropy:%d:%s\n", e.UserID, NormalizeEntropy(e.Text))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// SeedValue превращает зерно в значение для источника случайных чисел решателя.
+func SeedValue(seed string) (int64, error) {
+	raw, err := hex.DecodeString(seed)
+	if err != nil || len(raw) < 8 {
+		return 0, fmt.Errorf("invalid seed %q", seed)
+	}
+	return int64(binary.BigEndian.Uint64(raw[:8])), nil
+}
+
+func (p *Proof) String() string {
+	var text strings.Builder
+	text.WriteString(proofVersion + "\n")
+	text.WriteString(fmt.Sprintf("game:%d\n", p.GameID))
+	text.WriteString(fmt.Sprintf("salt:%s\n", p.Salt))
+	for _, e := range sortEntropy(p.Entropy) {
+		if e.Nonce != "" {
+			text.WriteString(fmt.Sprintf("nonce:%d:%s\n", e.UserID, e.Nonce))
+		}
+		text.WriteString(fmt.Sprintf("entropy:%d:%s\n", e.UserID, NormalizeEntropy(e.Text)))
+	}
+	text.WriteString(fmt.Sprintf("seed:%s\n", p.Seed))
+
+	giverIDs := make([]int64, 0, len(p.Pairs))
+	for giverID := range p.Pairs {
+		giverIDs = append(giverIDs, giverID)
+	}
+	sort.Slice(giverIDs, func(i, j int) bool { return giverIDs[i] < giverIDs[j] })
+	for _, giverID := range giverIDs {
+		text.WriteString(fmt.Sprintf("pair:%d:%d\n", giverID, p.Pairs[giverID]))
+	}
+	return text.String()
+}
+
+func (p *Proof) Commitment() string {
+	sum := sha256.Sum256([]byte(p.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseProof читает доказательство в формате String(). Пустые строки и пробелы
+// по краям строк игнорируются, чтобы текст можно было скопировать из чата.
+func ParseProof(text string) (*Proof, error) {
+	proof := &Proof{Pairs: make(map[int64]int64)}
+	versionSeen := false
+	nonces := make(map[int64]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == proofVersion {
+			versionSeen = true
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		var err error
+		switch key {
+		case "game":
+			proof.GameID, err = strconv.ParseInt(value, 10, 64)
+		case "salt":
+			proof.Salt = value
+		case "seed":
+			proof.Seed = value
+		case "nonce":
+			userID, nonce, _ := strings.Cut(value, ":")
+			var id int64
+			id, err = strconv.ParseInt(userID, 10, 64)
+			nonces[id] = nonce
+		case "entropy":
+			userID, text, _ := strings.Cut(value, ":")
+			var id int64
+			id, err = strconv.ParseInt(userID, 10, 64)
+			proof.Entropy = append(proof.Entropy, Entropy{UserID: id, Nonce: nonces[id], Text: text})
+			delete(nonces, id)
+		case "pair":
+			giver, receiver, _ := strings.Cut(value, ":")
+			var giverID, receiverID int64
+			if giverID, err = strconv.ParseInt(giver, 10, 64); err == nil {
+				receiverID, err = strconv.ParseInt(receiver, 10, 64)
+			}
+			if _, duplicate := proof.Pairs[giverID]; duplicate && err == nil {
+				err = errors.New("duplicate giver")
+			}
+			proof.Pairs[giverID] = receiverID
+		default:
+			err = errors.New("unknown field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %q: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !versionSeen {
+		return nil, fmt.Errorf("missing %q header", proofVersion)
+	}
+	for userID := range nonces {
+		return nil, fmt.Errorf("nonce for user %d has no entropy line", userID)
+	}
+	return proof, nil
+}
+
+// Verify проверяет, что доказательство соответствует опубликованному отпечатку, зерно
+// выведено из вкладов участников (если они есть), а пары образуют корректное распределение.
+// Что пары получены решателем именно из этого зерна, Verify не проверяет: решатель учитывает
+// ограничения и штрафы, которых в доказательстве нет. Отпечаток гарантирует только, что
+// пары не меняли после публикации.
+func (p *Proof) Verify(commitment string) error {
+	if got := p.Commitment(); !strings.EqualFold(got, strings.TrimSpace(commitment)) {
+		return fmt.Errorf("commitment mismatch: published %s, computed %s", commitment, got)
+	}
+	if len(p.Entropy) > 0 {
+		if seed := DeriveSeed(p.GameID, p.Entropy); seed != p.Seed {
+			return fmt.Errorf("seed %s is not derived from participants' entropy (expected %s)", p.Seed, seed)
+		}
+	}
+
+	received := make(map[int64]bool, len(p.Pairs))
+	for giverID, receiverID := range p.Pairs {
+		if giverID == receiverID {
+			return fmt.Errorf("participant %d gives a gift to themselves", giverID)
+		}
+		if _, ok := p.Pairs[receiverID]; !ok {
+			return fmt.Errorf("receiver %d is not a giver", receiverID)
+		}
+		if received[receiverID] {
+			return fmt.Errorf("receiver %d gets more than one gift", receiverID)
+		}
+		received[receiverID] = true
+	}
+	return nil
+}
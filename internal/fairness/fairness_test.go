@@ -0,0 +1,130 @@
+package fairness
+
+import (
+	"strings"
+	"testing"
+)
+
+func testProof() *Proof {
+	entropy := []Entropy{
+		{UserID: 1, Nonce: "0f1e2d3c4b5a69788796a5b4c3d2e1f0", Text: "снег"},
+		{UserID: 2, Nonce: "00112233445566778899aabbccddeeff", Text: "мандарины"},
+	}
+	return &Proof{
+		GameID:  -100,
+		Salt:    "salt",
+		Entropy: entropy,
+		Seed:    DeriveSeed(-100, entropy),
+		Pairs:   map[int64]int64{1: 2, 2: 3, 3: 1},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	commitment := testProof().Commitment()
+
+	tests := []struct {
+		name       string
+		tamper     func(p *Proof)
+		commitment string
+		wantErr    string // пусто - проверка должна пройти
+	}{
+		{
+			name:       "untouched",
+			tamper:     func(p *Proof) {},
+			commitment: commitment,
+		},
+		{
+			name:       "tampered commitment",
+			tamper:     func(p *Proof) {},
+			commitment: strings.Repeat("0", len(commitment)),
+			wantErr:    "commitment mismatch",
+		},
+		{
+			name:       "tampered pairs",
+			tamper:     func(p *Proof) { p.Pairs = map[int64]int64{1: 3, 3: 2, 2: 1} },
+			commitment: commitment,
+			wantErr:    "commitment mismatch",
+		},
+		{
+			name:       "tampered seed",
+			tamper:     func(p *Proof) { p.Seed = strings.Repeat("a", 64) },
+			commitment: commitment,
+			wantErr:    "commitment mismatch",
+		},
+		{
+			// Отпечаток пересчитан под подмененное зерно, но зерно не выводится из вкладов.
+			name:    "seed not derived from entropy",
+			tamper:  func(p *Proof) { p.Seed = strings.Repeat("a", 64) },
+			wantErr: "not derived from participants' entropy",
+		},
+		{
+			name:    "pairs are not an assignment",
+			tamper:  func(p *Proof) { p.Pairs = map[int64]int64{1: 2, 2: 1, 3: 1} },
+			wantErr: "gets more than one gift",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testProof()
+			tt.tamper(p)
+			commitment := tt.commitment
+			if commitment == "" {
+				commitment = p.Commitment()
+			}
+
+			// Доказательство проверяется так же, как в cmd/verify: из опубликованного текста.
+			parsed, err := ParseProof(p.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = parsed.Verify(commitment)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Verify = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseProofKeepsNonces(t *testing.T) {
+	p := testProof()
+	parsed, err := ParseProof(p.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range sortEntropy(p.Entropy) {
+		if got := parsed.Entropy[i]; got != e {
+			t.Fatalf("entropy %d = %+v, want %+v", i, got, e)
+		}
+	}
+
+	if _, err := ParseProof(proofVersion + "\nnonce:7:abc\n"); err == nil {
+		t.Fatal("ParseProof accepted a nonce without an entropy line")
+	}
+}
+
+func TestEntropyCommitmentHidesShortText(t *testing.T) {
+	e := Entropy{UserID: 1, Nonce: "0f1e2d3c4b5a69788796a5b4c3d2e1f0", Text: "снег"}
+	legacy := EntropyCommitment(Entropy{UserID: 1, Text: "снег"})
+
+	if got := EntropyCommitment(e); got == legacy {
+		t.Fatal("commitment with a nonce equals the bare SHA-256 of the text")
+	}
+	for _, other := range []Entropy{
+		{UserID: 1, Nonce: "00112233445566778899aabbccddeeff", Text: e.Text},
+		{UserID: 2, Nonce: e.Nonce, Text: e.Text},
+		{UserID: 1, Nonce: e.Nonce, Text: "дождь"},
+	} {
+		if EntropyCommitment(other) == EntropyCommitment(e) {
+			t.Fatalf("%+v and %+v have the same commitment", other, e)
+		}
+	}
+	if EntropyCommitment(Entropy{UserID: 1, Nonce: e.Nonce, Text: "  снег "}) != EntropyCommitment(e) {
+		t.Fatal("commitment depends on surrounding whitespace")
+	}
+}
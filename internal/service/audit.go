@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+	"telegram-secret-santa/internal/fairness"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *SecretSantaBot) gameEntropy(ctx context.Context, gameID int64) ([]fairness.Entropy, error) {
+	contributions, err := s.Storage.GetAllEntropy(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	entropy := make([]fairness.Entropy, 0, len(contributions))
+	for userID, e := range contributions {
+		entropy = append(entropy, fairness.Entropy{UserID: userID, Nonce: e.Nonce, Text: e.Text})
+	}
+	return entropy, nil
+}
+
+// drawSeed выбирает зерно жеребьевки: из вкладов участников, если они есть, иначе случайно.
+func (s *SecretSantaBot) drawSeed(ctx context.Context, gameID int64) (string, error) {
+	entropy, err := s.gameEntropy(ctx, gameID)
+	if err != nil {
+		return "", err
+	}
+	if len(entropy) > 0 {
+		return fairness.DeriveSeed(gameID, entropy), nil
+	}
+	return fairness.RandomSeed()
+}
+
+func (s *SecretSantaBot) drawProof(ctx context.Context, gameID int64, record *domain.DrawRecord) (*fairness.Proof, error) {
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
+	}
+	entropy, err := s.gameEntropy(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	return &fairness.Proof{GameID: gameID, Salt: record.Salt, Entropy: entropy, Seed: record.Seed, Pairs: assignments}, nil
+}
+
+// recordDraw сохраняет отпечаток текущего распределения с новой солью, пишет его в журнал
+// и публикует в чате игры. Сами пары в журнал не попадают. С пустым announcement отпечаток
+// не публикуется, а участникам по-прежнему показывается прежний.
+func (s *SecretSantaBot) recordDraw(ctx context.Context, gameID int64, seed, reason, announcement string) (*domain.DrawRecord, error) {
+	salt, err := fairness.NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	record := &domain.DrawRecord{Salt: salt, Seed: seed, CreatedAt: time.Now()}
+	if announcement == "" {
+		previous, err := s.Storage.GetDrawRecord(ctx, gameID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get draw record: %w", err)
+		}
+		if previous != nil {
+			record.Published = previous.PublishedCommitment()
+		}
+	}
+
+	proof, err := s.drawProof(ctx, gameID, record)
+	if err != nil {
+		return nil, err
+	}
+	record.Pairs = len(proof.Pairs)
+	record.Commitment = proof.Commitment()
+	if announcement != "" {
+		record.Published = record.Commitment
+	}
+	if err := s.Storage.SaveDrawRecord(ctx, gameID, record); err != nil {
+		return nil, fmt.Errorf("failed to save draw record: %w", err)
+	}
+	log.Printf("audit: gameID=%d %s: %d pairs, commitment %s", gameID, reason, record.Pairs, record.Commitment)
+
+	if gameID < 0 && announcement != "" {
+		s.sendMessage(ctx, gameID, fmt.Sprintf("%s\n\n🔐 Отпечаток распределения (SHA-256):\n%s\n\n"+
+			"Сохраните его: при /reveal бот опубликует пары и соль, и любой сможет проверить, что распределение не меняли.",
+			announcement, record.Commitment))
+	}
+	return record, nil
+}
+
+func (s *SecretSantaBot) currentSeed(ctx context.Context, gameID int64) (string, error) {
+	record, err := s.Storage.GetDrawRecord(ctx, gameID)
+	if err != nil {
+		return "", err
+	}
+	if record != nil && record.Seed != "" {
+		return record.Seed, nil
+	}
+	return fairness.RandomSeed()
+}
+
+// formatProof - сообщение для проверки жеребьевки, которое публикуется при раскрытии.
+func (s *SecretSantaBot) formatProof(ctx context.Context, gameID int64) (string, error) {
+	record, err := s.Storage.GetDrawRecord(ctx, gameID)
+	if err != nil {
+		return "", err
+	}
+	if record == nil {
+		return "", nil
+	}
+	proof, err := s.drawProof(ctx, gameID, record)
+	if err != nil {
+		return "", err
+	}
+	if commitment := proof.Commitment(); commitment != record.Commitment {
+		log.Printf("audit: gameID=%d assignments do not match the published commitment %s (now %s)", gameID, record.Commitment, commitment)
+	}
+
+	published := fmt.Sprintf("Отпечаток, опубликованный заранее:\n%s", record.Commitment)
+	if record.PublishedCommitment() != record.Commitment {
+		published = fmt.Sprintf("Отпечаток, опубликованный заранее:\n%s\n\n"+
+			"После этого состав игры менялся, и распределение исправлялось. Отпечаток итогового распределения:\n%s",
+			record.PublishedCommitment(), record.Commitment)
+	}
+
+	return fmt.Sprintf("🔐 Проверка честности жеребьевки\n\n%s\n\n"+
+		"Сохраните текст ниже в файл proof.txt и выполните в папке с исходным кодом бота:\n"+
+		"go run ./cmd/verify -commitment %s proof.txt\n\n%s",
+		published, record.Commitment, proof.String()), nil
+}
+
+// handlePairs показывает администратору все пары игры. Это единственный способ увидеть
+// распределение до раскрытия, поэтому каждый просмотр пишется в журнал и объявляется в чате игры.
+func (s *SecretSantaBot) handlePairs(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		return
+	}
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, "🤫 Распределение можно посмотреть только в личных сообщениях с ботом.")
+		return
+	}
+
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения распределения: %v", err))
+		return
+	}
+	if len(assignments) == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, "📝 Распределение еще не создано.")
+		return
+	}
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+
+	commitment := "неизвестен"
+	if record, err := s.Storage.GetDrawRecord(ctx, gameID); err == nil && record != nil {
+		commitment = record.Commitment
+	}
+	log.Printf("audit: gameID=%d pairs viewed by admin userID=%d (@%s): %d pairs, commitment %s",
+		gameID, msg.From.ID, msg.From.UserName, len(assignments), commitment)
+
+	pairs := make([]string, 0, len(assignments))
+	for giverID, receiverID := range assignments {
+		receiver := participantDisplayName(participants, receiverID)
+		if participants[receiverID] == nil {
+			// Пара осталась после /remove, который не удалось починить без новой жеребьевки.
+			receiver = "⚠️ получатель вышел из игры"
+		}
+		pairs = append(pairs, fmt.Sprintf("🎁 %s → %s", participantDisplayName(participants, giverID), receiver))
+	}
+	sort.Strings(pairs)
+
+	text := fmt.Sprintf("🔐 Распределение игры «%s» (пар: %d)\nОтпечаток: %s\n\n%s",
+		s.gameTitle(ctx, gameID), len(pairs), commitment, strings.Join(pairs, "\n"))
+	for _, part := range splitMessage(text) {
+		s.sendMessage(ctx, msg.Chat.ID, part)
+	}
+
+	adminName := msg.From.FirstName
+	if msg.From.UserName != "" {
+		adminName = "@" + msg.From.UserName
+	}
+	if gameID < 0 {
+		s.sendMessage(ctx, gameID, fmt.Sprintf("🔍 Администратор %s посмотрел распределение игры.", adminName))
+	}
+}
+
+// handleEntropy принимает вклад участника в зерно жеребьевки (commit-then-reveal): в чате
+// игры сразу публикуется отпечаток вклада, а сам текст раскрывается вместе с парами.
+func (s *SecretSantaBot) handleEntropy(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, "🤫 Вклад в жеребьевку отправляется в личных сообщениях с ботом, чтобы никто не узнал его заранее.")
+		return
+	}
+
+	text := fairness.NormalizeEntropy(msg.CommandArguments())
+	if text == "" {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Напишите любое секретное слово или фразу. Пример: /entropy мандарины и снег 1987")
+		return
+	}
+
+	participant, err := s.Storage.GetParticipant(ctx, gameID, msg.From.ID)
+	if err != nil || participant == nil {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Вы не участвуете в игре.")
+		return
+	}
+
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	gameActive, _, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
+		return
+	}
+	if gameActive {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Жеребьевка уже проведена, вклады больше не принимаются.")
+		return
+	}
+
+	nonce, err := fairness.NewNonce()
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения вклада: %v", err))
+		return
+	}
+	if err := s.Storage.SaveEntropy(ctx, gameID, msg.From.ID, &domain.Entropy{Nonce: nonce, Text: text}); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения вклада: %v", err))
+		return
+	}
+
+	commitment := fairness.EntropyCommitment(fairness.Entropy{UserID: msg.From.ID, Nonce: nonce, Text: text})
+	if gameID < 0 {
+		s.sendMessage(ctx, gameID, fmt.Sprintf("🎲 %s внес(ла) вклад в зерно жеребьевки.\nОтпечаток вклада: %s", participant.FullName, commitment))
+	}
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Вклад принят. Его отпечаток опубликован в чате игры:\n%s\n\n"+
+		"Сам текст будет раскрыт при /reveal, и каждый сможет проверить, что зерно жеребьевки выведено из вкладов участников.", commitment))
+}
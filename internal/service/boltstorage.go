@@ -0,0 +1,1032 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("santa")
+
+// BoltStorage хранит данные в одном файле bbolt - для небольших игр, где не хочется поднимать Redis.
+// Каждая запись лежит под своим ключом (userKey, participantKey и т.п.), а поля хешей и элементы
+// списков - под ключом «ключ:поле»: ключи в bbolt упорядочены, поэтому выборка списка - это один
+// обход по префиксу, и хеши-индексы, как в Redis, не нужны.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+func fieldKey(key, field string) string {
+	return key + ":" + field
+}
+
+// listItemKey дополняет номер нулями, чтобы элементы списка шли по порядку при обходе.
+func listItemKey(key string, seq uint64) string {
+	return fmt.Sprintf("%s:%020d", key, seq)
+}
+
+func outboxQueueItemKey(m *domain.OutboundMessage) string {
+	return fmt.Sprintf("%s:%020d:%s", outboxQueueKey, m.NotBefore.UnixMilli(), m.ID)
+}
+
+func (s *BoltStorage) get(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltBucket).Get([]byte(key)); v != nil {
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (s *BoltStorage) put(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+}
+
+func (s *BoltStorage) del(keys ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scanPrefix обходит ключи с префиксом по порядку. Значения действительны только внутри fn.
+func scanPrefix(b *bolt.Bucket, prefix string, fn func(key string, value []byte) bool) {
+	p := []byte(prefix)
+	c := b.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if !fn(string(k), v) {
+			return
+		}
+	}
+}
+
+func (s *BoltStorage) scan(prefix string, fn func(key string, value []byte)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		scanPrefix(tx.Bucket(boltBucket), prefix, func(key string, value []byte) bool {
+			fn(key, value)
+			return true
+		})
+		return nil
+	})
+}
+
+func deletePrefix(b *bolt.Bucket, prefix string, keep func(key string) bool) error {
+	var keys []string
+	scanPrefix(b, prefix, func(key string, _ []byte) bool {
+		if keep == nil || !keep(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		if err := b.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStorage) getJSON(key string, v interface{}) (bool, error) {
+	data, err := s.get(key)
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *BoltStorage) putJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.put(key, data)
+}
+
+func (s *BoltStorage) getInt(key string) (int64, error) {
+	data, err := s.get(key)
+	if err != nil || data == nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+func (s *BoltStorage) SaveUser(ctx context.Context, p *domain.Participant) error {
+	if err := s.putJSON(userKey(p.UserID), p); err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetUser(ctx context.Context, userID int64) (*domain.Participant, error) {
+	var p domain.Participant
+	found, err := s.getJSON(userKey(userID), &p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (s *BoltStorage) GetAllUsers(ctx context.Context) (map[int64]*domain.Participant, error) {
+	users := make(map[int64]*domain.Participant)
+	err := s.scan("user:", func(key string, value []byte) {
+		var p domain.Participant
+		if err := json.Unmarshal(value, &p); err == nil {
+			users[p.UserID] = &p
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return users, nil
+}
+
+func (s *BoltStorage) SaveGame(ctx context.Context, g *domain.Game) error {
+	if err := s.putJSON(gameInfoKey(g.ID), g); err != nil {
+		return fmt.Errorf("failed to save game: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetGame(ctx context.Context, gameID int64) (*domain.Game, error) {
+	var g domain.Game
+	found, err := s.getJSON(gameInfoKey(gameID), &g)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+func (s *BoltStorage) GetAllGames(ctx context.Context) (map[int64]*domain.Game, error) {
+	games := make(map[int64]*domain.Game)
+	err := s.scan("game:", func(key string, value []byte) {
+		if !strings.HasSuffix(key, ":info") {
+			return
+		}
+		var g domain.Game
+		if err := json.Unmarshal(value, &g); err == nil && gameInfoKey(g.ID) == key {
+			games[g.ID] = &g
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get games: %w", err)
+	}
+	return games, nil
+}
+
+func (s *BoltStorage) SaveReachability(ctx context.Context, userID int64, r *domain.Reachability) error {
+	if err := s.putJSON(reachabilityKey(userID), r); err != nil {
+		return fmt.Errorf("failed to save reachability: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetReachability(ctx context.Context, userID int64) (*domain.Reachability, error) {
+	var r domain.Reachability
+	found, err := s.getJSON(reachabilityKey(userID), &r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reachability: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (s *BoltStorage) GetUserGames(ctx context.Context, userID int64) ([]int64, error) {
+	prefix := userGamesKey(userID) + ":"
+	gameIDs := []int64{}
+	err := s.scan(prefix, func(key string, _ []byte) {
+		if gameID, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64); err == nil {
+			gameIDs = append(gameIDs, gameID)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user games: %w", err)
+	}
+	return gameIDs, nil
+}
+
+func (s *BoltStorage) SaveSelectedGame(ctx context.Context, userID, gameID int64) error {
+	return s.put(selectedGameKey(userID), []byte(strconv.FormatInt(gameID, 10)))
+}
+
+func (s *BoltStorage) GetSelectedGame(ctx context.Context, userID int64) (int64, error) {
+	gameID, err := s.getInt(selectedGameKey(userID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get selected game: %w", err)
+	}
+	return gameID, nil
+}
+
+func (s *BoltStorage) SaveParticipant(ctx context.Context, gameID int64, p *domain.Participant) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to serialize participant: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if err := b.Put([]byte(participantKey(gameID, p.UserID)), data); err != nil {
+			return fmt.Errorf("failed to save participant: %w", err)
+		}
+		return b.Put([]byte(fieldKey(userGamesKey(p.UserID), strconv.FormatInt(gameID, 10))), []byte("1"))
+	})
+}
+
+func (s *BoltStorage) GetParticipant(ctx context.Context, gameID, userID int64) (*domain.Participant, error) {
+	var p domain.Participant
+	found, err := s.getJSON(participantKey(gameID, userID), &p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participant: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (s *BoltStorage) GetAllParticipants(ctx context.Context, gameID int64) (map[int64]*domain.Participant, error) {
+	participants := make(map[int64]*domain.Participant)
+	err := s.scan(gameKeyPrefix(gameID)+"participant:", func(key string, value []byte) {
+		var p domain.Participant
+		if err := json.Unmarshal(value, &p); err == nil {
+			participants[p.UserID] = &p
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participants: %w", err)
+	}
+	return participants, nil
+}
+
+func (s *BoltStorage) DeleteParticipant(ctx context.Context, gameID, userID int64) error {
+	if err := s.del(participantKey(gameID, userID), fieldKey(userGamesKey(userID), strconv.FormatInt(gameID, 10))); err != nil {
+		return fmt.Errorf("failed to delete participant: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) SaveRestriction(ctx context.Context, gameID, userID, forbiddenUserID, creatorID int64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if err := b.Put([]byte(restrictionKey(gameID, userID, forbiddenUserID)), []byte("1")); err != nil {
+			return err
+		}
+		return b.Put([]byte(restrictionCreatorKey(gameID, userID, forbiddenUserID)), []byte(strconv.FormatInt(creatorID, 10)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save restriction: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) HasRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) (bool, error) {
+	data, err := s.get(restrictionKey(gameID, userID, forbiddenUserID))
+	if err != nil {
+		return false, fmt.Errorf("failed to check restriction: %w", err)
+	}
+	return data != nil, nil
+}
+
+func (s *BoltStorage) GetRestrictionCreator(ctx context.Context, gameID, userID, forbiddenUserID int64) (int64, error) {
+	creatorID, err := s.getInt(restrictionCreatorKey(gameID, userID, forbiddenUserID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get restriction creator: %w", err)
+	}
+	return creatorID, nil
+}
+
+func (s *BoltStorage) GetAllRestrictions(ctx context.Context, gameID int64) (map[int64]map[int64]bool, map[int64]map[int64]int64, error) {
+	restrictions := make(map[int64]map[int64]bool)
+	creators := make(map[int64]map[int64]int64)
+
+	prefix := gameKeyPrefix(gameID)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		scanPrefix(b, prefix+"restriction:", func(key string, _ []byte) bool {
+			var userID, forbiddenUserID int64
+			if _, err := fmt.Sscanf(strings.TrimPrefix(key, prefix), "restriction:%d:%d", &userID, &forbiddenUserID); err != nil {
+				return true
+			}
+
+			if restrictions[userID] == nil {
+				restrictions[userID] = make(map[int64]bool)
+			}
+			restrictions[userID][forbiddenUserID] = true
+
+			creator := b.Get([]byte(restrictionCreatorKey(gameID, userID, forbiddenUserID)))
+			if creatorID, err := strconv.ParseInt(string(creator), 10, 64); err == nil && creatorID != 0 {
+				if creators[userID] == nil {
+					creators[userID] = make(map[int64]int64)
+				}
+				creators[userID][forbiddenUserID] = creatorID
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get restrictions: %w", err)
+	}
+
+	return restrictions, creators, nil
+}
+
+func (s *BoltStorage) DeleteRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) error {
+	if err := s.del(restrictionKey(gameID, userID, forbiddenUserID), restrictionCreatorKey(gameID, userID, forbiddenUserID)); err != nil {
+		return fmt.Errorf("failed to delete restriction: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) DeleteAllRestrictionsForUser(ctx context.Context, gameID, userID int64) error {
+	prefix := gameKeyPrefix(gameID)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		var forbidden []int64
+		scanPrefix(b, fmt.Sprintf("%srestriction:%d:", prefix, userID), func(key string, _ []byte) bool {
+			var keyUserID, forbiddenUserID int64
+			if _, err := fmt.Sscanf(strings.TrimPrefix(key, prefix), "restriction:%d:%d", &keyUserID, &forbiddenUserID); err == nil {
+				forbidden = append(forbidden, forbiddenUserID)
+			}
+			return true
+		})
+		for _, forbiddenUserID := range forbidden {
+			if err := b.Delete([]byte(restrictionKey(gameID, userID, forbiddenUserID))); err != nil {
+				return fmt.Errorf("failed to delete restriction: %w", err)
+			}
+			if err := b.Delete([]byte(restrictionCreatorKey(gameID, userID, forbiddenUserID))); err != nil {
+				return fmt.Errorf("failed to delete restriction creator: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) SavePreference(ctx context.Context, gameID, userID, targetID int64, kind domain.PreferenceKind) error {
+	return s.put(preferenceKey(gameID, userID, targetID), []byte(kind))
+}
+
+func (s *BoltStorage) GetAllPreferences(ctx context.Context, gameID int64) (map[int64]map[int64]domain.PreferenceKind, error) {
+	preferences := make(map[int64]map[int64]domain.PreferenceKind)
+
+	prefix := gameKeyPrefix(gameID)
+	err := s.scan(prefix+"preference:", func(key string, value []byte) {
+		var userID, targetID int64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(key, prefix), "preference:%d:%d", &userID, &targetID); err != nil {
+			return
+		}
+		if preferences[userID] == nil {
+			preferences[userID] = make(map[int64]domain.PreferenceKind)
+		}
+		preferences[userID][targetID] = domain.PreferenceKind(value)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+
+	return preferences, nil
+}
+
+func (s *BoltStorage) DeletePreference(ctx context.Context, gameID, userID, targetID int64) error {
+	return s.del(preferenceKey(gameID, userID, targetID))
+}
+
+func (s *BoltStorage) SaveGroup(ctx context.Context, gameID int64, g *domain.Group) error {
+	if err := s.putJSON(groupKey(gameID, g.Name), g); err != nil {
+		return fmt.Errorf("failed to save group: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetGroup(ctx context.Context, gameID int64, name string) (*domain.Group, error) {
+	var g domain.Group
+	found, err := s.getJSON(groupKey(gameID, name), &g)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+func (s *BoltStorage) GetAllGroups(ctx context.Context, gameID int64) (map[string]*domain.Group, error) {
+	groups := make(map[string]*domain.Group)
+	err := s.scan(gameKeyPrefix(gameID)+"group:", func(key string, value []byte) {
+		var g domain.Group
+		if err := json.Unmarshal(value, &g); err == nil {
+			groups[strings.ToLower(g.Name)] = &g
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	return groups, nil
+}
+
+func (s *BoltStorage) DeleteGroup(ctx context.Context, gameID int64, name string) error {
+	return s.del(groupKey(gameID, name))
+}
+
+// SaveAssignment при смене получателя сбрасывает статус подарка, как и в Redis.
+func (s *BoltStorage) SaveAssignment(ctx context.Context, gameID, giverID, receiverID int64) error {
+	receiver := []byte(strconv.FormatInt(receiverID, 10))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		key := []byte(assignmentKey(gameID, giverID))
+		if !bytes.Equal(b.Get(key), receiver) {
+			if err := b.Delete([]byte(fieldKey(giftStatusKey(gameID), strconv.FormatInt(giverID, 10)))); err != nil {
+				return fmt.Errorf("failed to reset gift status: %w", err)
+			}
+		}
+		return b.Put(key, receiver)
+	})
+}
+
+func (s *BoltStorage) GetAssignment(ctx context.Context, gameID, giverID int64) (int64, error) {
+	receiverID, err := s.getInt(assignmentKey(gameID, giverID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get assignment: %w", err)
+	}
+	return receiverID, nil
+}
+
+func (s *BoltStorage) GetAllAssignments(ctx context.Context, gameID int64) (map[int64]int64, error) {
+	assignments := make(map[int64]int64)
+
+	prefix := gameKeyPrefix(gameID)
+	err := s.scan(prefix+"assignment:", func(key string, value []byte) {
+		var giverID int64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(key, prefix), "assignment:%d", &giverID); err != nil {
+			return
+		}
+		if receiverID, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			assignments[giverID] = receiverID
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
+	}
+
+	return assignments, nil
+}
+
+func (s *BoltStorage) DeleteAssignment(ctx context.Context, gameID, giverID int64) error {
+	return s.del(assignmentKey(gameID, giverID), fieldKey(giftStatusKey(gameID), strconv.FormatInt(giverID, 10)))
+}
+
+func (s *BoltStorage) DeleteAllAssignments(ctx context.Context, gameID int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if err := deletePrefix(b, gameKeyPrefix(gameID)+"assignment:", nil); err != nil {
+			return fmt.Errorf("failed to delete assignments: %w", err)
+		}
+		return deletePrefix(b, giftStatusKey(gameID)+":", nil)
+	})
+}
+
+func (s *BoltStorage) SaveGiftStatus(ctx context.Context, gameID, giverID int64, status domain.GiftStatus) error {
+	return s.put(fieldKey(giftStatusKey(gameID), strconv.FormatInt(giverID, 10)), []byte(status))
+}
+
+func (s *BoltStorage) GetGiftStatus(ctx context.Context, gameID, giverID int64) (domain.GiftStatus, error) {
+	data, err := s.get(fieldKey(giftStatusKey(gameID), strconv.FormatInt(giverID, 10)))
+	if err != nil {
+		return "", fmt.Errorf("failed to get gift status: %w", err)
+	}
+	return domain.GiftStatus(data), nil
+}
+
+func (s *BoltStorage) GetAllGiftStatuses(ctx context.Context, gameID int64) (map[int64]domain.GiftStatus, error) {
+	prefix := giftStatusKey(gameID) + ":"
+	statuses := make(map[int64]domain.GiftStatus)
+	err := s.scan(prefix, func(key string, value []byte) {
+		if giverID, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64); err == nil {
+			statuses[giverID] = domain.GiftStatus(value)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gift statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+func (s *BoltStorage) SaveGameState(ctx context.Context, gameID int64, gameActive, gameStarted bool) error {
+	return s.put(gameStateKey(gameID), []byte(fmt.Sprintf("%t:%t", gameActive, gameStarted)))
+}
+
+func (s *BoltStorage) GetGameState(ctx context.Context, gameID int64) (bool, bool, error) {
+	data, err := s.get(gameStateKey(gameID))
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get game state: %w", err)
+	}
+	if data == nil {
+		return false, false, nil
+	}
+
+	var gameActive, gameStarted bool
+	if _, err := fmt.Sscanf(string(data), "%t:%t", &gameActive, &gameStarted); err != nil {
+		return false, false, fmt.Errorf("failed to parse game state: %w", err)
+	}
+
+	return gameActive, gameStarted, nil
+}
+
+func (s *BoltStorage) ResetGameState(ctx context.Context, gameID int64) error {
+	return s.del(gameStateKey(gameID))
+}
+
+func (s *BoltStorage) SaveGameSettings(ctx context.Context, gameID int64, settings *domain.GameSettings) error {
+	if err := s.putJSON(gameSettingsKey(gameID), settings); err != nil {
+		return fmt.Errorf("failed to save game settings: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetGameSettings(ctx context.Context, gameID int64) (*domain.GameSettings, error) {
+	var settings domain.GameSettings
+	found, err := s.getJSON(gameSettingsKey(gameID), &settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game settings: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &settings, nil
+}
+
+func (s *BoltStorage) SaveDrawRecord(ctx context.Context, gameID int64, r *domain.DrawRecord) error {
+	if err := s.putJSON(drawRecordKey(gameID), r); err != nil {
+		return fmt.Errorf("failed to save draw record: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetDrawRecord(ctx context.Context, gameID int64) (*domain.DrawRecord, error) {
+	var r domain.DrawRecord
+	found, err := s.getJSON(drawRecordKey(gameID), &r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get draw record: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (s *BoltStorage) SaveEntropy(ctx context.Context, gameID, userID int64, e *domain.Entropy) error {
+	if err := s.putJSON(fieldKey(entropyKey(gameID), strconv.FormatInt(userID, 10)), e); err != nil {
+		return fmt.Errorf("failed to save entropy: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetAllEntropy(ctx context.Context, gameID int64) (map[int64]*domain.Entropy, error) {
+	prefix := entropyKey(gameID) + ":"
+	entropy := make(map[int64]*domain.Entropy)
+	err := s.scan(prefix, func(key string, value []byte) {
+		if userID, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64); err == nil {
+			entropy[userID] = decodeEntropy(value)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entropy: %w", err)
+	}
+	return entropy, nil
+}
+
+func (s *BoltStorage) SaveRevealSettings(ctx context.Context, gameID int64, r *domain.RevealSettings) error {
+	if err := s.putJSON(revealSettingsKey(gameID), r); err != nil {
+		return fmt.Errorf("failed to save reveal settings: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetRevealSettings(ctx context.Context, gameID int64) (*domain.RevealSettings, error) {
+	var r domain.RevealSettings
+	found, err := s.getJSON(revealSettingsKey(gameID), &r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reveal settings: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (s *BoltStorage) appendList(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(listItemKey(key, seq)), data)
+	})
+}
+
+func (s *BoltStorage) SaveSeason(ctx context.Context, season *domain.Season) error {
+	if err := s.appendList(historyKey(season.GameID), season); err != nil {
+		return fmt.Errorf("failed to save season: %w", err)
+	}
+	return nil
+}
+
+// GetSeasons возвращает сезоны от новых к старым, как LPUSH/LRANGE в Redis.
+func (s *BoltStorage) GetSeasons(ctx context.Context, gameID int64) ([]*domain.Season, error) {
+	seasons := []*domain.Season{}
+	err := s.scan(historyKey(gameID)+":", func(key string, value []byte) {
+		var season domain.Season
+		if err := json.Unmarshal(value, &season); err == nil {
+			seasons = append(seasons, &season)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+
+	for i, j := 0, len(seasons)-1; i < j; i, j = i+1, j-1 {
+		seasons[i], seasons[j] = seasons[j], seasons[i]
+	}
+	return seasons, nil
+}
+
+func (s *BoltStorage) ClearGame(ctx context.Context, gameID int64) error {
+	preserved := map[string]bool{
+		gameInfoKey(gameID):     true,
+		gameSettingsKey(gameID): true,
+	}
+	member := strconv.FormatInt(gameID, 10)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+
+		var userIDs []int64
+		scanPrefix(b, gameKeyPrefix(gameID)+"participant:", func(_ string, value []byte) bool {
+			var p domain.Participant
+			if err := json.Unmarshal(value, &p); err == nil {
+				userIDs = append(userIDs, p.UserID)
+			}
+			return true
+		})
+		for _, userID := range userIDs {
+			if err := b.Delete([]byte(fieldKey(userGamesKey(userID), member))); err != nil {
+				return fmt.Errorf("failed to remove game from user: %w", err)
+			}
+		}
+
+		if err := deletePrefix(b, gameKeyPrefix(gameID), func(key string) bool { return preserved[key] }); err != nil {
+			return fmt.Errorf("failed to delete game keys: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) SaveRelayRoute(ctx context.Context, chatID int64, messageID int, route *domain.RelayRoute) error {
+	return s.put(fieldKey(relayRoutesKey(route.GameID), relayRouteField(chatID, messageID)), []byte(route.Role))
+}
+
+func (s *BoltStorage) GetRelayRole(ctx context.Context, gameID, chatID int64, messageID int) (domain.RelayRole, error) {
+	data, err := s.get(fieldKey(relayRoutesKey(gameID), relayRouteField(chatID, messageID)))
+	if err != nil {
+		return "", fmt.Errorf("failed to get relay route: %w", err)
+	}
+	return domain.RelayRole(data), nil
+}
+
+func (s *BoltStorage) SaveRelayMessage(ctx context.Context, m *domain.RelayMessage) error {
+	if err := s.appendList(relayLogKey(m.GameID), m); err != nil {
+		return fmt.Errorf("failed to save relay message: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetRelayMessages(ctx context.Context, gameID int64) ([]*domain.RelayMessage, error) {
+	messages := []*domain.RelayMessage{}
+	err := s.scan(relayLogKey(gameID)+":", func(key string, value []byte) {
+		var m domain.RelayMessage
+		if err := json.Unmarshal(value, &m); err == nil {
+			messages = append(messages, &m)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get relay messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *BoltStorage) SaveWish(ctx context.Context, gameID, userID int64, wish string) error {
+	return s.put(wishKey(gameID, userID), []byte(wish))
+}
+
+func (s *BoltStorage) GetWish(ctx context.Context, gameID, userID int64) (string, error) {
+	data, err := s.get(wishKey(gameID, userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to get wish: %w", err)
+	}
+	return string(data), nil
+}
+
+func (s *BoltStorage) DeleteWish(ctx context.Context, gameID, userID int64) error {
+	return s.del(wishKey(gameID, userID))
+}
+
+func (s *BoltStorage) SaveTriggerMessage(ctx context.Context, triggerWord, message string) error {
+	existing, err := s.GetTriggerMessages(ctx, triggerWord)
+	if err != nil {
+		return fmt.Errorf("failed to get existing messages: %w", err)
+	}
+
+	for _, msg := range existing {
+		if msg == message {
+			return nil
+		}
+	}
+
+	if err := s.putJSON(triggerMessagesKey(triggerWord), append(existing, message)); err != nil {
+		return fmt.Errorf("failed to save trigger messages: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetTriggerMessages(ctx context.Context, triggerWord string) ([]string, error) {
+	messages := []string{}
+	if _, err := s.getJSON(triggerMessagesKey(triggerWord), &messages); err != nil {
+		return nil, fmt.Errorf("failed to get trigger messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *BoltStorage) GetAllTriggerWords(ctx context.Context) ([]string, error) {
+	triggerWords := []string{}
+	err := s.scan("trigger_messages:", func(key string, _ []byte) {
+		if triggerWord := strings.TrimPrefix(key, "trigger_messages:"); triggerWord != "" {
+			triggerWords = append(triggerWords, triggerWord)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trigger words: %w", err)
+	}
+	return triggerWords, nil
+}
+
+func (s *BoltStorage) DeleteTriggerMessage(ctx context.Context, triggerWord, message string) error {
+	existing, err := s.GetTriggerMessages(ctx, triggerWord)
+	if err != nil {
+		return fmt.Errorf("failed to get existing messages: %w", err)
+	}
+
+	var updated []string
+	for _, msg := range existing {
+		if msg != message {
+			updated = append(updated, msg)
+		}
+	}
+
+	if len(updated) == 0 {
+		return s.del(triggerMessagesKey(triggerWord))
+	}
+	if err := s.putJSON(triggerMessagesKey(triggerWord), updated); err != nil {
+		return fmt.Errorf("failed to save trigger messages: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) SaveComment(ctx context.Context, gameID, receiverID, authorID int64, comment string) error {
+	return s.put(commentKey(gameID, receiverID, authorID), []byte(comment))
+}
+
+func (s *BoltStorage) GetComments(ctx context.Context, gameID, receiverID int64) (map[int64]string, error) {
+	prefix := gameKeyPrefix(gameID)
+	comments := make(map[int64]string)
+	err := s.scan(fmt.Sprintf("%scomment:%d:", prefix, receiverID), func(key string, value []byte) {
+		var keyReceiverID, authorID int64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(key, prefix), "comment:%d:%d", &keyReceiverID, &authorID); err == nil {
+			comments[authorID] = string(value)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments: %w", err)
+	}
+	return comments, nil
+}
+
+func (s *BoltStorage) DeleteComment(ctx context.Context, gameID, receiverID, authorID int64) error {
+	return s.del(commentKey(gameID, receiverID, authorID))
+}
+
+// EnqueueOutbound держит рядом с сообщением запись очереди, упорядоченную по NotBefore,
+// и при повторной постановке убирает прежнюю запись.
+func (s *BoltStorage) EnqueueOutbound(ctx context.Context, m *domain.OutboundMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to serialize outbound message: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		key := []byte(outboxMessageKey(m.ID))
+		if previous := b.Get(key); previous != nil {
+			var old domain.OutboundMessage
+			if err := json.Unmarshal(previous, &old); err == nil {
+				if err := b.Delete([]byte(outboxQueueItemKey(&old))); err != nil {
+					return err
+				}
+			}
+		}
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+		return b.Put([]byte(outboxQueueItemKey(m)), []byte(m.ID))
+	})
+}
+
+func (s *BoltStorage) EnqueueOutboundBatch(ctx context.Context, batch *domain.OutboundBatch, deliveries []*domain.Delivery, messages []*domain.OutboundMessage) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		put := func(key string, v interface{}) error {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(key), data)
+		}
+
+		if err := put(outboxBatchKey(batch.ID), batch); err != nil {
+			return err
+		}
+		for _, d := range deliveries {
+			if err := put(fieldKey(outboxDeliveriesKey(batch.ID), strconv.FormatInt(d.ChatID, 10)), d); err != nil {
+				return err
+			}
+		}
+		for _, m := range messages {
+			if err := put(outboxMessageKey(m.ID), m); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(outboxQueueItemKey(m)), []byte(m.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue outbound batch: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetDueOutbound(ctx context.Context, now time.Time, limit int) ([]*domain.OutboundMessage, error) {
+	prefix := outboxQueueKey + ":"
+	messages := make([]*domain.OutboundMessage, 0, limit)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		scanPrefix(b, prefix, func(key string, id []byte) bool {
+			var score int64
+			if _, err := fmt.Sscanf(strings.TrimPrefix(key, prefix), "%d:", &score); err != nil {
+				return true
+			}
+			if score > now.UnixMilli() || len(messages) >= limit {
+				return false
+			}
+
+			data := b.Get([]byte(outboxMessageKey(string(id))))
+			if data == nil {
+				return true
+			}
+			var m domain.OutboundMessage
+			if err := json.Unmarshal(data, &m); err == nil {
+				messages = append(messages, &m)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbound queue: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *BoltStorage) RescheduleOutbound(ctx context.Context, m *domain.OutboundMessage) error {
+	return s.EnqueueOutbound(ctx, m)
+}
+
+func (s *BoltStorage) DeleteOutbound(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		key := []byte(outboxMessageKey(id))
+		if data := b.Get(key); data != nil {
+			var m domain.OutboundMessage
+			if err := json.Unmarshal(data, &m); err == nil {
+				if err := b.Delete([]byte(outboxQueueItemKey(&m))); err != nil {
+					return err
+				}
+			}
+		}
+		return b.Delete(key)
+	})
+}
+
+func (s *BoltStorage) SaveOutboundBatch(ctx context.Context, b *domain.OutboundBatch) error {
+	if err := s.putJSON(outboxBatchKey(b.ID), b); err != nil {
+		return fmt.Errorf("failed to save outbound batch: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetOutboundBatch(ctx context.Context, id string) (*domain.OutboundBatch, error) {
+	var b domain.OutboundBatch
+	found, err := s.getJSON(outboxBatchKey(id), &b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbound batch: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+func (s *BoltStorage) DeleteOutboundBatch(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if err := b.Delete([]byte(outboxBatchKey(id))); err != nil {
+			return err
+		}
+		return deletePrefix(b, outboxDeliveriesKey(id)+":", nil)
+	})
+}
+
+func (s *BoltStorage) SaveDelivery(ctx context.Context, batchID string, d *domain.Delivery) error {
+	if err := s.putJSON(fieldKey(outboxDeliveriesKey(batchID), strconv.FormatInt(d.ChatID, 10)), d); err != nil {
+		return fmt.Errorf("failed to save delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStorage) GetDeliveries(ctx context.Context, batchID string) ([]*domain.Delivery, error) {
+	deliveries := []*domain.Delivery{}
+	err := s.scan(outboxDeliveriesKey(batchID)+":", func(key string, value []byte) {
+		var d domain.Delivery
+		if err := json.Unmarshal(value, &d); err == nil {
+			deliveries = append(deliveries, &d)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ChatID < deliveries[j].ChatID })
+	return deliveries, nil
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
@@ -1,15 +1,18 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
-	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"telegram-secret-santa/internal/domain"
+	"telegram-secret-santa/internal/fairness"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -38,19 +41,20 @@ func escapeMarkdown(text string) string {
 }
 
 type SecretSantaBot struct {
-	Bot          *tgbotapi.BotAPI
+	Bot          Messenger
+	Self         tgbotapi.User
 	Storage      domain.StorageInterface
+	Outbox       *Outbox
 	Admins       map[string]bool
 	TriggerWords []string
 	UserTriggers map[int64][]string
-}
 
-func NewSecretSantaBot(token string, admins []string, storage domain.StorageInterface, triggerWords []string) (*SecretSantaBot, error) {
-	bot, err := tgbotapi.NewBotAPI(token)
-	if err != nil {
-		return nil, err
-	}
+	triggersMu  sync.RWMutex
+	gameLocksMu sync.Mutex
+	gameLocks   map[int64]*sync.Mutex
+}
 
+func NewSecretSantaBot(messenger Messenger, self tgbotapi.User, admins []string, storage domain.StorageInterface, triggerWords []string) *SecretSantaBot {
 	adminMap := make(map[string]bool)
 	for _, admin := range admins {
 		adminUsername := strings.TrimPrefix(admin, "@")
@@ -58,12 +62,53 @@ func NewSecretSantaBot(token string, admins []string, storage domain.StorageInte
 	}
 
 	return &SecretSantaBot{
-		Bot:          bot,
+		Bot:          messenger,
+		Self:         self,
 		Storage:      storage,
+		Outbox:       NewOutbox(storage, messenger),
 		Admins:       adminMap,
 		TriggerWords: triggerWords,
 		UserTriggers: make(map[int64][]string),
-	}, nil
+	}
+}
+
+// lockGame сериализует операции, меняющие распределение игры: обновления из разных
+// чатов обрабатываются параллельно, а одна игра доступна и из группы, и из личных сообщений.
+func (s *SecretSantaBot) lockGame(gameID int64) func() {
+	s.gameLocksMu.Lock()
+	if s.gameLocks == nil {
+		s.gameLocks = make(map[int64]*sync.Mutex)
+	}
+	lock, ok := s.gameLocks[gameID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.gameLocks[gameID] = lock
+	}
+	s.gameLocksMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (s *SecretSantaBot) userTriggers(userID int64) []string {
+	s.triggersMu.RLock()
+	defer s.triggersMu.RUnlock()
+	return append([]string(nil), s.UserTriggers[userID]...)
+}
+
+func (s *SecretSantaBot) addUserTrigger(userID int64, triggerWord string) bool {
+	s.triggersMu.Lock()
+	defer s.triggersMu.Unlock()
+	if s.UserTriggers == nil {
+		s.UserTriggers = make(map[int64][]string)
+	}
+	for _, existing := range s.UserTriggers[userID] {
+		if existing == triggerWord {
+			return false
+		}
+	}
+	s.UserTriggers[userID] = append(s.UserTriggers[userID], triggerWord)
+	return true
 }
 
 func (s *SecretSantaBot) IsAdmin(username string) bool {
@@ -74,16 +119,16 @@ func (s *SecretSantaBot) IsAdmin(username string) bool {
 	return s.Admins[usernameLower]
 }
 
-func (s *SecretSantaBot) AddParticipant(userID int64, username, fullName string) error {
+func (s *SecretSantaBot) AddParticipant(ctx context.Context, gameID, userID int64, username, fullName string) error {
 	p := &domain.Participant{
 		UserID:   userID,
 		Username: username,
 		FullName: fullName,
 	}
-	return s.Storage.SaveParticipant(p)
+	return s.Storage.SaveParticipant(ctx, gameID, p)
 }
 
-func (s *SecretSantaBot) SaveUserInfo(user *tgbotapi.User) {
+func (s *SecretSantaBot) SaveUserInfo(ctx context.Context, user *tgbotapi.User) {
 	if user == nil || user.ID == 0 {
 		return
 	}
@@ -92,15 +137,20 @@ func (s *SecretSantaBot) SaveUserInfo(user *tgbotapi.User) {
 		fullName += " " + user.LastName
 	}
 	if user.UserName != "" {
-		existing, _ := s.Storage.GetParticipant(user.ID)
+		existing, _ := s.Storage.GetUser(ctx, user.ID)
 		if existing == nil {
-			s.AddParticipant(user.ID, user.UserName, fullName)
+			s.Storage.SaveUser(ctx, &domain.Participant{
+				UserID:   user.ID,
+				Username: user.UserName,
+				FullName: fullName,
+			})
 			log.Printf("SaveUserInfo: saved user info userID=%d, username=%s, fullName=%s", user.ID, user.UserName, fullName)
 		} else {
 			if existing.Username != user.UserName || existing.FullName != fullName {
 				existing.Username = user.UserName
 				existing.FullName = fullName
-				s.Storage.SaveParticipant(existing)
+				s.Storage.SaveUser(ctx, existing)
+				s.syncParticipantInfo(ctx, existing)
 				log.Printf("SaveUserInfo: updated user info userID=%d, username=%s, fullName=%s", user.ID, user.UserName, fullName)
 			}
 		}
@@ -109,40 +159,76 @@ func (s *SecretSantaBot) SaveUserInfo(user *tgbotapi.User) {
 	}
 }
 
-func (s *SecretSantaBot) RemoveParticipant(userID int64) error {
-	if err := s.Storage.DeleteParticipant(userID); err != nil {
-		return err
+func (s *SecretSantaBot) syncParticipantInfo(ctx context.Context, user *domain.Participant) {
+	gameIDs, err := s.Storage.GetUserGames(ctx, user.UserID)
+	if err != nil {
+		log.Printf("syncParticipantInfo: failed to get games for userID=%d: %v", user.UserID, err)
+		return
 	}
+	for _, gameID := range gameIDs {
+		p, err := s.Storage.GetParticipant(ctx, gameID, user.UserID)
+		if err != nil || p == nil {
+			continue
+		}
+		if p.Username != user.Username || p.FullName != user.FullName {
+			p.Username = user.Username
+			p.FullName = user.FullName
+			s.Storage.SaveParticipant(ctx, gameID, p)
+		}
+	}
+}
 
-	if err := s.Storage.DeleteAllRestrictionsForUser(userID); err != nil {
-		return err
+func (s *SecretSantaBot) RemoveParticipant(ctx context.Context, gameID, userID int64) (*RepairReport, error) {
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.Storage.DeleteAssignment(userID); err != nil {
-		return err
+	if err := s.Storage.DeleteParticipant(ctx, gameID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.Storage.DeleteAllRestrictionsForUser(ctx, gameID, userID); err != nil {
+		return nil, err
 	}
 
-	restrictions, _, err := s.Storage.GetAllRestrictions()
+	if err := s.Storage.DeleteAssignment(ctx, gameID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.removeFromGroups(ctx, gameID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.removePreferencesForUser(ctx, gameID, userID); err != nil {
+		return nil, err
+	}
+
+	restrictions, _, err := s.Storage.GetAllRestrictions(ctx, gameID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for otherUserID, userRestrictions := range restrictions {
 		for forbiddenID := range userRestrictions {
 			if forbiddenID == userID {
-				if err := s.Storage.DeleteRestriction(otherUserID, userID); err != nil {
-					return err
+				if err := s.Storage.DeleteRestriction(ctx, gameID, otherUserID, userID); err != nil {
+					return nil, err
 				}
 			}
 		}
 	}
 
-	return nil
+	if len(assignments) == 0 {
+		return &RepairReport{}, nil
+	}
+	delete(assignments, userID)
+	return s.repairAssignments(ctx, gameID, assignments, nil, true)
 }
 
-func (s *SecretSantaBot) AddRestriction(userID, forbiddenUserID, creatorID int64) error {
-	log.Printf("AddRestriction: saving to Redis - userID=%d, forbiddenUserID=%d, creatorID=%d", userID, forbiddenUserID, creatorID)
-	err := s.Storage.SaveRestriction(userID, forbiddenUserID, creatorID)
+func (s *SecretSantaBot) AddRestriction(ctx context.Context, gameID, userID, forbiddenUserID, creatorID int64) error {
+	log.Printf("AddRestriction: saving to Redis - gameID=%d, userID=%d, forbiddenUserID=%d, creatorID=%d", gameID, userID, forbiddenUserID, creatorID)
+	err := s.Storage.SaveRestriction(ctx, gameID, userID, forbiddenUserID, creatorID)
 	if err != nil {
 		log.Printf("AddRestriction: failed to save to Redis: %v", err)
 		return err
@@ -151,9 +237,9 @@ func (s *SecretSantaBot) AddRestriction(userID, forbiddenUserID, creatorID int64
 	return nil
 }
 
-func (s *SecretSantaBot) RemoveRestriction(userID, forbiddenUserID int64) error {
-	log.Printf("RemoveRestriction: deleting from Redis - userID=%d, forbiddenUserID=%d", userID, forbiddenUserID)
-	err := s.Storage.DeleteRestriction(userID, forbiddenUserID)
+func (s *SecretSantaBot) RemoveRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) error {
+	log.Printf("RemoveRestriction: deleting from Redis - gameID=%d, userID=%d, forbiddenUserID=%d", gameID, userID, forbiddenUserID)
+	err := s.Storage.DeleteRestriction(ctx, gameID, userID, forbiddenUserID)
 	if err != nil {
 		log.Printf("RemoveRestriction: failed to delete from Redis: %v", err)
 		return err
@@ -162,196 +248,169 @@ func (s *SecretSantaBot) RemoveRestriction(userID, forbiddenUserID int64) error
 	return nil
 }
 
-func (s *SecretSantaBot) GenerateAssignments() error {
-	participants, err := s.Storage.GetAllParticipants()
-	if err != nil {
-		return fmt.Errorf("failed to get participants: %w", err)
-	}
+type GenerationReport struct {
+	Repeats              int
+	PreferenceViolations int
+	Preferences          int
+}
 
-	if len(participants) < 2 {
-		return fmt.Errorf("at least 2 participants required")
-	}
+type drawConstraints struct {
+	settings     *domain.GameSettings
+	restrictions map[int64]map[int64]bool
+	history      map[int64]map[int64]int
+	preferences  map[int64]map[int64]domain.PreferenceKind
+}
 
-	restrictions, _, err := s.Storage.GetAllRestrictions()
+func (s *SecretSantaBot) loadDrawConstraints(ctx context.Context, gameID int64) (*drawConstraints, error) {
+	restrictions, _, err := s.Storage.GetAllRestrictions(ctx, gameID)
 	if err != nil {
-		return fmt.Errorf("failed to get restrictions: %w", err)
+		return nil, fmt.Errorf("failed to get restrictions: %w", err)
 	}
-	log.Printf("GenerateAssignments: loaded %d user restrictions from Redis", len(restrictions))
 	totalRestrictions := 0
 	for _, userRestrictions := range restrictions {
 		totalRestrictions += len(userRestrictions)
 	}
-	log.Printf("GenerateAssignments: total restrictions count: %d", totalRestrictions)
+	log.Printf("loadDrawConstraints: loaded %d restrictions for %d users", totalRestrictions, len(restrictions))
 
-	participantIDs := make([]int64, 0, len(participants))
-	for id := range participants {
-		participantIDs = append(participantIDs, id)
+	groups, err := s.Storage.GetAllGroups(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	if len(groups) > 0 {
+		restrictions = mergeRestrictions(restrictions, groupPairs(groups))
+		log.Printf("loadDrawConstraints: applied %d groups", len(groups))
 	}
 
-	maxAttempts := 1000
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		receivers := make([]int64, len(participantIDs))
-		copy(receivers, participantIDs)
-		rand.Shuffle(len(receivers), func(i, j int) {
-			receivers[i], receivers[j] = receivers[j], receivers[i]
-		})
-
-		valid := true
-		assignments := make(map[int64]int64)
-
-		for i, giverID := range participantIDs {
-			receiverID := receivers[i]
+	settings := s.getGameSettings(ctx, gameID)
+	history, err := s.recentPairings(ctx, gameID, settings.HistorySeasons)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+	if settings.HistoryHard {
+		restrictions = mergeRestrictions(restrictions, history)
+		history = nil
+	}
+	log.Printf("loadDrawConstraints: avoiding %d pairs from last %d seasons (hard=%t)", countPairs(history), settings.HistorySeasons, settings.HistoryHard)
 
-			if giverID == receiverID {
-				valid = false
-				break
-			}
+	preferences, err := s.Storage.GetAllPreferences(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
 
-			if userRestrictions, ok := restrictions[giverID]; ok && userRestrictions[receiverID] {
-				valid = false
-				break
-			}
+	return &drawConstraints{
+		settings:     settings,
+		restrictions: restrictions,
+		history:      history,
+		preferences:  preferences,
+	}, nil
+}
 
-			assignments[giverID] = receiverID
-		}
+func (c *drawConstraints) solver(participantIDs []int64) *assignmentSolver {
+	solver := newAssignmentSolver(participantIDs, c.restrictions)
+	solver.setPenalties(addPenalties(preferencePenalties(c.preferences, participantIDs), c.history))
+	return solver
+}
 
-		if valid {
-			log.Printf("GenerateAssignments: valid assignment found on attempt %d", attempt+1)
+func (s *SecretSantaBot) GenerateAssignments(ctx context.Context, gameID int64) (*GenerationReport, error) {
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participants: %w", err)
+	}
 
-			var logBuilder strings.Builder
-			logBuilder.WriteString("\n")
-			logBuilder.WriteString("═══════════════════════════════════════════════════════════\n")
-			logBuilder.WriteString("🎅 РАСПРЕДЕЛЕНИЕ ТАЙНОГО САНТЫ 🎅\n")
-			logBuilder.WriteString("═══════════════════════════════════════════════════════════\n")
-			logBuilder.WriteString("\n")
+	if len(participants) < 2 {
+		return nil, fmt.Errorf("at least 2 participants required")
+	}
 
-			for giverID, receiverID := range assignments {
-				if err := s.Storage.SaveAssignment(giverID, receiverID); err != nil {
-					return fmt.Errorf("failed to save assignment: %w", err)
-				}
+	participantIDs := make([]int64, 0, len(participants))
+	for id := range participants {
+		participantIDs = append(participantIDs, id)
+	}
+	sort.Slice(participantIDs, func(i, j int) bool { return participantIDs[i] < participantIDs[j] })
 
-				giver, err := s.Storage.GetParticipant(giverID)
-				receiver, err2 := s.Storage.GetParticipant(receiverID)
-				if err == nil && err2 == nil && giver != nil && receiver != nil {
-					giverName := giver.FullName
-					if giver.Username != "" {
-						giverName += " (@" + giver.Username + ")"
-					}
-					receiverName := receiver.FullName
-					if receiver.Username != "" {
-						receiverName += " (@" + receiver.Username + ")"
-					}
-					logBuilder.WriteString(fmt.Sprintf("  🎁 %s\n", giverName))
-					logBuilder.WriteString(fmt.Sprintf("     └─> дарит подарок: %s\n", receiverName))
-
-					receiverWish, err3 := s.Storage.GetWish(receiverID)
-					if err3 == nil && receiverWish != "" {
-						logBuilder.WriteString(fmt.Sprintf("        💝 Желание: %s\n", receiverWish))
-					} else {
-						logBuilder.WriteString("        💝 Желание: не указано\n")
-					}
+	constraints, err := s.loadDrawConstraints(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	settings := constraints.settings
+	log.Printf("GenerateAssignments: using assignment mode %s", settings.Mode)
 
-					comments, err4 := s.Storage.GetComments(receiverID)
-					if err4 == nil && len(comments) > 0 {
-						logBuilder.WriteString("        💬 Комментарии от участников:\n")
-						allParticipants, _ := s.Storage.GetAllParticipants()
-						for authorID, comment := range comments {
-							author, ok := allParticipants[authorID]
-							if ok && author != nil {
-								authorName := author.FullName
-								if author.Username != "" {
-									authorName += " (@" + author.Username + ")"
-								}
-								logBuilder.WriteString(fmt.Sprintf("          👤 %s: %s\n", authorName, comment))
-							} else {
-								logBuilder.WriteString(fmt.Sprintf("          👤 Участник (ID: %d): %s\n", authorID, comment))
-							}
-						}
-					}
+	seed, err := s.drawSeed(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to choose seed: %w", err)
+	}
+	seedValue, err := fairness.SeedValue(seed)
+	if err != nil {
+		return nil, err
+	}
+	solver := constraints.solver(participantIDs)
+	solver.setSeed(seedValue)
 
-					logBuilder.WriteString(fmt.Sprintf("        (ID: %d -> %d)\n", giverID, receiverID))
-					logBuilder.WriteString("\n")
-				} else {
-					logBuilder.WriteString(fmt.Sprintf("  🎁 userID:%d\n", giverID))
-					logBuilder.WriteString(fmt.Sprintf("     └─> дарит подарок: userID:%d\n", receiverID))
-
-					receiverWish, err3 := s.Storage.GetWish(receiverID)
-					if err3 == nil && receiverWish != "" {
-						logBuilder.WriteString(fmt.Sprintf("        💝 Желание: %s\n", receiverWish))
-					} else {
-						logBuilder.WriteString("        💝 Желание: не указано\n")
-					}
+	assignments, penalty, err := solver.solve(settings.Mode)
+	if err != nil {
+		log.Printf("GenerateAssignments: %v", err)
+		return nil, err
+	}
 
-					comments, err4 := s.Storage.GetComments(receiverID)
-					if err4 == nil && len(comments) > 0 {
-						logBuilder.WriteString("        💬 Комментарии от участников:\n")
-						allParticipants, _ := s.Storage.GetAllParticipants()
-						for authorID, comment := range comments {
-							author, ok := allParticipants[authorID]
-							if ok && author != nil {
-								authorName := author.FullName
-								if author.Username != "" {
-									authorName += " (@" + author.Username + ")"
-								}
-								logBuilder.WriteString(fmt.Sprintf("          👤 %s: %s\n", authorName, comment))
-							} else {
-								logBuilder.WriteString(fmt.Sprintf("          👤 Участник (ID: %d): %s\n", authorID, comment))
-							}
-						}
-					}
+	report := &GenerationReport{}
+	for giverID, receiverID := range assignments {
+		report.Repeats += constraints.history[giverID][receiverID]
+	}
+	report.PreferenceViolations, report.Preferences = preferenceScore(constraints.preferences, assignments)
+	log.Printf("GenerateAssignments: total penalty %d, repeats %d, preference violations %d of %d",
+		penalty, report.Repeats, report.PreferenceViolations, report.Preferences)
 
-					logBuilder.WriteString("\n")
-				}
-			}
+	log.Printf("GenerateAssignments: valid assignment found for %d participants", len(assignments))
 
-			logBuilder.WriteString("═══════════════════════════════════════════════════════════\n")
-			logBuilder.WriteString("✅ Все назначения успешно сохранены в Redis\n")
-			logBuilder.WriteString("═══════════════════════════════════════════════════════════\n")
+	if err := s.Storage.DeleteAllAssignments(ctx, gameID); err != nil {
+		return nil, fmt.Errorf("failed to clear previous assignments: %w", err)
+	}
 
-			log.Printf("%s", logBuilder.String())
-			return nil
+	for giverID, receiverID := range assignments {
+		if err := s.Storage.SaveAssignment(ctx, gameID, giverID, receiverID); err != nil {
+			return nil, fmt.Errorf("failed to save assignment: %w", err)
 		}
 	}
 
-	return fmt.Errorf("failed to create assignment with current restrictions")
+	if _, err := s.recordDraw(ctx, gameID, seed, "assignments generated", "🎲 Распределение создано."); err != nil {
+		return nil, err
+	}
+	return report, nil
 }
 
-func (s *SecretSantaBot) SendAssignment(userID int64) error {
-	receiverID, err := s.Storage.GetAssignment(userID)
+func (s *SecretSantaBot) assignmentMessage(ctx context.Context, gameID, userID int64) (string, error) {
+	receiverID, err := s.Storage.GetAssignment(ctx, gameID, userID)
 	if err != nil {
-		return fmt.Errorf("failed to get assignment: %w", err)
+		return "", fmt.Errorf("failed to get assignment: %w", err)
 	}
 	if receiverID == 0 {
-		return fmt.Errorf("assignment not found")
+		return "", fmt.Errorf("assignment not found")
 	}
 
-	receiver, err := s.Storage.GetParticipant(receiverID)
+	receiver, err := s.Storage.GetParticipant(ctx, gameID, receiverID)
 	if err != nil {
-		return fmt.Errorf("failed to get participant: %w", err)
+		return "", fmt.Errorf("failed to get participant: %w", err)
 	}
 	if receiver == nil {
-		return fmt.Errorf("participant not found")
+		return "", fmt.Errorf("participant not found")
 	}
 
-	message := fmt.Sprintf("🎅 Тайный Санта назначен!\n\n"+
-		"Вы дарите подарок: %s", receiver.FullName)
+	message := fmt.Sprintf("🎅 Тайный Санта назначен!\n"+
+		"🎄 Игра: %s\n\n"+
+		"Вы дарите подарок: %s", s.gameTitle(ctx, gameID), receiver.FullName)
 
 	if receiver.Username != "" {
 		message += fmt.Sprintf(" (@%s)", receiver.Username)
 	}
 
-	receiverWish, err := s.Storage.GetWish(receiverID)
+	receiverWish, err := s.Storage.GetWish(ctx, gameID, receiverID)
 	if err == nil && receiverWish != "" {
 		message += fmt.Sprintf("\n\n💝 Желание получателя:\n%s", receiverWish)
-		log.Printf("SendAssignment: sending message to userID=%d with wish for receiverID=%d", userID, receiverID)
-	} else {
-		log.Printf("SendAssignment: sending message to userID=%d without wish (receiverID=%d has no wish)", userID, receiverID)
 	}
 
-	comments, err := s.Storage.GetComments(receiverID)
+	comments, err := s.Storage.GetComments(ctx, gameID, receiverID)
 	if err == nil && len(comments) > 0 {
 		message += "\n\n💬 Комментарии от участников:"
-		participants, _ := s.Storage.GetAllParticipants()
+		participants, _ := s.Storage.GetAllParticipants(ctx, gameID)
 		for authorID, comment := range comments {
 			author, ok := participants[authorID]
 			if ok && author != nil {
@@ -364,89 +423,194 @@ func (s *SecretSantaBot) SendAssignment(userID int64) error {
 				message += fmt.Sprintf("\n\n👤 Участник (ID: %d):\n%s", authorID, comment)
 			}
 		}
-		log.Printf("SendAssignment: sending message to userID=%d with %d comments for receiverID=%d", userID, len(comments), receiverID)
 	}
 
-	msg := tgbotapi.NewMessage(userID, message)
-	_, err = s.Bot.Send(msg)
+	message += "\n\n🎁 Отмечайте, как продвигается подарок, кнопками ниже или командой /gift"
+
+	return message, nil
+}
+
+func (s *SecretSantaBot) SendAssignment(ctx context.Context, gameID, userID int64) error {
+	message, err := s.assignmentMessage(ctx, gameID, userID)
 	if err != nil {
-		log.Printf("SendAssignment: failed to send message to userID=%d: %v", userID, err)
-	} else {
-		log.Printf("SendAssignment: successfully sent message to userID=%d", userID)
+		return err
+	}
+
+	if err := s.Outbox.SendWithButtons(ctx, userID, message, giftButtons(gameID)); err != nil {
+		log.Printf("SendAssignment: failed to enqueue message for userID=%d: %v", userID, err)
+		return err
+	}
+	log.Printf("SendAssignment: queued assignment for userID=%d", userID)
+	return nil
+}
+
+func (s *SecretSantaBot) HandleUpdate(ctx context.Context, update tgbotapi.Update) {
+	if update.MyChatMember != nil {
+		s.handleMyChatMember(ctx, update.MyChatMember)
+	}
+	if update.CallbackQuery != nil {
+		s.HandleCallback(ctx, update.CallbackQuery)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+	if update.Message.From != nil {
+		s.SaveUserInfo(ctx, update.Message.From)
+		if update.Message.Chat.IsPrivate() {
+			s.markReachable(ctx, update.Message.From.ID)
+		}
+	}
+	if update.Message.Text != "" {
+		s.CheckTriggerWords(ctx, update.Message)
+	}
+	if update.Message.IsCommand() {
+		s.HandleCommand(ctx, update)
+	} else if s.HandleRelayReply(ctx, update.Message) {
+		return
+	} else if update.Message.ForwardFrom != nil {
+		s.HandleForwardedMessage(ctx, update.Message)
 	}
-	return err
 }
 
-func (s *SecretSantaBot) HandleCommand(update tgbotapi.Update) {
+func (s *SecretSantaBot) HandleCommand(ctx context.Context, update tgbotapi.Update) {
 	msg := update.Message
 	if msg != nil && msg.From != nil {
-		s.SaveUserInfo(msg.From)
+		s.SaveUserInfo(ctx, msg.From)
 	}
 	command := strings.ToLower(msg.Command())
 
 	switch command {
-	case "start", "help":
+	case "start":
+		s.handleStart(ctx, msg)
+
+	case "help":
 		s.sendHelpMessage(msg)
 
-	case "startgame":
-		s.handleSendAssignments(msg)
+	case "game", "games":
+		s.handleSelectGame(ctx, msg)
+
+	case "addtrigger":
+		s.handleAddTrigger(ctx, msg)
+
+	case "addtriggermessage":
+		s.handleAddTriggerMessage(ctx, msg)
+
+	case "startgame", "send", "add", "adduser", "remove", "list", "restrict", "unrestrict", "restrictions",
+		"generate", "reset", "status", "members", "wish", "mywish", "deletewish", "comment", "history", "group",
+		"prefer", "avoid", "unprefer", "preferences", "myassignment", "resend", "ask", "answer", "gift", "gotit", "reveal", "mysanta", "pairs", "entropy", "admit":
+		s.handleGameCommand(ctx, command, msg)
+
+	default:
+		s.sendMessage(ctx, msg.Chat.ID, "Неизвестная команда. Используйте /help для списка команд.")
+	}
+}
+
+func (s *SecretSantaBot) handleGameCommand(ctx context.Context, command string, msg *tgbotapi.Message) {
+	gameID, ok := s.resolveGame(ctx, msg)
+	if !ok {
+		return
+	}
+
+	switch command {
+	case "startgame", "send":
+		s.handleSendAssignments(ctx, msg, gameID)
 
 	case "add":
-		s.handleAddParticipant(msg)
+		s.handleAddParticipant(ctx, msg, gameID)
 
 	case "adduser":
-		s.handleAddUserByUsername(msg)
+		s.handleAddUserByUsername(ctx, msg, gameID)
 
 	case "remove":
-		s.handleRemoveParticipant(msg)
+		s.handleRemoveParticipant(ctx, msg, gameID)
 
 	case "list":
-		s.handleListParticipants(msg)
+		s.handleListParticipants(ctx, msg, gameID)
 
 	case "restrict":
-		s.handleAddRestriction(msg)
+		s.handleAddRestriction(ctx, msg, gameID)
 
 	case "unrestrict":
-		s.handleRemoveRestriction(msg)
+		s.handleRemoveRestriction(ctx, msg, gameID)
 
 	case "restrictions":
-		s.handleListRestrictions(msg)
+		s.handleListRestrictions(ctx, msg, gameID)
 
 	case "generate":
-		s.handleGenerate(msg)
-
-	case "send":
-		s.handleSendAssignments(msg)
+		s.handleGenerate(ctx, msg, gameID)
 
 	case "reset":
-		s.handleReset(msg)
+		s.handleReset(ctx, msg, gameID)
 
 	case "status":
-		s.handleStatus(msg)
+		s.handleStatus(ctx, msg, gameID)
 
 	case "members":
-		s.handleMembersCount(msg)
+		s.handleMembersCount(ctx, msg, gameID)
 
 	case "wish":
-		s.handleSetWish(msg)
+		s.handleSetWish(ctx, msg, gameID)
 
 	case "mywish":
-		s.handleGetWish(msg)
+		s.handleGetWish(ctx, msg, gameID)
 
 	case "deletewish":
-		s.handleDeleteWish(msg)
+		s.handleDeleteWish(ctx, msg, gameID)
 
-	case "addtrigger":
-		s.handleAddTrigger(msg)
+	case "comment":
+		s.handleAddComment(ctx, msg, gameID)
 
-	case "addtriggermessage":
-		s.handleAddTriggerMessage(msg)
+	case "history":
+		s.handleHistory(ctx, msg, gameID)
 
-	case "comment":
-		s.handleAddComment(msg)
+	case "group":
+		s.handleGroup(ctx, msg, gameID)
 
-	default:
-		s.sendMessage(msg.Chat.ID, "Неизвестная команда. Используйте /help для списка команд.")
+	case "prefer":
+		s.handleSetPreference(ctx, msg, gameID, domain.PreferenceLove)
+
+	case "avoid":
+		s.handleSetPreference(ctx, msg, gameID, domain.PreferenceAvoid)
+
+	case "unprefer":
+		s.handleRemovePreference(ctx, msg, gameID)
+
+	case "preferences":
+		s.handleListPreferences(ctx, msg, gameID)
+
+	case "myassignment":
+		s.handleMyAssignment(ctx, msg, gameID)
+
+	case "resend":
+		s.handleResend(ctx, msg, gameID)
+
+	case "ask":
+		s.handleRelayCommand(ctx, msg, gameID, domain.RelayRoleSanta)
+
+	case "answer":
+		s.handleRelayCommand(ctx, msg, gameID, domain.RelayRoleReceiver)
+
+	case "gift":
+		s.handleGift(ctx, msg, gameID)
+
+	case "gotit":
+		s.handleGotIt(ctx, msg, gameID)
+
+	case "reveal":
+		s.handleReveal(ctx, msg, gameID)
+
+	case "mysanta":
+		s.handleMySanta(ctx, msg, gameID)
+
+	case "pairs":
+		s.handlePairs(ctx, msg, gameID)
+
+	case "entropy":
+		s.handleEntropy(ctx, msg, gameID)
+
+	case "admit":
+		s.handleAdmit(ctx, msg, gameID)
 	}
 }
 
@@ -461,15 +625,28 @@ func (s *SecretSantaBot) sendHelpMessage(msg *tgbotapi.Message) {
 
 *Команды для всех:*
 
-/add - Добавить себя в игру
+/add - Добавить себя в игру (можно и после начала игры: вас встроят в уже созданное распределение)
 /adduser @username - Добавить участника по username (в группах - через упоминание, в личке - перешлите сообщение от пользователя)
-/remove - Удалить себя из игры
-/list - Список участников
+/remove - Удалить себя из игры (после начала игры - только с подтверждения администратора)
+/list - Список участников (администратор видит, кому бот может написать)
 /restrict @username - Добавить ограничение (вы не получите этого человека)
 /unrestrict @username - Удалить ограничение (только свои или админ может удалять любые)
 /restrictions - Показать все ограничения
+/group - Группы (семьи, команды): участники одной группы не дарят друг другу
+/prefer @username - Мягкое пожелание: хотели бы подарить этому участнику
+/avoid @username - Мягкое пожелание: лучше не дарить этому участнику
+/unprefer @username - Удалить пожелание
+/preferences - Показать ваши пожелания
+/myassignment - Еще раз прислать вашего получателя (только в личных сообщениях)
+/ask текст - Анонимно спросить что-нибудь у вашего получателя (в личных сообщениях)
+/answer текст - Ответить вашему Тайному Санте (или просто ответьте на его сообщение)
+/gift [bought|shipped|delivered] - Отметить, что подарок куплен, отправлен или доставлен
+/gotit - Подтвердить, что вы получили подарок
+/entropy текст - Внести вклад в зерно жеребьевки до /generate (в личных сообщениях)
+/mysanta - Узнать, кто был вашим Сантой (после раскрытия или с даты, назначенной администратором)
 /status - Показать статус игры
 /members - Показать количество участников в группе (только в группах)
+/game ID - Выбрать игру для команд в личных сообщениях (если вы участвуете в нескольких)
 /wish текст - Указать или изменить желание (что вы хотите получить от тайного санта)
 /mywish - Показать ваше текущее желание
 /deletewish - Удалить ваше желание
@@ -480,8 +657,23 @@ func (s *SecretSantaBot) sendHelpMessage(msg *tgbotapi.Message) {
 *Команды для администраторов:*
 
 /generate - Сгенерировать распределение
+/generate chain - Распределение одним общим кругом
+/generate nomutual - Распределение без взаимных пар
+/generate any - Вернуть обычный режим распределения
+/generate force - Создать распределение, даже если бот не может написать некоторым участникам
 /startgame или /send - Начать игру (отправить всем участникам их получателей)
-/reset - Сбросить игру
+/resend @username - Повторно отправить назначение одному участнику
+/pairs - Посмотреть распределение (только в личных сообщениях; просмотр записывается в журнал и объявляется в чате игры)
+/reveal now - Раскрыть все пары в чате игры
+/reveal ДД.ММ.ГГГГ [ЧЧ:ММ] - Запланировать раскрытие пар
+/reveal santa ДД.ММ.ГГГГ [ЧЧ:ММ] - Разрешить узнавать своего Санту через /mysanta с этой даты
+/remove @username - Удалить участника; распределение чинится, новые получатели приходят только тем, у кого они изменились
+/admit @username - Включить в распределение нового участника, если для этого нужно поменять получателей у других
+/reset - Сбросить игру (распределение начатой игры сохраняется в историю)
+/history - История прошлых сезонов
+/history avoid N [soft|hard] - Избегать повторов пар из последних N сезонов
+
+Каждый групповой чат ведет свою отдельную игру. В личных сообщениях команды относятся к игре, в которой вы участвуете.
 
 *Пример использования:*
 1. Участники добавляются через /add
@@ -494,15 +686,28 @@ func (s *SecretSantaBot) sendHelpMessage(msg *tgbotapi.Message) {
 
 *Команды:*
 
-/add - Добавить себя в игру
+/add - Добавить себя в игру (можно и после начала игры: вас встроят в уже созданное распределение)
 /adduser @username - Добавить участника по username (в группах - через упоминание, в личке - перешлите сообщение от пользователя)
-/remove - Удалить себя из игры
+/remove - Удалить себя из игры (после начала игры - только с подтверждения администратора)
 /list - Список участников
 /restrict @username - Добавить ограничение (вы не получите этого человека)
 /unrestrict @username - Удалить ограничение (только свои или админ может удалять любые)
 /restrictions - Показать все ограничения
+/group - Группы (семьи, команды): участники одной группы не дарят друг другу
+/prefer @username - Мягкое пожелание: хотели бы подарить этому участнику
+/avoid @username - Мягкое пожелание: лучше не дарить этому участнику
+/unprefer @username - Удалить пожелание
+/preferences - Показать ваши пожелания
+/myassignment - Еще раз прислать вашего получателя (только в личных сообщениях)
+/ask текст - Анонимно спросить что-нибудь у вашего получателя (в личных сообщениях)
+/answer текст - Ответить вашему Тайному Санте (или просто ответьте на его сообщение)
+/gift [bought|shipped|delivered] - Отметить, что подарок куплен, отправлен или доставлен
+/gotit - Подтвердить, что вы получили подарок
+/entropy текст - Внести вклад в зерно жеребьевки до /generate (в личных сообщениях)
+/mysanta - Узнать, кто был вашим Сантой (после раскрытия или с даты, назначенной администратором)
 /status - Показать статус игры
 /members - Показать количество участников в группе (только в группах)
+/game ID - Выбрать игру для команд в личных сообщениях (если вы участвуете в нескольких)
 /wish текст - Указать или изменить желание (что вы хотите получить от тайного санта)
 /mywish - Показать ваше текущее желание
 /deletewish - Удалить ваше желание
@@ -510,6 +715,8 @@ func (s *SecretSantaBot) sendHelpMessage(msg *tgbotapi.Message) {
 /addtriggermessage слово|сообщение - Добавить сообщение к триггерному слову (сообщения выбираются случайно)
 /comment @username текст - Добавить комментарий/подсказку для участника (что нужно дарить)
 
+Каждый групповой чат ведет свою отдельную игру. В личных сообщениях команды относятся к игре, в которой вы участвуете.
+
 *Пример использования:*
 1. Участники добавляются через /add
 2. Устанавливаются ограничения через /restrict @username
@@ -529,7 +736,7 @@ func (s *SecretSantaBot) sendHelpMessage(msg *tgbotapi.Message) {
 	}
 }
 
-func (s *SecretSantaBot) handleAddParticipant(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleAddParticipant(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
 	username := msg.From.UserName
 	fullName := msg.From.FirstName
@@ -537,17 +744,17 @@ func (s *SecretSantaBot) handleAddParticipant(msg *tgbotapi.Message) {
 		fullName += " " + msg.From.LastName
 	}
 
-	if err := s.AddParticipant(userID, username, fullName); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении: %v", err))
+	if err := s.AddParticipant(ctx, gameID, userID, username, fullName); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении: %v", err))
 		return
 	}
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Вы добавлены в игру, %s!", fullName))
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Вы добавлены в игру, %s!", fullName)+s.admissionNote(ctx, gameID, userID))
 }
 
-func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleAddUserByUsername(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	text := strings.TrimSpace(msg.CommandArguments())
 	if text == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Укажите username пользователя. Пример: /adduser @username")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите username пользователя. Пример: /adduser @username")
 		return
 	}
 
@@ -590,16 +797,16 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 	}
 
 	if targetUser == nil {
-		log.Printf("handleAddUserByUsername: trying to find user in saved participants by username=%s", username)
-		allParticipants, err := s.Storage.GetAllParticipants()
+		log.Printf("handleAddUserByUsername: trying to find user in saved users by username=%s", username)
+		allUsers, err := s.Storage.GetAllUsers(ctx)
 		if err != nil {
-			log.Printf("handleAddUserByUsername: failed to get saved participants: %v", err)
+			log.Printf("handleAddUserByUsername: failed to get saved users: %v", err)
 		} else {
-			log.Printf("handleAddUserByUsername: checking %d saved participants", len(allParticipants))
-			for userID, participant := range allParticipants {
-				log.Printf("handleAddUserByUsername: participant userID=%d, username=%s, comparing with %s", userID, participant.Username, username)
+			log.Printf("handleAddUserByUsername: checking %d saved users", len(allUsers))
+			for userID, participant := range allUsers {
+				log.Printf("handleAddUserByUsername: user userID=%d, username=%s, comparing with %s", userID, participant.Username, username)
 				if strings.EqualFold(participant.Username, username) {
-					log.Printf("handleAddUserByUsername: found user in saved participants userID=%d, username=%s", userID, participant.Username)
+					log.Printf("handleAddUserByUsername: found user in saved users userID=%d, username=%s", userID, participant.Username)
 					targetUser = &tgbotapi.User{
 						ID:        userID,
 						UserName:  participant.Username,
@@ -612,7 +819,7 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 				}
 			}
 			if targetUser == nil {
-				log.Printf("handleAddUserByUsername: user @%s not found in %d saved participants", username, len(allParticipants))
+				log.Printf("handleAddUserByUsername: user @%s not found in %d saved users", username, len(allUsers))
 			}
 		}
 	}
@@ -641,10 +848,10 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 
 	if targetUser != nil {
 		log.Printf("handleAddUserByUsername: user found, userID=%d, username=%s, checking if already participant", targetUser.ID, targetUser.UserName)
-		existing, err := s.Storage.GetParticipant(targetUser.ID)
+		existing, err := s.Storage.GetParticipant(ctx, gameID, targetUser.ID)
 		if err == nil && existing != nil {
 			log.Printf("handleAddUserByUsername: user already exists as participant")
-			s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s уже участвует в игре.", username))
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s уже участвует в игре.", username))
 			return
 		}
 
@@ -653,18 +860,18 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 			fullName += " " + targetUser.LastName
 		}
 		log.Printf("handleAddUserByUsername: adding participant userID=%d, username=%s, fullName=%s", targetUser.ID, targetUser.UserName, fullName)
-		if err := s.AddParticipant(targetUser.ID, targetUser.UserName, fullName); err != nil {
+		if err := s.AddParticipant(ctx, gameID, targetUser.ID, targetUser.UserName, fullName); err != nil {
 			log.Printf("handleAddUserByUsername: failed to add participant: %v", err)
-			s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении: %v", err))
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении: %v", err))
 			return
 		}
 		log.Printf("handleAddUserByUsername: successfully added participant userID=%d, username=%s", targetUser.ID, targetUser.UserName)
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Пользователь %s (@%s) добавлен в игру!", fullName, username))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Пользователь %s (@%s) добавлен в игру!", fullName, username)+s.admissionNote(ctx, gameID, targetUser.ID))
 		return
 	}
 
 	log.Printf("handleAddUserByUsername: user not found, checking existing participants")
-	participants, err := s.Storage.GetAllParticipants()
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
 		log.Printf("handleAddUserByUsername: failed to get participants: %v", err)
 	} else {
@@ -672,7 +879,7 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 		for _, participant := range participants {
 			if strings.EqualFold(participant.Username, username) {
 				log.Printf("handleAddUserByUsername: user found in existing participants, userID=%d", participant.UserID)
-				s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s уже участвует в игре.", username))
+				s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s уже участвует в игре.", username))
 				return
 			}
 		}
@@ -682,7 +889,11 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 
 	if msg.Chat.IsGroup() || msg.Chat.IsSuperGroup() {
 		errorMsg := fmt.Sprintf("❌ Не удалось найти пользователя @%s в группе.\n\n", username)
-		errorMsg += fmt.Sprintf("*Информация:*\n• Сохранено ботом: %d пользователей\n\n", len(participants))
+		savedCount := 0
+		if users, err := s.Storage.GetAllUsers(ctx); err == nil {
+			savedCount = len(users)
+		}
+		errorMsg += fmt.Sprintf("*Информация:*\n• Сохранено ботом: %d пользователей\n\n", savedCount)
 		errorMsg += "*Важно:* Telegram Bot API не позволяет получить список всех участников группы.\n\n"
 		errorMsg += fmt.Sprintf("*Как добавить участника:*\n"+
 			"1. *Выберите пользователя из списка:* Начните печатать @%s и выберите пользователя из предложенного списка (не просто напечатайте @username)\n"+
@@ -690,9 +901,9 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 			"3. Перешлите любое сообщение от пользователя @%s боту\n\n"+
 			"*Совет:* Самый надежный способ - выбрать пользователя из списка при упоминании (начните печатать @ и выберите из списка).\n\n"+
 			"Используйте /members чтобы посмотреть статистику группы.", username, username, username)
-		s.sendMessage(msg.Chat.ID, errorMsg)
+		s.sendMessage(ctx, msg.Chat.ID, errorMsg)
 	} else {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось найти пользователя @%s.\n\n"+
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Не удалось найти пользователя @%s.\n\n"+
 			"*Как добавить участника по username:*\n"+
 			"1. Перешлите любое сообщение от пользователя @%s боту\n"+
 			"2. Или попросите пользователя @%s написать боту /add\n\n"+
@@ -700,7 +911,7 @@ func (s *SecretSantaBot) handleAddUserByUsername(msg *tgbotapi.Message) {
 	}
 }
 
-func (s *SecretSantaBot) HandleForwardedMessage(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) HandleForwardedMessage(ctx context.Context, msg *tgbotapi.Message) {
 	if msg.ForwardFrom == nil {
 		return
 	}
@@ -746,9 +957,14 @@ func (s *SecretSantaBot) HandleForwardedMessage(msg *tgbotapi.Message) {
 		return
 	}
 
-	existing, err := s.Storage.GetParticipant(msg.ForwardFrom.ID)
+	gameID, ok := s.resolveGame(ctx, msg)
+	if !ok {
+		return
+	}
+
+	existing, err := s.Storage.GetParticipant(ctx, gameID, msg.ForwardFrom.ID)
 	if err == nil && existing != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s уже участвует в игре.", username))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s уже участвует в игре.", username))
 		return
 	}
 
@@ -756,46 +972,117 @@ func (s *SecretSantaBot) HandleForwardedMessage(msg *tgbotapi.Message) {
 	if msg.ForwardFrom.LastName != "" {
 		fullName += " " + msg.ForwardFrom.LastName
 	}
-	if err := s.AddParticipant(msg.ForwardFrom.ID, msg.ForwardFrom.UserName, fullName); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении: %v", err))
+	if err := s.AddParticipant(ctx, gameID, msg.ForwardFrom.ID, msg.ForwardFrom.UserName, fullName); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении: %v", err))
 		return
 	}
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Пользователь %s (@%s) добавлен в игру!", fullName, username))
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Пользователь %s (@%s) добавлен в игру!", fullName, username)+s.admissionNote(ctx, gameID, msg.ForwardFrom.ID))
 }
 
-func (s *SecretSantaBot) handleRemoveParticipant(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleRemoveParticipant(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	isAdmin := s.IsAdmin(msg.From.UserName)
+	arg := strings.TrimSpace(msg.CommandArguments())
+
 	userID := msg.From.ID
-	existing, err := s.Storage.GetParticipant(userID)
+	if arg != "" {
+		if !isAdmin {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Удалять других участников может только администратор. Чтобы выйти самому, используйте /remove без аргументов.")
+			return
+		}
+		participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+		if err != nil {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+			return
+		}
+		targetID, found := findParticipant(participants, arg)
+		if !found {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Участник %s не найден в игре.", arg))
+			return
+		}
+		userID = targetID
+	}
+
+	// Состояние игры читается под блокировкой: иначе /startgame или /reset между
+	// проверкой и удалением обошли бы запрет выходить из начатой игры.
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	existing, err := s.Storage.GetParticipant(ctx, gameID, userID)
 	if err != nil || existing == nil {
-		s.sendMessage(msg.Chat.ID, "❌ Вы не участвуете в игре.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Вы не участвуете в игре.")
+		return
+	}
+
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
+		return
+	}
+	if gameStarted && !isAdmin {
+		target := fmt.Sprintf("%d", userID)
+		if existing.Username != "" {
+			target = "@" + existing.Username
+		}
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Игра уже началась, и участникам разосланы получатели. "+
+			"Выйти из игры можно только с подтверждения администратора: попросите его выполнить /remove %s", target))
 		return
 	}
 
-	if err := s.RemoveParticipant(userID); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении: %v", err))
+	report, err := s.RemoveParticipant(ctx, gameID, userID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении: %v", err))
 		return
 	}
-	s.sendMessage(msg.Chat.ID, "✅ Вы удалены из игры.")
+	s.notifyChangedAssignments(ctx, gameID, report)
+
+	result := "✅ Вы удалены из игры."
+	if userID != msg.From.ID {
+		result = fmt.Sprintf("✅ Участник %s удален из игры.", existing.FullName)
+	}
+	if report.Failed {
+		result += fmt.Sprintf("\n\n⚠️ Перераспределить подарки без этого участника, не проводя жеребьевку заново, не удалось. "+
+			"Остальные пары сохранены, но у %d участников не осталось получателя, а их бывшие получатели остались без Санты. "+
+			"Администратор видит эти пары в /pairs (в личных сообщениях с ботом) и решает, проводить ли жеребьевку заново: /generate и /startgame.",
+			len(report.Orphaned))
+	} else if len(report.Changed) > 0 {
+		result += fmt.Sprintf("\n\n🔄 Распределение исправлено: получатель изменился у %d участников.", len(report.Changed))
+		if gameStarted {
+			result += " Им отправлены новые назначения."
+		}
+	}
+	s.sendMessage(ctx, msg.Chat.ID, result)
 }
 
-func (s *SecretSantaBot) handleListParticipants(msg *tgbotapi.Message) {
-	participants, err := s.Storage.GetAllParticipants()
+func (s *SecretSantaBot) handleListParticipants(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения списка участников: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения списка участников: %v", err))
 		return
 	}
 
 	if len(participants) == 0 {
-		s.sendMessage(msg.Chat.ID, "📝 Участников пока нет.")
+		s.sendMessage(ctx, msg.Chat.ID, "📝 Участников пока нет.")
 		return
 	}
 
+	var reachability map[int64]*domain.Reachability
+	showReachability := s.IsAdmin(msg.From.UserName)
+	if showReachability {
+		reachability = s.participantsReachability(ctx, participants)
+	}
+	icon := func(userID int64) string {
+		if !showReachability {
+			return ""
+		}
+		return reachabilityIcon(reachability[userID]) + " "
+	}
+
 	var list strings.Builder
 	list.WriteString("📝 *Участники:*\n\n")
 	index := 1
-	for _, p := range participants {
+	for userID, p := range participants {
 		escapedName := escapeMarkdown(p.FullName)
-		list.WriteString(fmt.Sprintf("%d\\. %s", index, escapedName))
+		list.WriteString(fmt.Sprintf("%d\\. %s%s", index, icon(userID), escapedName))
 		if p.Username != "" {
 			escapedUsername := escapeMarkdown(p.Username)
 			list.WriteString(fmt.Sprintf(" \\(@%s\\)", escapedUsername))
@@ -803,6 +1090,9 @@ func (s *SecretSantaBot) handleListParticipants(msg *tgbotapi.Message) {
 		list.WriteString("\n")
 		index++
 	}
+	if showReachability {
+		list.WriteString("\n" + escapeMarkdown(reachabilityLegend))
+	}
 
 	response := tgbotapi.NewMessage(msg.Chat.ID, list.String())
 	response.ParseMode = "MarkdownV2"
@@ -812,38 +1102,41 @@ func (s *SecretSantaBot) handleListParticipants(msg *tgbotapi.Message) {
 		var plainList strings.Builder
 		plainList.WriteString("📝 Участники:\n\n")
 		index = 1
-		for _, p := range participants {
-			plainList.WriteString(fmt.Sprintf("%d. %s", index, p.FullName))
+		for userID, p := range participants {
+			plainList.WriteString(fmt.Sprintf("%d. %s%s", index, icon(userID), p.FullName))
 			if p.Username != "" {
 				plainList.WriteString(fmt.Sprintf(" (@%s)", p.Username))
 			}
 			plainList.WriteString("\n")
 			index++
 		}
+		if showReachability {
+			plainList.WriteString("\n" + reachabilityLegend)
+		}
 		responsePlain := tgbotapi.NewMessage(msg.Chat.ID, plainList.String())
 		s.Bot.Send(responsePlain)
 	}
 }
 
-func (s *SecretSantaBot) handleAddRestriction(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleAddRestriction(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
-	existing, err := s.Storage.GetParticipant(userID)
+	existing, err := s.Storage.GetParticipant(ctx, gameID, userID)
 	if err != nil || existing == nil {
-		s.sendMessage(msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
 		return
 	}
 
 	text := strings.TrimSpace(msg.CommandArguments())
 	if text == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Укажите username пользователя. Пример: /restrict @username")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите username пользователя. Пример: /restrict @username")
 		return
 	}
 
 	username := strings.TrimPrefix(text, "@")
 
-	participants, err := s.Storage.GetAllParticipants()
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
 		return
 	}
 
@@ -858,38 +1151,38 @@ func (s *SecretSantaBot) handleAddRestriction(msg *tgbotapi.Message) {
 	}
 
 	if !found {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s не найден среди участников.", username))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s не найден среди участников.", username))
 		return
 	}
 
 	if userID == forbiddenUserID {
-		s.sendMessage(msg.Chat.ID, "❌ Нельзя добавить ограничение на самого себя.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Нельзя добавить ограничение на самого себя.")
 		return
 	}
 
 	creatorID := msg.From.ID
 
-	hasRestriction, err := s.Storage.HasRestriction(userID, forbiddenUserID)
+	hasRestriction, err := s.Storage.HasRestriction(ctx, gameID, userID, forbiddenUserID)
 	if err != nil {
 		log.Printf("handleAddRestriction: failed to check existing restriction: %v", err)
 	} else if hasRestriction {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("ℹ️ Ограничение уже существует: вы не получите @%s", username))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("ℹ️ Ограничение уже существует: вы не получите @%s", username))
 		return
 	}
 
-	if err := s.AddRestriction(userID, forbiddenUserID, creatorID); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении ограничения: %v", err))
+	if err := s.AddRestriction(ctx, gameID, userID, forbiddenUserID, creatorID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при добавлении ограничения: %v", err))
 		return
 	}
 	log.Printf("handleAddRestriction: restriction saved to Redis successfully")
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Ограничение добавлено и сохранено: вы не получите @%s", username))
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Ограничение добавлено и сохранено: вы не получите @%s", username))
 }
 
-func (s *SecretSantaBot) handleRemoveRestriction(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleRemoveRestriction(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
 	text := strings.TrimSpace(msg.CommandArguments())
 	if text == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Укажите username пользователя. Пример: /unrestrict @username")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите username пользователя. Пример: /unrestrict @username")
 		return
 	}
 
@@ -898,9 +1191,9 @@ func (s *SecretSantaBot) handleRemoveRestriction(msg *tgbotapi.Message) {
 
 	usernameArg := strings.TrimPrefix(text, "@")
 
-	participants, err := s.Storage.GetAllParticipants()
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
 		return
 	}
 
@@ -915,40 +1208,46 @@ func (s *SecretSantaBot) handleRemoveRestriction(msg *tgbotapi.Message) {
 	}
 
 	if !found {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s не найден.", usernameArg))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s не найден.", usernameArg))
 		return
 	}
 
 	if !isAdmin {
-		creatorID, err := s.Storage.GetRestrictionCreator(userID, forbiddenUserID)
+		creatorID, err := s.Storage.GetRestrictionCreator(ctx, gameID, userID, forbiddenUserID)
 		if err != nil || creatorID != userID {
-			s.sendMessage(msg.Chat.ID, "❌ Вы можете удалить только свои ограничения. Администраторы могут удалять любые ограничения.")
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Вы можете удалить только свои ограничения. Администраторы могут удалять любые ограничения.")
 			return
 		}
 	}
 
-	if err := s.RemoveRestriction(userID, forbiddenUserID); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении ограничения: %v", err))
+	if err := s.RemoveRestriction(ctx, gameID, userID, forbiddenUserID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении ограничения: %v", err))
 		return
 	}
 	log.Printf("handleRemoveRestriction: restriction deleted from Redis successfully")
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Ограничение удалено из Redis для @%s", usernameArg))
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Ограничение удалено из Redis для @%s", usernameArg))
 }
 
-func (s *SecretSantaBot) handleListRestrictions(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleListRestrictions(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
 	username := msg.From.UserName
 	isAdmin := s.IsAdmin(username)
 
-	restrictions, _, err := s.Storage.GetAllRestrictions()
+	restrictions, _, err := s.Storage.GetAllRestrictions(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения ограничений: %v", err))
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения ограничений: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
 		return
 	}
 
-	participants, err := s.Storage.GetAllParticipants()
+	groups, err := s.Storage.GetAllGroups(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения групп: %v", err))
 		return
 	}
 
@@ -986,35 +1285,44 @@ func (s *SecretSantaBot) handleListRestrictions(msg *tgbotapi.Message) {
 		}
 	} else {
 		userRestrictions, exists := restrictions[userID]
-		if !exists || len(userRestrictions) == 0 {
-			s.sendMessage(msg.Chat.ID, "📋 У вас нет ограничений.")
+		if (!exists || len(userRestrictions) == 0) && len(groups) == 0 {
+			s.sendMessage(ctx, msg.Chat.ID, "📋 У вас нет ограничений.")
 			return
 		}
 
 		user := participants[userID]
 		if user == nil {
-			s.sendMessage(msg.Chat.ID, "❌ Ошибка: вы не найдены среди участников.")
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Ошибка: вы не найдены среди участников.")
 			return
 		}
 
-		list.WriteString("*Вы* не получите:\n")
-		for forbiddenID := range userRestrictions {
-			forbiddenUser := participants[forbiddenID]
-			if forbiddenUser != nil {
-				escapedForbiddenName := escapeMarkdown(forbiddenUser.FullName)
-				list.WriteString(fmt.Sprintf("  \\- %s", escapedForbiddenName))
-				if forbiddenUser.Username != "" {
-					escapedForbiddenUsername := escapeMarkdown(forbiddenUser.Username)
-					list.WriteString(fmt.Sprintf(" \\(@%s\\)", escapedForbiddenUsername))
+		if len(userRestrictions) > 0 {
+			list.WriteString("*Вы* не получите:\n")
+			for forbiddenID := range userRestrictions {
+				forbiddenUser := participants[forbiddenID]
+				if forbiddenUser != nil {
+					escapedForbiddenName := escapeMarkdown(forbiddenUser.FullName)
+					list.WriteString(fmt.Sprintf("  \\- %s", escapedForbiddenName))
+					if forbiddenUser.Username != "" {
+						escapedForbiddenUsername := escapeMarkdown(forbiddenUser.Username)
+						list.WriteString(fmt.Sprintf(" \\(@%s\\)", escapedForbiddenUsername))
+					}
+					list.WriteString("\n")
 				}
-				list.WriteString("\n")
 			}
+			list.WriteString("\n")
+			hasRestrictions = true
 		}
+	}
+
+	if len(groups) > 0 {
+		list.WriteString("👨‍👩‍👧 *Группы:*\n\n")
+		list.WriteString(formatGroups(groups, participants, true))
 		hasRestrictions = true
 	}
 
 	if !hasRestrictions {
-		s.sendMessage(msg.Chat.ID, "📋 Ограничений нет.")
+		s.sendMessage(ctx, msg.Chat.ID, "📋 Ограничений нет.")
 		return
 	}
 
@@ -1047,8 +1355,7 @@ func (s *SecretSantaBot) handleListRestrictions(msg *tgbotapi.Message) {
 				}
 				plainList.WriteString("\n")
 			}
-		} else {
-			userRestrictions := restrictions[userID]
+		} else if userRestrictions := restrictions[userID]; len(userRestrictions) > 0 {
 			plainList.WriteString("Вы не получите:\n")
 			for forbiddenID := range userRestrictions {
 				forbiddenUser := participants[forbiddenID]
@@ -1060,40 +1367,111 @@ func (s *SecretSantaBot) handleListRestrictions(msg *tgbotapi.Message) {
 					plainList.WriteString("\n")
 				}
 			}
+			plainList.WriteString("\n")
+		}
+		if len(groups) > 0 {
+			plainList.WriteString("👨‍👩‍👧 Группы:\n\n")
+			plainList.WriteString(formatGroups(groups, participants, false))
 		}
 		responsePlain := tgbotapi.NewMessage(msg.Chat.ID, plainList.String())
 		s.Bot.Send(responsePlain)
 	}
 }
 
-func (s *SecretSantaBot) handleGenerate(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleGenerate(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	username := msg.From.UserName
 	if !s.IsAdmin(username) {
-		s.sendMessage(msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
 		return
 	}
 
-	participants, err := s.Storage.GetAllParticipants()
+	// Доступность проверяется до блокировки игры; тех, кто присоединится за это время,
+	// бот считает доступными, пока отправка не покажет обратное.
+	if participants, err := s.Storage.GetAllParticipants(ctx, gameID); err == nil && len(participants) >= 2 {
+		s.refreshReachability(ctx, participants)
+	}
+
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
 		return
 	}
 
 	if len(participants) < 2 {
-		s.sendMessage(msg.Chat.ID, "❌ Нужно минимум 2 участника для игры.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Нужно минимум 2 участника для игры.")
 		return
 	}
 
-	err = s.GenerateAssignments()
+	force := false
+	var modeArg string
+	for _, arg := range strings.Fields(strings.ToLower(msg.CommandArguments())) {
+		if arg == "force" {
+			force = true
+			continue
+		}
+		modeArg = arg
+	}
+
+	if modeArg != "" {
+		mode, ok := parseAssignmentMode(modeArg)
+		if !ok {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Неизвестный режим распределения. Доступные режимы:\n\n"+
+				"/generate any - любое распределение\n"+
+				"/generate chain - один общий круг (подарки передаются по цепочке)\n"+
+				"/generate nomutual - без взаимных пар (A дарит B и B дарит A)")
+			return
+		}
+		settings := s.getGameSettings(ctx, gameID)
+		settings.Mode = mode
+		if err := s.Storage.SaveGameSettings(ctx, gameID, settings); err != nil {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения настроек игры: %v", err))
+			return
+		}
+	}
+	mode := s.getGameSettings(ctx, gameID).Mode
+
+	unreachable := s.findUnreachable(ctx, participants)
+	if len(unreachable) > 0 && !force {
+		s.remindUnreachable(ctx, gameID, participants, unreachable)
+		names := make([]string, 0, len(unreachable))
+		for _, userID := range unreachable {
+			names = append(names, participantDisplayName(participants, userID))
+		}
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("📭 Бот не может написать в личные сообщения участникам (%d): %s.\n\n"+
+			"Им отправлена ссылка на бота в групповом чате игры. Когда они откроют бота, повторите /generate.\n"+
+			"Создать распределение, не дожидаясь их: /generate force (назначение придет им, когда они откроют бота по ссылке).",
+			len(unreachable), strings.Join(names, ", ")))
+		return
+	}
+
+	report, err := s.GenerateAssignments(ctx, gameID)
+	if errors.Is(err, ErrModeUnsatisfiable) || errors.Is(err, ErrSearchLimit) {
+		reason := "При текущих ограничениях такого распределения не существует."
+		if errors.Is(err, ErrSearchLimit) {
+			reason = "Не удалось найти такое распределение за отведенное время."
+		}
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Не удалось создать распределение в режиме «%s».\n\n%s\n\n"+
+			"Попробуйте уменьшить количество ограничений или выберите другой режим: /generate any", assignmentModeTitle(mode), reason))
+		return
+	}
+	var infeasible *InfeasibilityError
+	if errors.As(err, &infeasible) {
+		report := s.formatInfeasibility(ctx, gameID, participants, infeasible)
+		if msg.Chat.IsGroup() || msg.Chat.IsSuperGroup() {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Распределение невозможно при текущих ограничениях. Подробности отправлены администратору в личные сообщения.")
+			s.sendMessage(ctx, msg.From.ID, report)
+		} else {
+			s.sendMessage(ctx, msg.Chat.ID, report)
+		}
+		return
+	}
 	if err != nil {
 		escapedError := escapeMarkdown(err.Error())
-		errorMsg := fmt.Sprintf("❌ *Ошибка при генерации распределения:*\n\n%s\n\n"+
-			"*Возможные причины:*\n"+
-			"• Слишком много ограничений\n"+
-			"• Невозможно создать валидное распределение с текущими ограничениями\n\n"+
-			"*Решение:*\n"+
-			"Попробуйте уменьшить количество ограничений или изменить их\\.", escapedError)
-		s.sendMessage(msg.Chat.ID, errorMsg)
+		errorMsg := fmt.Sprintf("❌ *Ошибка при генерации распределения:*\n\n%s", escapedError)
+		s.sendMessage(ctx, msg.Chat.ID, errorMsg)
 		if msg.Chat.IsGroup() || msg.Chat.IsSuperGroup() {
 			adminUserID := msg.From.ID
 			adminMsg := tgbotapi.NewMessage(adminUserID, errorMsg)
@@ -1103,91 +1481,327 @@ func (s *SecretSantaBot) handleGenerate(msg *tgbotapi.Message) {
 		return
 	}
 
-	if err := s.Storage.SaveGameState(true, false); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения состояния игры: %v", err))
+	if err := s.Storage.SaveGameState(ctx, gameID, true, false); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения состояния игры: %v", err))
 		return
 	}
-	s.sendMessage(msg.Chat.ID, "✅ Распределение успешно создано! Используйте /startgame чтобы начать игру и отправить результаты участникам.")
+	resultMsg := fmt.Sprintf("✅ Распределение успешно создано (режим: %s)! Используйте /startgame чтобы начать игру и отправить результаты участникам.", assignmentModeTitle(mode))
+	if report.Repeats > 0 {
+		resultMsg += fmt.Sprintf("\n\n⚠️ Избежать всех повторов с прошлыми сезонами не удалось. Повторившихся пар: %d", report.Repeats)
+	}
+	if report.Preferences > 0 {
+		resultMsg += fmt.Sprintf("\n\n⭐ Учтено пожеланий: %d из %d", report.Preferences-report.PreferenceViolations, report.Preferences)
+	}
+	if len(unreachable) > 0 {
+		resultMsg += fmt.Sprintf("\n\n📭 Бот пока не может написать участникам: %d. Статус доставки: /list", len(unreachable))
+	}
+	s.sendMessage(ctx, msg.Chat.ID, resultMsg)
+}
+
+func parseAssignmentMode(arg string) (domain.AssignmentMode, bool) {
+	switch domain.AssignmentMode(arg) {
+	case domain.AssignmentModeAny, domain.AssignmentModeChain, domain.AssignmentModeNoMutual:
+		return domain.AssignmentMode(arg), true
+	}
+	return "", false
+}
+
+func assignmentModeTitle(mode domain.AssignmentMode) string {
+	switch mode {
+	case domain.AssignmentModeChain:
+		return "один общий круг"
+	case domain.AssignmentModeNoMutual:
+		return "без взаимных пар"
+	default:
+		return "любое распределение"
+	}
+}
+
+func (s *SecretSantaBot) getGameSettings(ctx context.Context, gameID int64) *domain.GameSettings {
+	settings, err := s.Storage.GetGameSettings(ctx, gameID)
+	if err != nil {
+		log.Printf("getGameSettings: failed to get settings for gameID=%d: %v", gameID, err)
+	}
+	if settings == nil {
+		settings = &domain.GameSettings{}
+	}
+	if settings.Mode == "" {
+		settings.Mode = domain.AssignmentModeAny
+	}
+	return settings
+}
+
+func findParticipantByUsername(participants map[int64]*domain.Participant, username string) (int64, bool) {
+	username = strings.TrimPrefix(username, "@")
+	for id, p := range participants {
+		if strings.EqualFold(p.Username, username) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// findParticipant ищет участника по @username или по ID.
+func findParticipant(participants map[int64]*domain.Participant, arg string) (int64, bool) {
+	if id, found := findParticipantByUsername(participants, arg); found {
+		return id, true
+	}
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil && participants[id] != nil {
+		return id, true
+	}
+	return 0, false
 }
 
-func (s *SecretSantaBot) handleSendAssignments(msg *tgbotapi.Message) {
+func participantDisplayName(participants map[int64]*domain.Participant, userID int64) string {
+	p, ok := participants[userID]
+	if !ok || p == nil {
+		return fmt.Sprintf("Участник (ID: %d)", userID)
+	}
+	name := p.FullName
+	if p.Username != "" {
+		name += " (@" + p.Username + ")"
+	}
+	return name
+}
+
+func (s *SecretSantaBot) formatInfeasibility(ctx context.Context, gameID int64, participants map[int64]*domain.Participant, e *InfeasibilityError) string {
+	var report strings.Builder
+	report.WriteString("❌ Распределение невозможно\n\n")
+
+	if e.ByReceivers {
+		report.WriteString(fmt.Sprintf("Этим участникам (%d):\n", len(e.People)))
+	} else {
+		report.WriteString(fmt.Sprintf("Эти участники (%d):\n", len(e.People)))
+	}
+	for _, userID := range e.People {
+		report.WriteString(fmt.Sprintf("• %s\n", participantDisplayName(participants, userID)))
+	}
+
+	if e.ByReceivers {
+		report.WriteString(fmt.Sprintf("\nмогут дарить подарки только эти участники (%d):\n", len(e.Partners)))
+	} else {
+		report.WriteString(fmt.Sprintf("\nмогут дарить подарки только этим участникам (%d):\n", len(e.Partners)))
+	}
+	if len(e.Partners) == 0 {
+		report.WriteString("• никто\n")
+	}
+	for _, userID := range e.Partners {
+		report.WriteString(fmt.Sprintf("• %s\n", participantDisplayName(participants, userID)))
+	}
+
+	if len(e.DropRestrictions) > 0 {
+		report.WriteString(fmt.Sprintf("\nЧтобы распределение стало возможным, достаточно снять ограничения (%d):\n", len(e.DropRestrictions)))
+		for _, r := range e.DropRestrictions {
+			report.WriteString(fmt.Sprintf("• %s не получит %s%s\n",
+				participantDisplayName(participants, r.UserID),
+				participantDisplayName(participants, r.ForbiddenUserID),
+				s.restrictionSource(ctx, gameID, r)))
+		}
+	}
+
+	return report.String()
+}
+
+func (s *SecretSantaBot) handleSendAssignments(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	username := msg.From.UserName
 	if !s.IsAdmin(username) {
-		s.sendMessage(msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
 		return
 	}
 
-	gameActive, _, err := s.Storage.GetGameState()
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	gameActive, _, err := s.Storage.GetGameState(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
 		return
 	}
 
 	if !gameActive {
-		s.sendMessage(msg.Chat.ID, "❌ Сначала создайте распределение через /generate")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала создайте распределение через /generate")
 		return
 	}
 
-	assignments, err := s.Storage.GetAllAssignments()
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения назначений: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения назначений: %v", err))
 		return
 	}
 
 	if len(assignments) == 0 {
-		s.sendMessage(msg.Chat.ID, "❌ Сначала создайте распределение через /generate")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала создайте распределение через /generate")
 		return
 	}
 
-	successCount := 0
-	failedCount := 0
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
 
+	items := make([]OutboundItem, 0, len(assignments))
+	failedCount := 0
 	for userID := range assignments {
-		err := s.SendAssignment(userID)
+		message, err := s.assignmentMessage(ctx, gameID, userID)
 		if err != nil {
-			log.Printf("Failed to send message to user %d: %v", userID, err)
+			log.Printf("Failed to prepare assignment for user %d: %v", userID, err)
 			failedCount++
-		} else {
-			successCount++
+			continue
 		}
+		items = append(items, OutboundItem{
+			ChatID:    userID,
+			Recipient: participantDisplayName(participants, userID),
+			Text:      message,
+			Buttons:   giftButtons(gameID),
+		})
 	}
 
-	resultMsg := fmt.Sprintf("✅ *Игра начата!*\n\n"+
-		"Отправлено сообщений: %d\n"+
-		"Ошибок: %d\n\n"+
-		"Все участники получили информацию о своих получателях.", successCount, failedCount)
-	s.sendMessage(msg.Chat.ID, resultMsg)
+	// Игра считается начатой, только когда все назначения уже в очереди: иначе после сбоя
+	// повторный /startgame разослал бы часть назначений дважды.
+	batch := &domain.OutboundBatch{
+		GameID:       gameID,
+		Title:        fmt.Sprintf("назначения игры «%s»", s.gameTitle(ctx, gameID)),
+		ReportChatID: msg.From.ID,
+	}
+	if err := s.Outbox.SendBatch(ctx, batch, items); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка постановки сообщений в очередь: %v", err))
+		return
+	}
+
+	if err := s.Storage.SaveGameState(ctx, gameID, true, true); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения состояния игры: %v", err))
+		return
+	}
+
+	resultMsg := fmt.Sprintf("✅ Игра начата!\n\n"+
+		"Назначений поставлено в очередь: %d\n"+
+		"Ошибок подготовки: %d\n\n"+
+		"Сообщения отправляются с учетом лимитов Telegram. Когда рассылка завершится, администратор получит в личные сообщения отчет о доставке каждому участнику.", len(items), failedCount)
+	s.sendMessage(ctx, msg.Chat.ID, resultMsg)
 
 	if msg.Chat.IsGroup() || msg.Chat.IsSuperGroup() {
-		adminUserID := msg.From.ID
-		adminMsg := tgbotapi.NewMessage(adminUserID, resultMsg)
-		adminMsg.ParseMode = "Markdown"
-		s.Bot.Send(adminMsg)
+		s.Bot.Send(tgbotapi.NewMessage(msg.From.ID, resultMsg))
+	}
+}
+
+func (s *SecretSantaBot) handleMyAssignment(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, "🤫 Чтобы никто не узнал вашего получателя, эта команда работает только в личных сообщениях с ботом. Напишите боту /myassignment в личку.")
+		return
+	}
+
+	userID := msg.From.ID
+	participant, err := s.Storage.GetParticipant(ctx, gameID, userID)
+	if err != nil || participant == nil {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Вы не участвуете в игре.")
+		return
+	}
+
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
+		return
+	}
+	receiverID, err := s.Storage.GetAssignment(ctx, gameID, userID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения назначения: %v", err))
+		return
+	}
+	if !gameStarted || receiverID == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, "⏳ Игра еще не началась: получатель придет вам, когда администратор выполнит /startgame.")
+		return
 	}
 
-	if err := s.Storage.SaveGameState(true, true); err != nil {
-		log.Printf("Failed to save game state: %v", err)
+	if err := s.SendAssignment(ctx, gameID, userID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Не удалось отправить назначение: %v", err))
 	}
 }
 
-func (s *SecretSantaBot) handleReset(msg *tgbotapi.Message) {
-	if err := s.Storage.ClearAll(); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сбросе игры: %v", err))
+func (s *SecretSantaBot) handleResend(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		return
+	}
+
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите участника. Пример: /resend @username")
 		return
 	}
-	s.sendMessage(msg.Chat.ID, "🔄 Игра сброшена. Можно начинать заново!")
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+	userID, found := findParticipant(participants, arg)
+	if !found {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Участник %s не найден в игре.", arg))
+		return
+	}
+	name := participantDisplayName(participants, userID)
+
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
+		return
+	}
+	if !gameStarted {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Игра еще не началась. Используйте /startgame, чтобы отправить назначения всем участникам.")
+		return
+	}
+
+	if err := s.SendAssignment(ctx, gameID, userID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Не удалось отправить назначение участнику %s: %v", name, err))
+		return
+	}
+
+	result := fmt.Sprintf("✅ Назначение повторно отправлено участнику %s.", name)
+	if r, err := s.Storage.GetReachability(ctx, userID); err == nil && r != nil && !r.Reachable {
+		result += fmt.Sprintf("\n\n📭 Последняя попытка написать этому участнику не удалась: %s. "+
+			"Если назначение снова не дойдет, попросите его открыть бота по ссылке:\n%s", r.Reason, s.startLink(gameID))
+	}
+	s.sendMessage(ctx, msg.Chat.ID, result)
 }
 
-func (s *SecretSantaBot) handleStatus(msg *tgbotapi.Message) {
-	participants, err := s.Storage.GetAllParticipants()
+func (s *SecretSantaBot) handleReset(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		return
+	}
+
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	archived, err := s.ArchiveSeason(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения статуса: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении сезона в историю: %v", err))
+		return
+	}
+
+	if err := s.Storage.ClearGame(ctx, gameID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сбросе игры: %v", err))
 		return
 	}
 
-	gameActive, gameStarted, err := s.Storage.GetGameState()
+	if archived {
+		s.sendMessage(ctx, msg.Chat.ID, "🔄 Игра сброшена, распределение сезона сохранено в историю. Можно начинать заново!")
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, "🔄 Игра сброшена. Можно начинать заново!")
+}
+
+func (s *SecretSantaBot) handleStatus(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения статуса: %v", err))
+		return
+	}
+
+	gameActive, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения состояния игры: %v", err))
 		return
 	}
 
@@ -1202,6 +1816,32 @@ func (s *SecretSantaBot) handleStatus(msg *tgbotapi.Message) {
 		escapeMarkdown(gameActiveText),
 		escapeMarkdown(gameStartedText))
 
+	var extra string
+	if record, err := s.Storage.GetDrawRecord(ctx, gameID); gameActive && err == nil && record != nil {
+		extra = fmt.Sprintf("\n\n🔐 Отпечаток распределения (SHA-256): %s", record.PublishedCommitment())
+	}
+	if relayed, err := s.Storage.GetRelayMessages(ctx, gameID); err == nil && len(relayed) > 0 {
+		extra += fmt.Sprintf("\n\n💬 Анонимных сообщений между Сантами и получателями: %d", len(relayed))
+	}
+	if s.IsAdmin(msg.From.UserName) {
+		reachable, unreachable, unknown := countReachability(participants, s.participantsReachability(ctx, participants))
+		extra += fmt.Sprintf("\n\n📬 Бот может написать в личку: %d из %d", reachable, len(participants))
+		if unreachable > 0 {
+			extra += fmt.Sprintf("\n📭 Не может: %d (кто именно - в /list)", unreachable)
+		}
+		if unknown > 0 {
+			extra += fmt.Sprintf("\n❔ Еще не проверялись: %d (проверка выполняется при /generate)", unknown)
+		}
+		if gameStarted {
+			assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+			statuses, statusErr := s.Storage.GetAllGiftStatuses(ctx, gameID)
+			if err == nil && statusErr == nil {
+				extra += formatGiftProgress(participants, assignments, statuses)
+			}
+		}
+	}
+	status += escapeMarkdown(extra)
+
 	response := tgbotapi.NewMessage(msg.Chat.ID, status)
 	response.ParseMode = "MarkdownV2"
 	_, err = s.Bot.Send(response)
@@ -1212,27 +1852,27 @@ func (s *SecretSantaBot) handleStatus(msg *tgbotapi.Message) {
 			"Распределение создано: %s\n"+
 			"Результаты отправлены: %s",
 			len(participants), gameActiveText, gameStartedText)
+		statusPlain += extra
 		responsePlain := tgbotapi.NewMessage(msg.Chat.ID, statusPlain)
 		s.Bot.Send(responsePlain)
 	}
 }
 
-func (s *SecretSantaBot) handleMembersCount(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleMembersCount(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	if !msg.Chat.IsGroup() && !msg.Chat.IsSuperGroup() {
-		s.sendMessage(msg.Chat.ID, "❌ Эта команда работает только в группах.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда работает только в группах.")
 		return
 	}
 
-	participants, err := s.Storage.GetAllParticipants()
 	savedCount := 0
+	if users, err := s.Storage.GetAllUsers(ctx); err == nil {
+		savedCount = len(users)
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	gameParticipants := 0
 	if err == nil {
-		savedCount = len(participants)
-		for _, p := range participants {
-			if p.Username != "" {
-				gameParticipants++
-			}
-		}
+		gameParticipants = len(participants)
 	}
 
 	admins, err := s.Bot.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{
@@ -1243,24 +1883,13 @@ func (s *SecretSantaBot) handleMembersCount(msg *tgbotapi.Message) {
 		adminCount = len(admins)
 	}
 
-	var membersCount int
-	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/getChatMemberCount?chat_id=%d", s.Bot.Token, msg.Chat.ID)
-	resp, err := http.Get(apiURL)
-	if err == nil {
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			var result struct {
-				OK     bool `json:"ok"`
-				Result int  `json:"result"`
-			}
-			if json.Unmarshal(body, &result) == nil && result.OK {
-				membersCount = result.Result
-				log.Printf("handleMembersCount: group has %d members", membersCount)
-			}
-		}
-	} else {
+	membersCount, err := s.Bot.GetChatMembersCount(tgbotapi.ChatMemberCountConfig{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: msg.Chat.ID},
+	})
+	if err != nil {
 		log.Printf("handleMembersCount: failed to get member count: %v", err)
+	} else {
+		log.Printf("handleMembersCount: group has %d members", membersCount)
 	}
 
 	message := "📊 *Информация о группе:*\n\n"
@@ -1271,24 +1900,24 @@ func (s *SecretSantaBot) handleMembersCount(msg *tgbotapi.Message) {
 	message += fmt.Sprintf("Сохранено ботом: %d пользователей\n", savedCount)
 	message += fmt.Sprintf("Участвует в игре: %d", gameParticipants)
 
-	s.sendMessage(msg.Chat.ID, message)
+	s.sendMessage(ctx, msg.Chat.ID, message)
 }
 
-func (s *SecretSantaBot) CheckTriggerWords(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) CheckTriggerWords(ctx context.Context, msg *tgbotapi.Message) {
 	if msg.From == nil {
 		return
 	}
 
 	text := strings.ToLower(msg.Text)
 
-	allTriggerWords, err := s.Storage.GetAllTriggerWords()
+	allTriggerWords, err := s.Storage.GetAllTriggerWords(ctx)
 	if err == nil {
 		for _, triggerWord := range allTriggerWords {
 			if strings.Contains(text, strings.ToLower(triggerWord)) {
-				messages, err := s.Storage.GetTriggerMessages(triggerWord)
+				messages, err := s.Storage.GetTriggerMessages(ctx, triggerWord)
 				if err == nil && len(messages) > 0 {
 					randomMessage := messages[rand.Intn(len(messages))]
-					s.sendMessage(msg.Chat.ID, randomMessage)
+					s.sendMessage(ctx, msg.Chat.ID, randomMessage)
 					log.Printf("Trigger word '%s' detected in message from user %d, sent random message (total: %d)", triggerWord, msg.From.ID, len(messages))
 					return
 				}
@@ -1298,31 +1927,30 @@ func (s *SecretSantaBot) CheckTriggerWords(msg *tgbotapi.Message) {
 
 	for _, triggerWord := range s.TriggerWords {
 		if strings.Contains(text, strings.ToLower(triggerWord)) {
-			messages, err := s.Storage.GetTriggerMessages(triggerWord)
+			messages, err := s.Storage.GetTriggerMessages(ctx, triggerWord)
 			if err == nil && len(messages) > 0 {
 				randomMessage := messages[rand.Intn(len(messages))]
-				s.sendMessage(msg.Chat.ID, randomMessage)
+				s.sendMessage(ctx, msg.Chat.ID, randomMessage)
 				log.Printf("Config trigger word '%s' detected in message from user %d, sent random message (total: %d)", triggerWord, msg.From.ID, len(messages))
 			} else {
 				curseMessage := "💩 Санта проклинает тебя на понос и желает дерьмового нового года! 💩"
-				s.sendMessage(msg.Chat.ID, curseMessage)
+				s.sendMessage(ctx, msg.Chat.ID, curseMessage)
 				log.Printf("Config trigger word '%s' detected in message from user %d, sent default message (no custom messages found)", triggerWord, msg.From.ID)
 			}
 			return
 		}
 	}
 
-	userTriggers := s.UserTriggers[msg.From.ID]
-	for _, triggerWord := range userTriggers {
+	for _, triggerWord := range s.userTriggers(msg.From.ID) {
 		if strings.Contains(text, strings.ToLower(triggerWord)) {
-			messages, err := s.Storage.GetTriggerMessages(triggerWord)
+			messages, err := s.Storage.GetTriggerMessages(ctx, triggerWord)
 			if err == nil && len(messages) > 0 {
 				randomMessage := messages[rand.Intn(len(messages))]
-				s.sendMessage(msg.Chat.ID, randomMessage)
+				s.sendMessage(ctx, msg.Chat.ID, randomMessage)
 				log.Printf("User trigger word '%s' detected in message from user %d, sent random message (total: %d)", triggerWord, msg.From.ID, len(messages))
 			} else {
 				curseMessage := "💩 Санта проклинает тебя на понос и желает дерьмового нового года! 💩"
-				s.sendMessage(msg.Chat.ID, curseMessage)
+				s.sendMessage(ctx, msg.Chat.ID, curseMessage)
 				log.Printf("User trigger word '%s' detected in message from user %d, sent default message (no custom messages found)", triggerWord, msg.From.ID)
 			}
 			return
@@ -1330,106 +1958,99 @@ func (s *SecretSantaBot) CheckTriggerWords(msg *tgbotapi.Message) {
 	}
 }
 
-func (s *SecretSantaBot) handleSetWish(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleSetWish(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
-	existing, err := s.Storage.GetParticipant(userID)
+	existing, err := s.Storage.GetParticipant(ctx, gameID, userID)
 	if err != nil || existing == nil {
-		s.sendMessage(msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
 		return
 	}
 
 	wish := strings.TrimSpace(msg.CommandArguments())
 	if wish == "" {
-		currentWish, err := s.Storage.GetWish(userID)
+		currentWish, err := s.Storage.GetWish(ctx, gameID, userID)
 		if err == nil && currentWish != "" {
-			s.sendMessage(msg.Chat.ID, fmt.Sprintf("💝 Ваше текущее желание:\n\n%s\n\nЧтобы изменить, используйте: /wish новое желание", currentWish))
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("💝 Ваше текущее желание:\n\n%s\n\nЧтобы изменить, используйте: /wish новое желание", currentWish))
 		} else {
-			s.sendMessage(msg.Chat.ID, "❌ Укажите ваше желание. Пример: /wish Хочу получить книгу")
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите ваше желание. Пример: /wish Хочу получить книгу")
 		}
 		return
 	}
 
-	if err := s.Storage.SaveWish(userID, wish); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении желания: %v", err))
+	if err := s.Storage.SaveWish(ctx, gameID, userID, wish); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении желания: %v", err))
 		return
 	}
 
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Ваше желание сохранено:\n\n%s\n\nВы можете изменить его в любой момент, используя /wish новое желание", wish))
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Ваше желание сохранено:\n\n%s\n\nВы можете изменить его в любой момент, используя /wish новое желание", wish))
 }
 
-func (s *SecretSantaBot) handleGetWish(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleGetWish(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
-	existing, err := s.Storage.GetParticipant(userID)
+	existing, err := s.Storage.GetParticipant(ctx, gameID, userID)
 	if err != nil || existing == nil {
-		s.sendMessage(msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
 		return
 	}
 
-	wish, err := s.Storage.GetWish(userID)
+	wish, err := s.Storage.GetWish(ctx, gameID, userID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при получении желания: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при получении желания: %v", err))
 		return
 	}
 
 	if wish == "" {
-		s.sendMessage(msg.Chat.ID, "💝 У вас пока нет сохраненного желания.\n\nИспользуйте /wish ваше желание чтобы добавить его.")
+		s.sendMessage(ctx, msg.Chat.ID, "💝 У вас пока нет сохраненного желания.\n\nИспользуйте /wish ваше желание чтобы добавить его.")
 	} else {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("💝 Ваше желание:\n\n%s", wish))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("💝 Ваше желание:\n\n%s", wish))
 	}
 }
 
-func (s *SecretSantaBot) handleDeleteWish(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleDeleteWish(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
-	existing, err := s.Storage.GetParticipant(userID)
+	existing, err := s.Storage.GetParticipant(ctx, gameID, userID)
 	if err != nil || existing == nil {
-		s.sendMessage(msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
 		return
 	}
 
-	if err := s.Storage.DeleteWish(userID); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении желания: %v", err))
+	if err := s.Storage.DeleteWish(ctx, gameID, userID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении желания: %v", err))
 		return
 	}
 
-	s.sendMessage(msg.Chat.ID, "✅ Ваше желание удалено.")
+	s.sendMessage(ctx, msg.Chat.ID, "✅ Ваше желание удалено.")
 }
 
-func (s *SecretSantaBot) handleAddTrigger(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleAddTrigger(ctx context.Context, msg *tgbotapi.Message) {
 	userID := msg.From.ID
 	triggerWord := strings.TrimSpace(msg.CommandArguments())
 	if triggerWord == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Укажите слово-триггер. Пример: /addtrigger плохое_слово")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите слово-триггер. Пример: /addtrigger плохое_слово")
 		return
 	}
 
 	triggerWord = strings.ToLower(triggerWord)
 
-	if s.UserTriggers[userID] == nil {
-		s.UserTriggers[userID] = make([]string, 0)
-	}
-
-	for _, existing := range s.UserTriggers[userID] {
-		if existing == triggerWord {
-			s.sendMessage(msg.Chat.ID, fmt.Sprintf("ℹ️ Слово '%s' уже добавлено в ваши триггеры", triggerWord))
-			return
-		}
+	if !s.addUserTrigger(userID, triggerWord) {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("ℹ️ Слово '%s' уже добавлено в ваши триггеры", triggerWord))
+		return
 	}
 
-	s.UserTriggers[userID] = append(s.UserTriggers[userID], triggerWord)
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Слово-триггер '%s' добавлено! Теперь при упоминании этого слова бот отправит специальное сообщение.", triggerWord))
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Слово-триггер '%s' добавлено! Теперь при упоминании этого слова бот отправит специальное сообщение.", triggerWord))
 	log.Printf("User %d added trigger word: %s", userID, triggerWord)
 }
 
-func (s *SecretSantaBot) handleAddTriggerMessage(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleAddTriggerMessage(ctx context.Context, msg *tgbotapi.Message) {
 	args := strings.TrimSpace(msg.CommandArguments())
 	if args == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Укажите триггерное слово и сообщение. Пример: /addtriggermessage слово|Сообщение для отправки")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите триггерное слово и сообщение. Пример: /addtriggermessage слово|Сообщение для отправки")
 		return
 	}
 
 	parts := strings.SplitN(args, "|", 2)
 	if len(parts) != 2 {
-		s.sendMessage(msg.Chat.ID, "❌ Неверный формат. Используйте: /addtriggermessage слово|Сообщение для отправки\n\nПример: /addtriggermessage мат|💩 Санта проклинает тебя!")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Неверный формат. Используйте: /addtriggermessage слово|Сообщение для отправки\n\nПример: /addtriggermessage мат|💩 Санта проклинает тебя!")
 		return
 	}
 
@@ -1437,44 +2058,44 @@ func (s *SecretSantaBot) handleAddTriggerMessage(msg *tgbotapi.Message) {
 	message := strings.TrimSpace(parts[1])
 
 	if triggerWord == "" || message == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Триггерное слово и сообщение не могут быть пустыми.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Триггерное слово и сообщение не могут быть пустыми.")
 		return
 	}
 
-	if err := s.Storage.SaveTriggerMessage(triggerWord, message); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении сообщения: %v", err))
+	if err := s.Storage.SaveTriggerMessage(ctx, triggerWord, message); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении сообщения: %v", err))
 		return
 	}
 
-	messages, err := s.Storage.GetTriggerMessages(triggerWord)
+	messages, err := s.Storage.GetTriggerMessages(ctx, triggerWord)
 	if err == nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Сообщение добавлено к триггеру '%s'!\n\nВсего сообщений для этого триггера: %d\n\nПри обнаружении слова '%s' бот случайным образом выберет одно из сообщений.", triggerWord, len(messages), triggerWord))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Сообщение добавлено к триггеру '%s'!\n\nВсего сообщений для этого триггера: %d\n\nПри обнаружении слова '%s' бот случайным образом выберет одно из сообщений.", triggerWord, len(messages), triggerWord))
 	} else {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Сообщение добавлено к триггеру '%s'!", triggerWord))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Сообщение добавлено к триггеру '%s'!", triggerWord))
 	}
 	log.Printf("User %d added trigger message for word '%s': %s", msg.From.ID, triggerWord, message)
 }
 
-func (s *SecretSantaBot) handleAddComment(msg *tgbotapi.Message) {
+func (s *SecretSantaBot) handleAddComment(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
 	userID := msg.From.ID
 	args := strings.TrimSpace(msg.CommandArguments())
 	if args == "" {
-		s.sendMessage(msg.Chat.ID, "❌ Укажите участника и комментарий. Пример: /comment @username Текст комментария")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите участника и комментарий. Пример: /comment @username Текст комментария")
 		return
 	}
 
 	parts := strings.Fields(args)
 	if len(parts) < 2 {
-		s.sendMessage(msg.Chat.ID, "❌ Неверный формат. Используйте: /comment @username Текст комментария")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Неверный формат. Используйте: /comment @username Текст комментария")
 		return
 	}
 
 	usernameArg := strings.TrimPrefix(parts[0], "@")
 	commentText := strings.Join(parts[1:], " ")
 
-	participants, err := s.Storage.GetAllParticipants()
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
 	if err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
 		return
 	}
 
@@ -1489,17 +2110,17 @@ func (s *SecretSantaBot) handleAddComment(msg *tgbotapi.Message) {
 	}
 
 	if !found {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s не найден среди участников.", usernameArg))
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь @%s не найден среди участников.", usernameArg))
 		return
 	}
 
 	if userID == receiverID {
-		s.sendMessage(msg.Chat.ID, "❌ Нельзя добавить комментарий для самого себя.")
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Нельзя добавить комментарий для самого себя.")
 		return
 	}
 
-	if err := s.Storage.SaveComment(receiverID, userID, commentText); err != nil {
-		s.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении комментария: %v", err))
+	if err := s.Storage.SaveComment(ctx, gameID, receiverID, userID, commentText); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении комментария: %v", err))
 		return
 	}
 
@@ -1509,11 +2130,21 @@ func (s *SecretSantaBot) handleAddComment(msg *tgbotapi.Message) {
 		receiverName += " (@" + receiver.Username + ")"
 	}
 
-	s.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Комментарий добавлен для %s!\n\n💬 Ваш комментарий:\n%s", receiverName, commentText))
-	log.Printf("User %d added comment for receiverID=%d: %s", userID, receiverID, commentText)
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Комментарий добавлен для %s!\n\n💬 Ваш комментарий:\n%s", receiverName, commentText))
+	log.Printf("handleAddComment: gameID=%d, comment added", gameID)
 }
 
-func (s *SecretSantaBot) sendMessage(chatID int64, text string) {
+func (s *SecretSantaBot) sendMessage(ctx context.Context, chatID int64, text string) {
+	if s.Outbox != nil {
+		err := s.Outbox.Send(ctx, chatID, text)
+		if err == nil {
+			return
+		}
+		log.Printf("sendMessage: failed to enqueue message for chatID=%d, sending directly: %v", chatID, err)
+	}
+
 	msg := tgbotapi.NewMessage(chatID, text)
-	s.Bot.Send(msg)
+	if _, err := s.Bot.Send(msg); err != nil {
+		log.Printf("sendMessage: failed to send message to chatID=%d: %v", chatID, err)
+	}
 }
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"telegram-secret-santa/internal/telegramtest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newFakeTelegramBot(t *testing.T) (*SecretSantaBot, *telegramtest.Server) {
+	t.Helper()
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+
+	api, err := NewTelegramAPI("test-token", server.URL())
+	mustDo(t, err)
+	bot := NewSecretSantaBot(api, api.Self, []string{"admin"}, NewMemoryStorage(), nil)
+	bot.Outbox.limits = outboxLimits{}
+	return bot, server
+}
+
+func TestGameOverFakeTelegram(t *testing.T) {
+	ctx := context.Background()
+	bot, server := newFakeTelegramBot(t)
+	if bot.Self.UserName != server.Bot.UserName {
+		t.Fatalf("bot identity = %q, want %q from getMe", bot.Self.UserName, server.Bot.UserName)
+	}
+
+	group := telegramtest.GroupChat(testGameID, "Офис")
+	admin := tgbotapi.User{ID: 1, FirstName: "Админ", UserName: "admin"}
+	users := []tgbotapi.User{
+		admin,
+		{ID: 2, FirstName: "Аня", UserName: "anya"},
+		{ID: 3, FirstName: "Борис", UserName: "boris"},
+		{ID: 4, FirstName: "Вера", UserName: "vera"},
+	}
+	send := func(chat tgbotapi.Chat, from tgbotapi.User, text string) {
+		bot.HandleUpdate(ctx, server.PushMessage(chat, from, text))
+		bot.Outbox.Flush(ctx)
+	}
+
+	for _, u := range users {
+		send(group, u, "/add")
+	}
+	send(group, admin, "/generate")
+	send(group, admin, "/startgame")
+
+	assignments, err := bot.Storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if len(assignments) != len(users) {
+		t.Fatalf("got %d assignments, want %d", len(assignments), len(users))
+	}
+	for _, u := range users {
+		receiver, err := bot.Storage.GetParticipant(ctx, testGameID, assignments[u.ID])
+		mustDo(t, err)
+		var found bool
+		for _, m := range server.SentTo(u.ID) {
+			if strings.Contains(m.Text, receiver.FullName) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("user %d did not get a message naming their receiver %q: %+v", u.ID, receiver.FullName, server.SentTo(u.ID))
+		}
+	}
+	if probes := server.Requests("getChat"); len(probes) == 0 {
+		t.Errorf("expected reachability probes before /generate")
+	}
+	if actions := server.Requests("sendChatAction"); len(actions) != 0 {
+		t.Errorf("reachability probes must not be visible to users, got %d chat actions", len(actions))
+	}
+
+	server.SetMemberCount(testGameID, 12)
+	send(group, admin, "/members")
+	sent := server.SentTo(testGameID)
+	if last := sent[len(sent)-1].Text; !strings.Contains(last, "Участников в группе: 12") {
+		t.Errorf("/members reply = %q, want the count from getChatMembersCount", last)
+	}
+}
+
+func TestGenerateRefusesUnreachableParticipantsOverFakeTelegram(t *testing.T) {
+	ctx := context.Background()
+	bot, server := newFakeTelegramBot(t)
+	group := telegramtest.GroupChat(testGameID, "Офис")
+	admin := tgbotapi.User{ID: 1, FirstName: "Админ", UserName: "admin"}
+	blocked := tgbotapi.User{ID: 5, FirstName: "Гена", UserName: "gena"}
+	server.Block(blocked.ID)
+
+	for _, u := range []tgbotapi.User{admin, {ID: 2, FirstName: "Аня"}, {ID: 3, FirstName: "Борис"}, blocked} {
+		bot.HandleUpdate(ctx, server.PushMessage(group, u, "/add"))
+	}
+	bot.HandleUpdate(ctx, server.PushMessage(group, admin, "/generate"))
+	bot.Outbox.Flush(ctx)
+
+	assignments, err := bot.Storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if len(assignments) != 0 {
+		t.Fatalf("generated %d assignments although Гена blocked the bot", len(assignments))
+	}
+	sent := server.SentTo(testGameID)
+	if last := sent[len(sent)-1].Text; !strings.Contains(last, "Гена") {
+		t.Errorf("/generate reply = %q, want it to name the unreachable participant", last)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func isGroupChat(chat *tgbotapi.Chat) bool {
+	return chat.IsGroup() || chat.IsSuperGroup()
+}
+
+func containsGame(gameIDs []int64, gameID int64) bool {
+	for _, id := range gameIDs {
+		if id == gameID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SecretSantaBot) gameTitle(ctx context.Context, gameID int64) string {
+	game, err := s.Storage.GetGame(ctx, gameID)
+	if err != nil || game == nil || game.Title == "" {
+		return fmt.Sprintf("Игра %d", gameID)
+	}
+	return game.Title
+}
+
+func (s *SecretSantaBot) resolveGame(ctx context.Context, msg *tgbotapi.Message) (int64, bool) {
+	if isGroupChat(msg.Chat) {
+		if err := s.Storage.SaveGame(ctx, &domain.Game{ID: msg.Chat.ID, Title: msg.Chat.Title}); err != nil {
+			log.Printf("resolveGame: failed to save game info for chatID=%d: %v", msg.Chat.ID, err)
+		}
+		return msg.Chat.ID, true
+	}
+
+	userID := msg.From.ID
+	gameIDs, err := s.Storage.GetUserGames(ctx, userID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения списка игр: %v", err))
+		return 0, false
+	}
+
+	selected, err := s.Storage.GetSelectedGame(ctx, userID)
+	if err != nil {
+		log.Printf("resolveGame: failed to get selected game for userID=%d: %v", userID, err)
+	} else if selected != 0 && s.canSelectGame(ctx, msg.From, gameIDs, selected) {
+		return selected, true
+	}
+
+	switch len(gameIDs) {
+	case 0:
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Вы пока не участвуете ни в одной игре.\n\n"+
+			"Добавьте бота в групповой чат и используйте там /add. Игра ведется отдельно для каждого чата.")
+		return 0, false
+	case 1:
+		return gameIDs[0], true
+	}
+
+	s.sendGameList(ctx, msg.Chat.ID, gameIDs, 0, "🎄 Вы участвуете в нескольких играх. Выберите, к какой относится команда:")
+	return 0, false
+}
+
+func (s *SecretSantaBot) canSelectGame(ctx context.Context, user *tgbotapi.User, userGames []int64, gameID int64) bool {
+	if containsGame(userGames, gameID) {
+		return true
+	}
+	if !s.IsAdmin(user.UserName) {
+		return false
+	}
+	game, err := s.Storage.GetGame(ctx, gameID)
+	return err == nil && game != nil
+}
+
+func (s *SecretSantaBot) sendGameList(ctx context.Context, chatID int64, gameIDs []int64, selected int64, header string) {
+	sort.Slice(gameIDs, func(i, j int) bool { return gameIDs[i] < gameIDs[j] })
+
+	var list strings.Builder
+	list.WriteString(header)
+	list.WriteString("\n\n")
+	for _, gameID := range gameIDs {
+		marker := "•"
+		if gameID == selected {
+			marker = "✅"
+		}
+		list.WriteString(fmt.Sprintf("%s %s — /game %d\n", marker, s.gameTitle(ctx, gameID), gameID))
+	}
+	list.WriteString("\nПосле выбора все команды в личных сообщениях будут относиться к выбранной игре.")
+	s.sendMessage(ctx, chatID, list.String())
+}
+
+func (s *SecretSantaBot) handleSelectGame(ctx context.Context, msg *tgbotapi.Message) {
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("ℹ️ В этом чате используется его собственная игра: %s (ID: %d).", s.gameTitle(ctx, msg.Chat.ID), msg.Chat.ID))
+		return
+	}
+
+	userID := msg.From.ID
+	gameIDs, err := s.Storage.GetUserGames(ctx, userID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения списка игр: %v", err))
+		return
+	}
+
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		if s.IsAdmin(msg.From.UserName) {
+			games, err := s.Storage.GetAllGames(ctx)
+			if err == nil {
+				for gameID := range games {
+					if !containsGame(gameIDs, gameID) {
+						gameIDs = append(gameIDs, gameID)
+					}
+				}
+			}
+		}
+		if len(gameIDs) == 0 {
+			s.sendMessage(ctx, msg.Chat.ID, "📝 Игр пока нет. Добавьте бота в групповой чат и используйте там /add.")
+			return
+		}
+		selected, _ := s.Storage.GetSelectedGame(ctx, userID)
+		s.sendGameList(ctx, msg.Chat.ID, gameIDs, selected, "🎄 Доступные игры:")
+		return
+	}
+
+	gameID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите ID игры. Пример: /game -1001234567890\n\nСписок игр: /game")
+		return
+	}
+
+	if !s.canSelectGame(ctx, msg.From, gameIDs, gameID) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Вы не участвуете в этой игре.")
+		return
+	}
+
+	if err := s.Storage.SaveSelectedGame(ctx, userID, gameID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при выборе игры: %v", err))
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Выбрана игра: %s", s.gameTitle(ctx, gameID)))
+}
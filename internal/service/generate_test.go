@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+	"telegram-secret-santa/internal/fairness"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testGameID = int64(-100)
+
+func newTestBot(storage domain.StorageInterface) *SecretSantaBot {
+	return &SecretSantaBot{
+		Storage: storage,
+		Outbox:  NewOutbox(storage, nil),
+		Admins:  map[string]bool{"admin": true},
+	}
+}
+
+func addTestParticipants(t *testing.T, storage domain.StorageInterface, ids ...int64) {
+	t.Helper()
+	for _, id := range ids {
+		mustDo(t, storage.SaveParticipant(context.Background(), testGameID, &domain.Participant{UserID: id, FullName: "user"}))
+	}
+}
+
+func queuedTexts(t *testing.T, storage domain.StorageInterface, chatID int64) []string {
+	t.Helper()
+	messages, err := storage.GetDueOutbound(context.Background(), time.Now().Add(time.Hour), 1000)
+	mustDo(t, err)
+	var texts []string
+	for _, m := range messages {
+		if m.ChatID == chatID {
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
+
+func TestGenerateAssignmentsRespectsRestrictions(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3, 4, 5)
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 1, 2, 1))
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 2, 1, 1))
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 3, 4, 1))
+
+	for i := 0; i < 20; i++ {
+		if _, err := bot.GenerateAssignments(ctx, testGameID); err != nil {
+			t.Fatal(err)
+		}
+		assignments, err := storage.GetAllAssignments(ctx, testGameID)
+		mustDo(t, err)
+
+		received := make(map[int64]bool)
+		for giverID, receiverID := range assignments {
+			if giverID == receiverID {
+				t.Fatalf("%d gives to themselves: %v", giverID, assignments)
+			}
+			if received[receiverID] {
+				t.Fatalf("%d receives twice: %v", receiverID, assignments)
+			}
+			received[receiverID] = true
+		}
+		if len(assignments) != 5 {
+			t.Fatalf("got %d assignments, want 5", len(assignments))
+		}
+		if assignments[1] == 2 || assignments[2] == 1 || assignments[3] == 4 {
+			t.Fatalf("restriction violated: %v", assignments)
+		}
+	}
+}
+
+func TestGenerateAssignmentsChainMode(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3, 4, 5, 6)
+	mustDo(t, storage.SaveGameSettings(ctx, testGameID, &domain.GameSettings{Mode: domain.AssignmentModeChain}))
+
+	if _, err := bot.GenerateAssignments(ctx, testGameID); err != nil {
+		t.Fatal(err)
+	}
+	assignments, err := storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+
+	visited := map[int64]bool{}
+	for id := int64(1); !visited[id]; id = assignments[id] {
+		visited[id] = true
+	}
+	if len(visited) != 6 {
+		t.Fatalf("assignments do not form a single cycle: %v", assignments)
+	}
+}
+
+func TestGenerateAssignmentsPublishesVerifiableCommitment(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3, 4, 5, 6, 7)
+	mustDo(t, storage.SaveEntropy(ctx, testGameID, 1, &domain.Entropy{Nonce: "n1", Text: "снег"}))
+	mustDo(t, storage.SaveEntropy(ctx, testGameID, 5, &domain.Entropy{Nonce: "n5", Text: "мандарины"}))
+
+	if _, err := bot.GenerateAssignments(ctx, testGameID); err != nil {
+		t.Fatal(err)
+	}
+	first, err := storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	record, err := storage.GetDrawRecord(ctx, testGameID)
+	mustDo(t, err)
+	if record == nil || record.Pairs != 7 {
+		t.Fatalf("draw record = %+v", record)
+	}
+
+	announcements := queuedTexts(t, storage, testGameID)
+	if len(announcements) != 1 || !strings.Contains(announcements[0], record.Commitment) {
+		t.Fatalf("group announcements = %q; want the commitment %s", announcements, record.Commitment)
+	}
+
+	proofText, err := bot.formatProof(ctx, testGameID)
+	mustDo(t, err)
+	proof, err := fairness.ParseProof(proofText[strings.Index(proofText, "secret-santa:v1"):])
+	mustDo(t, err)
+	if err := proof.Verify(record.Commitment); err != nil {
+		t.Fatalf("published proof does not verify: %v", err)
+	}
+
+	if _, err := bot.GenerateAssignments(ctx, testGameID); err != nil {
+		t.Fatal(err)
+	}
+	second, err := storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("same entropy produced different draws: %v and %v", first, second)
+	}
+}
+
+// failingEnqueueStorage отказывает в постановке рассылки в очередь; остальные
+// сообщения проходят как обычно.
+type failingEnqueueStorage struct {
+	*MemoryStorage
+}
+
+func (s *failingEnqueueStorage) EnqueueOutboundBatch(ctx context.Context, b *domain.OutboundBatch, deliveries []*domain.Delivery, messages []*domain.OutboundMessage) error {
+	return errors.New("queue is unavailable")
+}
+
+func TestStartGameIsNotMarkedStartedWhenEnqueueFails(t *testing.T) {
+	ctx := context.Background()
+	storage := &failingEnqueueStorage{MemoryStorage: NewMemoryStorage()}
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3)
+	if _, err := bot.GenerateAssignments(ctx, testGameID); err != nil {
+		t.Fatal(err)
+	}
+	mustDo(t, storage.SaveGameState(ctx, testGameID, true, false))
+
+	msg := &tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1, UserName: "admin"},
+		Chat: &tgbotapi.Chat{ID: testGameID, Type: "supergroup"},
+	}
+	bot.handleSendAssignments(ctx, msg, testGameID)
+
+	_, started, err := storage.GetGameState(ctx, testGameID)
+	mustDo(t, err)
+	if started {
+		t.Fatalf("game was marked started although the assignments were not queued")
+	}
+	replies := queuedTexts(t, storage, testGameID)
+	if len(replies) == 0 || !strings.Contains(replies[len(replies)-1], "Ошибка постановки сообщений в очередь") {
+		t.Fatalf("group replies = %q; want the enqueue error", replies)
+	}
+}
+
+func TestRefreshReachabilityProbesOnlyWhatItCanCheck(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	messenger := newRecordingMessenger()
+	bot := NewSecretSantaBot(messenger, tgbotapi.User{ID: 1000, IsBot: true, UserName: "santa_bot"}, []string{"admin"}, storage, nil)
+	addTestParticipants(t, storage, 1, 2, 3, 4, 5)
+
+	// 1 - ничего не известно; 2 - недавно был доступен; 3 - заблокировал бота;
+	// 4 - не начинал диалог, но с тех пор начал; 5 - так и не начал диалог.
+	mustDo(t, storage.SaveReachability(ctx, 2, &domain.Reachability{Reachable: true, CheckedAt: time.Now()}))
+	mustDo(t, storage.SaveReachability(ctx, 3, &domain.Reachability{ErrorCode: 403, CheckedAt: time.Now()}))
+	mustDo(t, storage.SaveReachability(ctx, 4, &domain.Reachability{ErrorCode: 400, CheckedAt: time.Now()}))
+	messenger.blocked[5] = true
+
+	participants, err := storage.GetAllParticipants(ctx, testGameID)
+	mustDo(t, err)
+	bot.refreshReachability(ctx, participants)
+
+	probes := append([]int64(nil), messenger.probes...)
+	if !reflect.DeepEqual(sortedIDs(probes), []int64{1, 4, 5}) {
+		t.Fatalf("probed %v, want [1 4 5]", probes)
+	}
+	if len(messenger.messages()) != 0 {
+		t.Fatalf("probing sent messages: %+v", messenger.messages())
+	}
+	if unreachable := bot.findUnreachable(ctx, participants); !reflect.DeepEqual(unreachable, []int64{3, 5}) {
+		t.Fatalf("unreachable = %v, want [3 5]", unreachable)
+	}
+}
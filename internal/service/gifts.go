@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const giftCallbackPrefix = "gift:"
+
+var giftStatuses = []domain.GiftStatus{
+	domain.GiftNotStarted,
+	domain.GiftBought,
+	domain.GiftShipped,
+	domain.GiftDelivered,
+	domain.GiftReceived,
+}
+
+func giftStatusTitle(status domain.GiftStatus) string {
+	switch status {
+	case domain.GiftBought:
+		return "🛍 куплен"
+	case domain.GiftShipped:
+		return "📦 отправлен"
+	case domain.GiftDelivered:
+		return "🚚 доставлен"
+	case domain.GiftReceived:
+		return "🎉 получен"
+	}
+	return "⏳ не начат"
+}
+
+func parseGiverStatus(arg string) (domain.GiftStatus, bool) {
+	switch domain.GiftStatus(arg) {
+	case domain.GiftBought, domain.GiftShipped, domain.GiftDelivered:
+		return domain.GiftStatus(arg), true
+	}
+	return "", false
+}
+
+func giftCallbackData(gameID int64, status domain.GiftStatus) string {
+	return fmt.Sprintf("%s%d:%s", giftCallbackPrefix, gameID, status)
+}
+
+func giftButtons(gameID int64) [][]domain.OutboundButton {
+	return [][]domain.OutboundButton{
+		{
+			{Text: "🛍 Купил(а)", Data: giftCallbackData(gameID, domain.GiftBought)},
+			{Text: "📦 Отправил(а)", Data: giftCallbackData(gameID, domain.GiftShipped)},
+		},
+		{
+			{Text: "🚚 Доставлен", Data: giftCallbackData(gameID, domain.GiftDelivered)},
+		},
+	}
+}
+
+func receivedButton(gameID int64) [][]domain.OutboundButton {
+	return [][]domain.OutboundButton{
+		{{Text: "🎉 Я получил(а) подарок", Data: giftCallbackData(gameID, domain.GiftReceived)}},
+	}
+}
+
+// updateGiftStatus меняет статус подарка от имени userID. Статусы «куплен», «отправлен»
+// и «доставлен» ставит даритель, «получен» - только получатель. Возвращает текст ответа.
+func (s *SecretSantaBot) updateGiftStatus(ctx context.Context, gameID, userID int64, status domain.GiftStatus) (string, error) {
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get game state: %w", err)
+	}
+	if !gameStarted {
+		return "⏳ Игра еще не началась.", nil
+	}
+
+	giverID, receiverID := userID, int64(0)
+	if status == domain.GiftReceived {
+		giverID, err = s.santaOf(ctx, gameID, userID)
+		if err != nil {
+			return "", err
+		}
+		if giverID == 0 {
+			return "❌ У вас пока нет Тайного Санты.", nil
+		}
+		receiverID = userID
+	} else {
+		receiverID, err = s.Storage.GetAssignment(ctx, gameID, userID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get assignment: %w", err)
+		}
+		if receiverID == 0 {
+			return "❌ У вас пока нет получателя.", nil
+		}
+	}
+
+	current, err := s.Storage.GetGiftStatus(ctx, gameID, giverID)
+	if err != nil {
+		return "", err
+	}
+	if current == status {
+		return fmt.Sprintf("Статус подарка уже: %s", giftStatusTitle(status)), nil
+	}
+	if current == domain.GiftReceived {
+		return "🎉 Получатель уже подтвердил, что получил подарок.", nil
+	}
+	if err := s.Storage.SaveGiftStatus(ctx, gameID, giverID, status); err != nil {
+		return "", fmt.Errorf("failed to save gift status: %w", err)
+	}
+	log.Printf("updateGiftStatus: gameID=%d, gift status changed to %q", gameID, status)
+
+	title := s.gameTitle(ctx, gameID)
+	switch status {
+	case domain.GiftShipped:
+		s.sendMessage(ctx, receiverID, fmt.Sprintf("📦 Ваш Тайный Санта (игра «%s») отправил вам подарок!", title))
+	case domain.GiftDelivered:
+		text := fmt.Sprintf("🚚 Ваш Тайный Санта (игра «%s») сообщает, что подарок доставлен. Когда получите его, нажмите кнопку или отправьте /gotit.", title)
+		if err := s.Outbox.SendWithButtons(ctx, receiverID, text, receivedButton(gameID)); err != nil {
+			log.Printf("updateGiftStatus: failed to notify receiver in gameID=%d: %v", gameID, err)
+		}
+	case domain.GiftReceived:
+		s.sendMessage(ctx, giverID, fmt.Sprintf("🎉 Получатель подтвердил, что получил ваш подарок (игра «%s»). Спасибо, Санта!", title))
+		return "🎉 Спасибо! Ваш Тайный Санта узнает, что подарок дошел.", nil
+	}
+	return fmt.Sprintf("✅ Статус подарка: %s", giftStatusTitle(status)), nil
+}
+
+func (s *SecretSantaBot) handleGift(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	arg := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if arg == "" {
+		receiverID, err := s.Storage.GetAssignment(ctx, gameID, msg.From.ID)
+		if err != nil || receiverID == 0 {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ У вас пока нет получателя.")
+			return
+		}
+		status, err := s.Storage.GetGiftStatus(ctx, gameID, msg.From.ID)
+		if err != nil {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения статуса подарка: %v", err))
+			return
+		}
+
+		text := fmt.Sprintf("🎁 Статус вашего подарка: %s\n\n"+
+			"Изменить: /gift bought (куплен), /gift shipped (отправлен), /gift delivered (доставлен)", giftStatusTitle(status))
+		if isGroupChat(msg.Chat) {
+			s.sendMessage(ctx, msg.Chat.ID, text)
+			return
+		}
+		if err := s.Outbox.SendWithButtons(ctx, msg.Chat.ID, text, giftButtons(gameID)); err != nil {
+			s.sendMessage(ctx, msg.Chat.ID, text)
+		}
+		return
+	}
+
+	status, ok := parseGiverStatus(arg)
+	if !ok {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Неизвестный статус. Доступные статусы:\n\n"+
+			"/gift bought - подарок куплен\n"+
+			"/gift shipped - подарок отправлен\n"+
+			"/gift delivered - подарок доставлен\n\n"+
+			"Получатель подтверждает получение командой /gotit")
+		return
+	}
+
+	result, err := s.updateGiftStatus(ctx, gameID, msg.From.ID, status)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка обновления статуса подарка: %v", err))
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, result)
+}
+
+func (s *SecretSantaBot) handleGotIt(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	result, err := s.updateGiftStatus(ctx, gameID, msg.From.ID, domain.GiftReceived)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка обновления статуса подарка: %v", err))
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, result)
+}
+
+func (s *SecretSantaBot) HandleCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	answer := func(text string) {
+		if _, err := s.Bot.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+			log.Printf("HandleCallback: failed to answer callback: %v", err)
+		}
+	}
+
+	if !strings.HasPrefix(query.Data, giftCallbackPrefix) {
+		answer("")
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(query.Data, giftCallbackPrefix), ":", 2)
+	if len(parts) != 2 {
+		answer("")
+		return
+	}
+	gameID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		answer("")
+		return
+	}
+
+	status, ok := parseGiverStatus(parts[1])
+	if !ok && domain.GiftStatus(parts[1]) == domain.GiftReceived {
+		status, ok = domain.GiftReceived, true
+	}
+	if !ok {
+		answer("")
+		return
+	}
+
+	result, err := s.updateGiftStatus(ctx, gameID, query.From.ID, status)
+	if err != nil {
+		log.Printf("HandleCallback: failed to update gift status in gameID=%d: %v", gameID, err)
+		answer("❌ Не удалось обновить статус подарка")
+		return
+	}
+	answer(result)
+}
+
+// formatGiftProgress показывает администратору, сколько подарков в каком статусе, и кто из
+// дарителей еще не начал. Получатели не упоминаются, поэтому пары не раскрываются.
+func formatGiftProgress(participants map[int64]*domain.Participant, assignments map[int64]int64, statuses map[int64]domain.GiftStatus) string {
+	if len(assignments) == 0 {
+		return ""
+	}
+
+	counts := make(map[domain.GiftStatus]int)
+	var notStarted []string
+	for giverID := range assignments {
+		status := statuses[giverID]
+		counts[status]++
+		if status == domain.GiftNotStarted {
+			notStarted = append(notStarted, participantDisplayName(participants, giverID))
+		}
+	}
+	sort.Strings(notStarted)
+
+	var progress strings.Builder
+	progress.WriteString("\n\n🎁 Подарки:\n")
+	for _, status := range giftStatuses {
+		progress.WriteString(fmt.Sprintf("%s: %d\n", giftStatusTitle(status), counts[status]))
+	}
+	if len(notStarted) > 0 {
+		progress.WriteString(fmt.Sprintf("Еще не начали: %s", strings.Join(notStarted, ", ")))
+	}
+	return strings.TrimRight(progress.String(), "\n")
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func groupPairs(groups map[string]*domain.Group) map[int64]map[int64]int {
+	pairs := make(map[int64]map[int64]int)
+	for _, group := range groups {
+		for _, giverID := range group.Members {
+			for _, receiverID := range group.Members {
+				if giverID == receiverID {
+					continue
+				}
+				if pairs[giverID] == nil {
+					pairs[giverID] = make(map[int64]int)
+				}
+				pairs[giverID][receiverID]++
+			}
+		}
+	}
+	return pairs
+}
+
+func groupHasMember(group *domain.Group, userID int64) bool {
+	for _, memberID := range group.Members {
+		if memberID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedGroups(groups map[string]*domain.Group) []*domain.Group {
+	result := make([]*domain.Group, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name)
+	})
+	return result
+}
+
+func (s *SecretSantaBot) restrictionSource(ctx context.Context, gameID int64, r Restriction) string {
+	explicit, err := s.Storage.HasRestriction(ctx, gameID, r.UserID, r.ForbiddenUserID)
+	if err == nil && explicit {
+		return ""
+	}
+
+	groups, err := s.Storage.GetAllGroups(ctx, gameID)
+	if err == nil {
+		for _, group := range sortedGroups(groups) {
+			if groupHasMember(group, r.UserID) && groupHasMember(group, r.ForbiddenUserID) {
+				return fmt.Sprintf(" (группа «%s»)", group.Name)
+			}
+		}
+	}
+
+	return " (повтор из прошлого сезона)"
+}
+
+func (s *SecretSantaBot) removeFromGroups(ctx context.Context, gameID, userID int64) error {
+	groups, err := s.Storage.GetAllGroups(ctx, gameID)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if !groupHasMember(group, userID) {
+			continue
+		}
+		members := make([]int64, 0, len(group.Members))
+		for _, memberID := range group.Members {
+			if memberID != userID {
+				members = append(members, memberID)
+			}
+		}
+		group.Members = members
+		if err := s.Storage.SaveGroup(ctx, gameID, group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatGroups(groups map[string]*domain.Group, participants map[int64]*domain.Participant, markdown bool) string {
+	var list strings.Builder
+	for _, group := range sortedGroups(groups) {
+		name := group.Name
+		if markdown {
+			name = escapeMarkdown(name)
+			list.WriteString(fmt.Sprintf("*%s* \\(не дарят друг другу\\):\n", name))
+		} else {
+			list.WriteString(fmt.Sprintf("%s (не дарят друг другу):\n", name))
+		}
+		if len(group.Members) == 0 {
+			if markdown {
+				list.WriteString("  \\- пока никого\n")
+			} else {
+				list.WriteString("  - пока никого\n")
+			}
+		}
+		for _, memberID := range group.Members {
+			memberName := participantDisplayName(participants, memberID)
+			if markdown {
+				memberName = escapeMarkdown(memberName)
+				list.WriteString(fmt.Sprintf("  \\- %s\n", memberName))
+			} else {
+				list.WriteString(fmt.Sprintf("  - %s\n", memberName))
+			}
+		}
+		list.WriteString("\n")
+	}
+	return list.String()
+}
+
+func (s *SecretSantaBot) handleGroup(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		s.handleListGroups(ctx, msg, gameID)
+		return
+	}
+
+	usage := "❌ Используйте:\n\n" +
+		"/group create Название - создать группу\n" +
+		"/group add Название @username - добавить участника в группу\n" +
+		"/group remove Название @username - убрать участника из группы\n" +
+		"/group delete Название - удалить группу\n" +
+		"/group - список групп\n\n" +
+		"Участники одной группы никогда не дарят подарки друг другу."
+	if len(args) < 2 {
+		s.sendMessage(ctx, msg.Chat.ID, usage)
+		return
+	}
+
+	action := strings.ToLower(args[0])
+	name := args[1]
+	switch action {
+	case "create":
+		s.handleCreateGroup(ctx, msg, gameID, name)
+	case "add":
+		s.handleGroupMembers(ctx, msg, gameID, name, args[2:], true)
+	case "remove":
+		s.handleGroupMembers(ctx, msg, gameID, name, args[2:], false)
+	case "delete":
+		s.handleDeleteGroup(ctx, msg, gameID, name)
+	default:
+		s.sendMessage(ctx, msg.Chat.ID, usage)
+	}
+}
+
+func (s *SecretSantaBot) handleCreateGroup(ctx context.Context, msg *tgbotapi.Message, gameID int64, name string) {
+	existing, err := s.Storage.GetParticipant(ctx, gameID, msg.From.ID)
+	if (err != nil || existing == nil) && !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
+		return
+	}
+
+	group, err := s.Storage.GetGroup(ctx, gameID, name)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения группы: %v", err))
+		return
+	}
+	if group != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("ℹ️ Группа «%s» уже существует.", group.Name))
+		return
+	}
+
+	group = &domain.Group{
+		Name:      name,
+		CreatorID: msg.From.ID,
+	}
+	if err := s.Storage.SaveGroup(ctx, gameID, group); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при создании группы: %v", err))
+		return
+	}
+	log.Printf("handleCreateGroup: user %d created group %q in gameID=%d", msg.From.ID, name, gameID)
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Группа «%s» создана. Добавьте участников: /group add %s @username", name, name))
+}
+
+func (s *SecretSantaBot) groupForEdit(ctx context.Context, msg *tgbotapi.Message, gameID int64, name string) *domain.Group {
+	group, err := s.Storage.GetGroup(ctx, gameID, name)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения группы: %v", err))
+		return nil
+	}
+	if group == nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Группа «%s» не найдена. Создайте ее: /group create %s", name, name))
+		return nil
+	}
+	if group.CreatorID != msg.From.ID && !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Изменять группу может только ее создатель или администратор.")
+		return nil
+	}
+	return group
+}
+
+func (s *SecretSantaBot) handleGroupMembers(ctx context.Context, msg *tgbotapi.Message, gameID int64, name string, usernames []string, add bool) {
+	if len(usernames) == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Укажите участников. Пример: /group add %s @username", name))
+		return
+	}
+
+	group := s.groupForEdit(ctx, msg, gameID, name)
+	if group == nil {
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+
+	var changed, notFound []string
+	for _, username := range usernames {
+		userID, found := findParticipantByUsername(participants, username)
+		if !found {
+			notFound = append(notFound, username)
+			continue
+		}
+		if add == groupHasMember(group, userID) {
+			continue
+		}
+		if add {
+			group.Members = append(group.Members, userID)
+		} else {
+			members := make([]int64, 0, len(group.Members))
+			for _, memberID := range group.Members {
+				if memberID != userID {
+					members = append(members, memberID)
+				}
+			}
+			group.Members = members
+		}
+		changed = append(changed, participantDisplayName(participants, userID))
+	}
+
+	if len(changed) > 0 {
+		if err := s.Storage.SaveGroup(ctx, gameID, group); err != nil {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении группы: %v", err))
+			return
+		}
+	}
+
+	var result strings.Builder
+	if len(changed) > 0 {
+		if add {
+			result.WriteString(fmt.Sprintf("✅ В группу «%s» добавлены:\n", group.Name))
+		} else {
+			result.WriteString(fmt.Sprintf("✅ Из группы «%s» убраны:\n", group.Name))
+		}
+		for _, memberName := range changed {
+			result.WriteString(fmt.Sprintf("• %s\n", memberName))
+		}
+	} else {
+		result.WriteString(fmt.Sprintf("ℹ️ Группа «%s» не изменилась.\n", group.Name))
+	}
+	if len(notFound) > 0 {
+		result.WriteString(fmt.Sprintf("\n❌ Не найдены среди участников: %s", strings.Join(notFound, ", ")))
+	}
+	s.sendMessage(ctx, msg.Chat.ID, result.String())
+}
+
+func (s *SecretSantaBot) handleDeleteGroup(ctx context.Context, msg *tgbotapi.Message, gameID int64, name string) {
+	group := s.groupForEdit(ctx, msg, gameID, name)
+	if group == nil {
+		return
+	}
+
+	if err := s.Storage.DeleteGroup(ctx, gameID, group.Name); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении группы: %v", err))
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Группа «%s» удалена.", group.Name))
+}
+
+func (s *SecretSantaBot) handleListGroups(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	groups, err := s.Storage.GetAllGroups(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения групп: %v", err))
+		return
+	}
+
+	if len(groups) == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, "👨‍👩‍👧 Групп пока нет. Создайте группу: /group create Название")
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+
+	s.sendMessage(ctx, msg.Chat.ID, "👨‍👩‍👧 Группы:\n\n"+formatGroups(groups, participants, false))
+}
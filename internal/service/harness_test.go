@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type recordedMessage struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+	Buttons   []string
+}
+
+// recordingMessenger - Messenger для сценарных тестов: запоминает отправленные сообщения
+// и ответы на нажатия кнопок, не обращаясь к сети.
+type recordingMessenger struct {
+	mu          sync.Mutex
+	nextID      int
+	sent        []recordedMessage
+	answers     []string
+	probes      []int64
+	blocked     map[int64]bool
+	admins      map[int64][]tgbotapi.ChatMember
+	memberCount int
+}
+
+func newRecordingMessenger() *recordingMessenger {
+	return &recordingMessenger{
+		nextID:  1,
+		blocked: make(map[int64]bool),
+		admins:  make(map[int64][]tgbotapi.ChatMember),
+	}
+}
+
+func (m *recordingMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, ok := c.(tgbotapi.MessageConfig)
+	if !ok {
+		return tgbotapi.Message{}, fmt.Errorf("recordingMessenger: unsupported %T", c)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.blocked[msg.ChatID] {
+		return tgbotapi.Message{}, &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}
+	}
+
+	recorded := recordedMessage{ChatID: msg.ChatID, MessageID: m.nextID, Text: msg.Text}
+	if markup, ok := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+		for _, row := range markup.InlineKeyboard {
+			for _, button := range row {
+				if button.CallbackData != nil {
+					recorded.Buttons = append(recorded.Buttons, *button.CallbackData)
+				}
+			}
+		}
+	}
+	m.nextID++
+	m.sent = append(m.sent, recorded)
+	return tgbotapi.Message{MessageID: recorded.MessageID, Chat: &tgbotapi.Chat{ID: msg.ChatID}, Text: msg.Text}, nil
+}
+
+func (m *recordingMessenger) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch c := c.(type) {
+	case tgbotapi.ChatInfoConfig:
+		m.probes = append(m.probes, c.ChatID)
+		if m.blocked[c.ChatID] {
+			return nil, &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}
+		}
+	case tgbotapi.CallbackConfig:
+		m.answers = append(m.answers, c.Text)
+	default:
+		return nil, fmt.Errorf("recordingMessenger: unsupported %T", c)
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (m *recordingMessenger) GetChatAdministrators(config tgbotapi.ChatAdministratorsConfig) ([]tgbotapi.ChatMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.admins[config.ChatID], nil
+}
+
+func (m *recordingMessenger) GetChatMembersCount(config tgbotapi.ChatMemberCountConfig) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.memberCount, nil
+}
+
+func (m *recordingMessenger) messages() []recordedMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]recordedMessage(nil), m.sent...)
+}
+
+// scenario описывает игру как последовательность сообщений пользователей и проверок
+// ответов бота. Каждое сообщение бота должно быть проверено через expect/expectGroup до
+// следующего действия, поэтому сценарий фиксирует все, что получает каждый участник.
+// Пользователи называются по username; администратор игры - "admin".
+type scenario struct {
+	t         *testing.T
+	ctx       context.Context
+	bot       *SecretSantaBot
+	messenger *recordingMessenger
+	chat      tgbotapi.Chat
+	users     map[string]tgbotapi.User
+	checked   int
+	expected  map[int64]bool
+}
+
+func newScenario(t *testing.T) *scenario {
+	storage := NewMemoryStorage()
+	messenger := newRecordingMessenger()
+	bot := NewSecretSantaBot(messenger, tgbotapi.User{ID: 1000, IsBot: true, UserName: "santa_bot"}, []string{"admin"}, storage, []string{"мат"})
+	bot.Outbox.limits = outboxLimits{}
+	return &scenario{
+		t:         t,
+		ctx:       context.Background(),
+		bot:       bot,
+		messenger: messenger,
+		chat:      tgbotapi.Chat{ID: testGameID, Type: "supergroup", Title: "Офис"},
+		users:     make(map[string]tgbotapi.User),
+		expected:  make(map[int64]bool),
+	}
+}
+
+// user возвращает пользователя по username, заводя его при первом упоминании.
+func (sc *scenario) user(name string) tgbotapi.User {
+	u, ok := sc.users[name]
+	if !ok {
+		u = tgbotapi.User{ID: int64(len(sc.users) + 1), FirstName: strings.ToUpper(name[:1]) + name[1:], UserName: name}
+		sc.users[name] = u
+	}
+	return u
+}
+
+func (sc *scenario) userID(name string) int64 {
+	return sc.user(name).ID
+}
+
+// group отправляет text от пользователя в групповой чат игры.
+func (sc *scenario) group(from, text string) {
+	sc.t.Helper()
+	sc.send(sc.chat, from, text)
+}
+
+// private отправляет text от пользователя в личный чат с ботом.
+func (sc *scenario) private(from, text string) {
+	sc.t.Helper()
+	u := sc.user(from)
+	sc.send(tgbotapi.Chat{ID: u.ID, Type: "private", UserName: u.UserName, FirstName: u.FirstName}, from, text)
+}
+
+func (sc *scenario) send(chat tgbotapi.Chat, from, text string) {
+	sc.t.Helper()
+	sc.expectNothingElse()
+	u := sc.user(from)
+	msg := &tgbotapi.Message{From: &u, Chat: &chat, Text: text}
+	if strings.HasPrefix(text, "/") {
+		command := strings.Fields(text)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len([]rune(command))}}
+	}
+	sc.bot.HandleUpdate(sc.ctx, tgbotapi.Update{Message: msg})
+	sc.bot.Outbox.Flush(sc.ctx)
+}
+
+// reply отвечает пользователем на последнее сообщение бота в его личном чате.
+func (sc *scenario) reply(from, text string) {
+	sc.t.Helper()
+	u := sc.user(from)
+	sent := sc.messenger.messages()
+	var original *recordedMessage
+	for i := len(sent) - 1; i >= 0 && original == nil; i-- {
+		if sent[i].ChatID == u.ID {
+			original = &sent[i]
+		}
+	}
+	if original == nil {
+		sc.t.Fatalf("%s has no messages to reply to", from)
+	}
+
+	sc.expectNothingElse()
+	chat := tgbotapi.Chat{ID: u.ID, Type: "private"}
+	msg := &tgbotapi.Message{
+		From: &u,
+		Chat: &chat,
+		Text: text,
+		ReplyToMessage: &tgbotapi.Message{
+			MessageID: original.MessageID,
+			From:      &sc.bot.Self,
+			Chat:      &chat,
+			Text:      original.Text,
+		},
+	}
+	sc.bot.HandleUpdate(sc.ctx, tgbotapi.Update{Message: msg})
+	sc.bot.Outbox.Flush(sc.ctx)
+}
+
+// press нажимает кнопку с данными data под последним сообщением бота пользователю,
+// у которого она есть, и возвращает всплывающий ответ бота.
+func (sc *scenario) press(from, data string) string {
+	sc.t.Helper()
+	u := sc.user(from)
+	sent := sc.messenger.messages()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].ChatID != u.ID {
+			continue
+		}
+		for _, button := range sent[i].Buttons {
+			if button != data {
+				continue
+			}
+			sc.expectNothingElse()
+			sc.bot.HandleUpdate(sc.ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+				ID:      fmt.Sprint(i),
+				From:    &u,
+				Message: &tgbotapi.Message{MessageID: sent[i].MessageID, Chat: &tgbotapi.Chat{ID: u.ID, Type: "private"}},
+				Data:    data,
+			}})
+			sc.bot.Outbox.Flush(sc.ctx)
+			sc.messenger.mu.Lock()
+			defer sc.messenger.mu.Unlock()
+			return sc.messenger.answers[len(sc.messenger.answers)-1]
+		}
+	}
+	sc.t.Fatalf("%s has no message with button %q", from, data)
+	return ""
+}
+
+// expect проверяет новые сообщения бота пользователю: по одному фрагменту текста на
+// сообщение, в порядке отправки. Пустой фрагмент подходит к любому сообщению.
+func (sc *scenario) expect(name string, fragments ...string) {
+	sc.t.Helper()
+	sc.expectChat(name, sc.userID(name), fragments)
+}
+
+func (sc *scenario) expectGroup(fragments ...string) {
+	sc.t.Helper()
+	sc.expectChat("group", sc.chat.ID, fragments)
+}
+
+func (sc *scenario) expectChat(name string, chatID int64, fragments []string) {
+	sc.t.Helper()
+	sent := sc.messenger.messages()
+	var got []string
+	for _, m := range sent[sc.checked:] {
+		if m.ChatID == chatID {
+			got = append(got, m.Text)
+		}
+	}
+	if len(got) != len(fragments) {
+		sc.t.Fatalf("%s got %d messages, want %d:\n%s", name, len(got), len(fragments), strings.Join(got, "\n---\n"))
+	}
+	for i, fragment := range fragments {
+		if !strings.Contains(got[i], fragment) {
+			sc.t.Fatalf("%s message %d does not contain %q:\n%s", name, i+1, fragment, got[i])
+		}
+	}
+	sc.expected[chatID] = true
+	sc.advance(sent)
+}
+
+// expectNothingElse проверяет, что все сообщения бота уже разобраны проверками.
+func (sc *scenario) expectNothingElse() {
+	sc.t.Helper()
+	unread := sc.unread()
+	if len(unread) == 0 {
+		return
+	}
+	var report strings.Builder
+	for _, m := range unread {
+		fmt.Fprintf(&report, "to %s:\n%s\n---\n", sc.chatName(m.ChatID), m.Text)
+	}
+	sc.t.Fatalf("%d unchecked messages:\n%s", len(unread), report.String())
+}
+
+func (sc *scenario) unread() []recordedMessage {
+	var unread []recordedMessage
+	for _, m := range sc.messenger.messages()[sc.checked:] {
+		if !sc.expected[m.ChatID] {
+			unread = append(unread, m)
+		}
+	}
+	return unread
+}
+
+// advance сдвигает границу проверенных сообщений, когда проверены все чаты,
+// получившие что-то после нее.
+func (sc *scenario) advance(sent []recordedMessage) {
+	for _, m := range sent[sc.checked:] {
+		if !sc.expected[m.ChatID] {
+			return
+		}
+	}
+	sc.checked = len(sent)
+	sc.expected = make(map[int64]bool)
+}
+
+func (sc *scenario) chatName(chatID int64) string {
+	if chatID == sc.chat.ID {
+		return "group"
+	}
+	for name, u := range sc.users {
+		if u.ID == chatID {
+			return name
+		}
+	}
+	return fmt.Sprint(chatID)
+}
+
+// receiver возвращает username получателя, которого жеребьевка назначила пользователю.
+func (sc *scenario) receiver(name string) string {
+	sc.t.Helper()
+	receiverID, err := sc.bot.Storage.GetAssignment(sc.ctx, testGameID, sc.userID(name))
+	mustDo(sc.t, err)
+	return sc.chatName(receiverID)
+}
+
+// santa возвращает username того, кто дарит подарок пользователю.
+func (sc *scenario) santa(name string) string {
+	sc.t.Helper()
+	assignments, err := sc.bot.Storage.GetAllAssignments(sc.ctx, testGameID)
+	mustDo(sc.t, err)
+	for giverID, receiverID := range assignments {
+		if receiverID == sc.userID(name) {
+			return sc.chatName(giverID)
+		}
+	}
+	sc.t.Fatalf("nobody gives a gift to %s", name)
+	return ""
+}
+
+// display - имя пользователя так, как бот показывает его в сообщениях.
+func (sc *scenario) display(name string) string {
+	u := sc.user(name)
+	return fmt.Sprintf("%s (@%s)", u.FirstName, u.UserName)
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxHistorySeasons = 10
+
+func countPairs(pairs map[int64]map[int64]int) int {
+	total := 0
+	for _, receivers := range pairs {
+		total += len(receivers)
+	}
+	return total
+}
+
+func mergeRestrictions(restrictions map[int64]map[int64]bool, pairs map[int64]map[int64]int) map[int64]map[int64]bool {
+	merged := make(map[int64]map[int64]bool, len(restrictions))
+	for giverID, receivers := range restrictions {
+		merged[giverID] = make(map[int64]bool, len(receivers))
+		for receiverID := range receivers {
+			merged[giverID][receiverID] = true
+		}
+	}
+	for giverID, receivers := range pairs {
+		if merged[giverID] == nil {
+			merged[giverID] = make(map[int64]bool)
+		}
+		for receiverID := range receivers {
+			merged[giverID][receiverID] = true
+		}
+	}
+	return merged
+}
+
+func (s *SecretSantaBot) recentPairings(ctx context.Context, gameID int64, seasons int) (map[int64]map[int64]int, error) {
+	pairs := make(map[int64]map[int64]int)
+	if seasons <= 0 {
+		return pairs, nil
+	}
+
+	history, err := s.Storage.GetSeasons(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > seasons {
+		history = history[:seasons]
+	}
+
+	for _, season := range history {
+		for _, pairing := range season.Pairings {
+			if pairs[pairing.GiverID] == nil {
+				pairs[pairing.GiverID] = make(map[int64]int)
+			}
+			pairs[pairing.GiverID][pairing.ReceiverID]++
+		}
+	}
+	return pairs, nil
+}
+
+func (s *SecretSantaBot) ArchiveSeason(ctx context.Context, gameID int64) (bool, error) {
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get game state: %w", err)
+	}
+	if !gameStarted {
+		return false, nil
+	}
+
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get assignments: %w", err)
+	}
+	if len(assignments) == 0 {
+		return false, nil
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get participants: %w", err)
+	}
+
+	season := &domain.Season{
+		GameID: gameID,
+		Title:  s.gameTitle(ctx, gameID),
+		Date:   time.Now(),
+	}
+	if record, err := s.Storage.GetDrawRecord(ctx, gameID); err == nil && record != nil {
+		season.Commitment = record.Commitment
+	}
+	for giverID, receiverID := range assignments {
+		season.Pairings = append(season.Pairings, domain.Pairing{
+			GiverID:      giverID,
+			ReceiverID:   receiverID,
+			GiverName:    participantDisplayName(participants, giverID),
+			ReceiverName: participantDisplayName(participants, receiverID),
+		})
+	}
+
+	if err := s.Storage.SaveSeason(ctx, season); err != nil {
+		return false, fmt.Errorf("failed to save season: %w", err)
+	}
+	log.Printf("ArchiveSeason: archived %d pairings for gameID=%d", len(season.Pairings), gameID)
+	return true, nil
+}
+
+func (s *SecretSantaBot) handleHistory(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		return
+	}
+
+	args := strings.Fields(strings.ToLower(msg.CommandArguments()))
+	if len(args) > 0 && args[0] == "avoid" {
+		s.handleHistorySettings(ctx, msg, gameID, args[1:])
+		return
+	}
+
+	seasons, err := s.Storage.GetSeasons(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения истории: %v", err))
+		return
+	}
+
+	settings := s.getGameSettings(ctx, gameID)
+	var report strings.Builder
+	report.WriteString(fmt.Sprintf("📜 История игры «%s»\n\n", s.gameTitle(ctx, gameID)))
+	if settings.HistorySeasons > 0 {
+		kind := "по возможности"
+		if settings.HistoryHard {
+			kind = "строго"
+		}
+		report.WriteString(fmt.Sprintf("Повторы пар из последних %d сезонов исключаются %s.\n\n", settings.HistorySeasons, kind))
+	} else {
+		report.WriteString("Повторы пар из прошлых сезонов не учитываются. Включить: /history avoid N [soft|hard]\n\n")
+	}
+
+	if len(seasons) == 0 {
+		report.WriteString("Завершенных сезонов пока нет. Сезон попадает в историю при /reset после /startgame.")
+	}
+	for i, season := range seasons {
+		if i >= maxHistorySeasons {
+			report.WriteString(fmt.Sprintf("… и еще %d сезонов\n", len(seasons)-maxHistorySeasons))
+			break
+		}
+		report.WriteString(fmt.Sprintf("🎄 Сезон от %s (пар: %d):\n", season.Date.Format("02.01.2006"), len(season.Pairings)))
+		for _, pairing := range season.Pairings {
+			report.WriteString(fmt.Sprintf("  🎁 %s → %s\n", pairing.GiverName, pairing.ReceiverName))
+		}
+		report.WriteString("\n")
+	}
+
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, "📜 История сезонов отправлена вам в личные сообщения.")
+		s.sendMessage(ctx, msg.From.ID, report.String())
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, report.String())
+}
+
+func (s *SecretSantaBot) handleHistorySettings(ctx context.Context, msg *tgbotapi.Message, gameID int64, args []string) {
+	usage := "❌ Используйте: /history avoid N [soft|hard]\n\n" +
+		"N - сколько последних сезонов учитывать (0 - не учитывать)\n" +
+		"soft - избегать повторов по возможности (по умолчанию)\n" +
+		"hard - запретить повторы полностью"
+	if len(args) == 0 || len(args) > 2 {
+		s.sendMessage(ctx, msg.Chat.ID, usage)
+		return
+	}
+
+	seasons, err := strconv.Atoi(args[0])
+	if err != nil || seasons < 0 {
+		s.sendMessage(ctx, msg.Chat.ID, usage)
+		return
+	}
+
+	hard := false
+	if len(args) == 2 {
+		switch args[1] {
+		case "soft":
+		case "hard":
+			hard = true
+		default:
+			s.sendMessage(ctx, msg.Chat.ID, usage)
+			return
+		}
+	}
+
+	settings := s.getGameSettings(ctx, gameID)
+	settings.HistorySeasons = seasons
+	settings.HistoryHard = hard
+	if err := s.Storage.SaveGameSettings(ctx, gameID, settings); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения настроек игры: %v", err))
+		return
+	}
+
+	if seasons == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, "✅ Повторы пар из прошлых сезонов больше не учитываются.")
+		return
+	}
+	kind := "по возможности"
+	if hard {
+		kind = "строго"
+	}
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ При генерации повторы пар из последних %d сезонов будут исключаться %s.", seasons, kind))
+}
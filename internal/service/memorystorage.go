@@ -0,0 +1,795 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+)
+
+// memoryGame - данные одной игры, которые удаляет ClearGame. Описание игры и ее
+// настройки хранятся отдельно, потому что переживают /reset.
+type memoryGame struct {
+	participants map[int64]*domain.Participant
+	restrictions map[int64]map[int64]int64
+	preferences  map[int64]map[int64]domain.PreferenceKind
+	groups       map[string]*domain.Group
+	assignments  map[int64]int64
+	giftStatuses map[int64]domain.GiftStatus
+	gameActive   bool
+	gameStarted  bool
+	wishes       map[int64]string
+	comments     map[int64]map[int64]string
+	draw         *domain.DrawRecord
+	entropy      map[int64]*domain.Entropy
+	reveal       *domain.RevealSettings
+	relayRoutes  map[string]domain.RelayRole
+	relayLog     []*domain.RelayMessage
+}
+
+func newMemoryGame() *memoryGame {
+	return &memoryGame{
+		participants: make(map[int64]*domain.Participant),
+		restrictions: make(map[int64]map[int64]int64),
+		preferences:  make(map[int64]map[int64]domain.PreferenceKind),
+		groups:       make(map[string]*domain.Group),
+		assignments:  make(map[int64]int64),
+		giftStatuses: make(map[int64]domain.GiftStatus),
+		wishes:       make(map[int64]string),
+		comments:     make(map[int64]map[int64]string),
+		entropy:      make(map[int64]*domain.Entropy),
+		relayRoutes:  make(map[string]domain.RelayRole),
+	}
+}
+
+// MemoryStorage хранит все в памяти процесса: для тестов и демонстраций (STORAGE=memory).
+// Наружу отдаются копии, поэтому изменение полученных структур не меняет хранилище - как в Redis.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	users          map[int64]*domain.Participant
+	reachability   map[int64]*domain.Reachability
+	userGames      map[int64]map[int64]bool
+	selectedGames  map[int64]int64
+	gameInfo       map[int64]*domain.Game
+	gameSettings   map[int64]*domain.GameSettings
+	games          map[int64]*memoryGame
+	history        map[int64][]*domain.Season
+	triggers       map[string][]string
+	outbox         map[string]*domain.OutboundMessage
+	outboxBatches  map[string]*domain.OutboundBatch
+	outboxDelivery map[string]map[int64]*domain.Delivery
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:          make(map[int64]*domain.Participant),
+		reachability:   make(map[int64]*domain.Reachability),
+		userGames:      make(map[int64]map[int64]bool),
+		selectedGames:  make(map[int64]int64),
+		gameInfo:       make(map[int64]*domain.Game),
+		gameSettings:   make(map[int64]*domain.GameSettings),
+		games:          make(map[int64]*memoryGame),
+		history:        make(map[int64][]*domain.Season),
+		triggers:       make(map[string][]string),
+		outbox:         make(map[string]*domain.OutboundMessage),
+		outboxBatches:  make(map[string]*domain.OutboundBatch),
+		outboxDelivery: make(map[string]map[int64]*domain.Delivery),
+	}
+}
+
+// clone копирует значение через JSON, как при записи в Redis и чтении обратно.
+func clone[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var c T
+	if err := json.Unmarshal(data, &c); err != nil {
+		panic(err)
+	}
+	return &c
+}
+
+// game возвращает данные игры, создавая их при записи. Вызывается под s.mu.
+func (s *MemoryStorage) game(gameID int64, create bool) *memoryGame {
+	g := s.games[gameID]
+	if g == nil && create {
+		g = newMemoryGame()
+		s.games[gameID] = g
+	}
+	return g
+}
+
+// emptyMemoryGame отдается при чтении игры, которой еще нет; в него никогда не пишут.
+var emptyMemoryGame = newMemoryGame()
+
+func (s *MemoryStorage) readGame(gameID int64) *memoryGame {
+	if g := s.games[gameID]; g != nil {
+		return g
+	}
+	return emptyMemoryGame
+}
+
+func (s *MemoryStorage) SaveUser(ctx context.Context, p *domain.Participant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[p.UserID] = clone(p)
+	return nil
+}
+
+func (s *MemoryStorage) GetUser(ctx context.Context, userID int64) (*domain.Participant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.users[userID]), nil
+}
+
+func (s *MemoryStorage) GetAllUsers(ctx context.Context) (map[int64]*domain.Participant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make(map[int64]*domain.Participant, len(s.users))
+	for id, p := range s.users {
+		users[id] = clone(p)
+	}
+	return users, nil
+}
+
+func (s *MemoryStorage) SaveGame(ctx context.Context, g *domain.Game) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gameInfo[g.ID] = clone(g)
+	return nil
+}
+
+func (s *MemoryStorage) GetGame(ctx context.Context, gameID int64) (*domain.Game, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.gameInfo[gameID]), nil
+}
+
+func (s *MemoryStorage) GetAllGames(ctx context.Context) (map[int64]*domain.Game, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	games := make(map[int64]*domain.Game, len(s.gameInfo))
+	for id, g := range s.gameInfo {
+		games[id] = clone(g)
+	}
+	return games, nil
+}
+
+func (s *MemoryStorage) SaveReachability(ctx context.Context, userID int64, r *domain.Reachability) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reachability[userID] = clone(r)
+	return nil
+}
+
+func (s *MemoryStorage) GetReachability(ctx context.Context, userID int64) (*domain.Reachability, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.reachability[userID]), nil
+}
+
+func (s *MemoryStorage) GetUserGames(ctx context.Context, userID int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	gameIDs := make([]int64, 0, len(s.userGames[userID]))
+	for gameID := range s.userGames[userID] {
+		gameIDs = append(gameIDs, gameID)
+	}
+	return gameIDs, nil
+}
+
+func (s *MemoryStorage) SaveSelectedGame(ctx context.Context, userID, gameID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selectedGames[userID] = gameID
+	return nil
+}
+
+func (s *MemoryStorage) GetSelectedGame(ctx context.Context, userID int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.selectedGames[userID], nil
+}
+
+func (s *MemoryStorage) SaveParticipant(ctx context.Context, gameID int64, p *domain.Participant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).participants[p.UserID] = clone(p)
+	if s.userGames[p.UserID] == nil {
+		s.userGames[p.UserID] = make(map[int64]bool)
+	}
+	s.userGames[p.UserID][gameID] = true
+	return nil
+}
+
+func (s *MemoryStorage) GetParticipant(ctx context.Context, gameID, userID int64) (*domain.Participant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.readGame(gameID).participants[userID]), nil
+}
+
+func (s *MemoryStorage) GetAllParticipants(ctx context.Context, gameID int64) (map[int64]*domain.Participant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	participants := make(map[int64]*domain.Participant)
+	for id, p := range s.readGame(gameID).participants {
+		participants[id] = clone(p)
+	}
+	return participants, nil
+}
+
+func (s *MemoryStorage) DeleteParticipant(ctx context.Context, gameID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.participants, userID)
+	}
+	delete(s.userGames[userID], gameID)
+	return nil
+}
+
+func (s *MemoryStorage) SaveRestriction(ctx context.Context, gameID, userID, forbiddenUserID, creatorID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.game(gameID, true)
+	if g.restrictions[userID] == nil {
+		g.restrictions[userID] = make(map[int64]int64)
+	}
+	g.restrictions[userID][forbiddenUserID] = creatorID
+	return nil
+}
+
+func (s *MemoryStorage) HasRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.readGame(gameID).restrictions[userID][forbiddenUserID]
+	return ok, nil
+}
+
+func (s *MemoryStorage) GetRestrictionCreator(ctx context.Context, gameID, userID, forbiddenUserID int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readGame(gameID).restrictions[userID][forbiddenUserID], nil
+}
+
+func (s *MemoryStorage) GetAllRestrictions(ctx context.Context, gameID int64) (map[int64]map[int64]bool, map[int64]map[int64]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	restrictions := make(map[int64]map[int64]bool)
+	creators := make(map[int64]map[int64]int64)
+	for userID, forbidden := range s.readGame(gameID).restrictions {
+		for forbiddenUserID, creatorID := range forbidden {
+			if restrictions[userID] == nil {
+				restrictions[userID] = make(map[int64]bool)
+			}
+			restrictions[userID][forbiddenUserID] = true
+
+			if creatorID != 0 {
+				if creators[userID] == nil {
+					creators[userID] = make(map[int64]int64)
+				}
+				creators[userID][forbiddenUserID] = creatorID
+			}
+		}
+	}
+	return restrictions, creators, nil
+}
+
+func (s *MemoryStorage) DeleteRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.restrictions[userID], forbiddenUserID)
+		if len(g.restrictions[userID]) == 0 {
+			delete(g.restrictions, userID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAllRestrictionsForUser(ctx context.Context, gameID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.restrictions, userID)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) SavePreference(ctx context.Context, gameID, userID, targetID int64, kind domain.PreferenceKind) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.game(gameID, true)
+	if g.preferences[userID] == nil {
+		g.preferences[userID] = make(map[int64]domain.PreferenceKind)
+	}
+	g.preferences[userID][targetID] = kind
+	return nil
+}
+
+func (s *MemoryStorage) GetAllPreferences(ctx context.Context, gameID int64) (map[int64]map[int64]domain.PreferenceKind, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	preferences := make(map[int64]map[int64]domain.PreferenceKind)
+	for userID, targets := range s.readGame(gameID).preferences {
+		for targetID, kind := range targets {
+			if preferences[userID] == nil {
+				preferences[userID] = make(map[int64]domain.PreferenceKind)
+			}
+			preferences[userID][targetID] = kind
+		}
+	}
+	return preferences, nil
+}
+
+func (s *MemoryStorage) DeletePreference(ctx context.Context, gameID, userID, targetID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.preferences[userID], targetID)
+		if len(g.preferences[userID]) == 0 {
+			delete(g.preferences, userID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) SaveGroup(ctx context.Context, gameID int64, g *domain.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).groups[strings.ToLower(g.Name)] = clone(g)
+	return nil
+}
+
+func (s *MemoryStorage) GetGroup(ctx context.Context, gameID int64, name string) (*domain.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.readGame(gameID).groups[strings.ToLower(name)]), nil
+}
+
+func (s *MemoryStorage) GetAllGroups(ctx context.Context, gameID int64) (map[string]*domain.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	groups := make(map[string]*domain.Group)
+	for name, g := range s.readGame(gameID).groups {
+		groups[name] = clone(g)
+	}
+	return groups, nil
+}
+
+func (s *MemoryStorage) DeleteGroup(ctx context.Context, gameID int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.groups, strings.ToLower(name))
+	}
+	return nil
+}
+
+// SaveAssignment при смене получателя сбрасывает статус подарка, как и в Redis.
+func (s *MemoryStorage) SaveAssignment(ctx context.Context, gameID, giverID, receiverID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.game(gameID, true)
+	if previous, ok := g.assignments[giverID]; !ok || previous != receiverID {
+		delete(g.giftStatuses, giverID)
+	}
+	g.assignments[giverID] = receiverID
+	return nil
+}
+
+func (s *MemoryStorage) GetAssignment(ctx context.Context, gameID, giverID int64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readGame(gameID).assignments[giverID], nil
+}
+
+func (s *MemoryStorage) GetAllAssignments(ctx context.Context, gameID int64) (map[int64]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	assignments := make(map[int64]int64)
+	for giverID, receiverID := range s.readGame(gameID).assignments {
+		assignments[giverID] = receiverID
+	}
+	return assignments, nil
+}
+
+func (s *MemoryStorage) DeleteAssignment(ctx context.Context, gameID, giverID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.assignments, giverID)
+		delete(g.giftStatuses, giverID)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAllAssignments(ctx context.Context, gameID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		g.assignments = make(map[int64]int64)
+		g.giftStatuses = make(map[int64]domain.GiftStatus)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) SaveGiftStatus(ctx context.Context, gameID, giverID int64, status domain.GiftStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).giftStatuses[giverID] = status
+	return nil
+}
+
+func (s *MemoryStorage) GetGiftStatus(ctx context.Context, gameID, giverID int64) (domain.GiftStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readGame(gameID).giftStatuses[giverID], nil
+}
+
+func (s *MemoryStorage) GetAllGiftStatuses(ctx context.Context, gameID int64) (map[int64]domain.GiftStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make(map[int64]domain.GiftStatus)
+	for giverID, status := range s.readGame(gameID).giftStatuses {
+		statuses[giverID] = status
+	}
+	return statuses, nil
+}
+
+func (s *MemoryStorage) SaveGameState(ctx context.Context, gameID int64, gameActive, gameStarted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.game(gameID, true)
+	g.gameActive, g.gameStarted = gameActive, gameStarted
+	return nil
+}
+
+func (s *MemoryStorage) GetGameState(ctx context.Context, gameID int64) (bool, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g := s.readGame(gameID)
+	return g.gameActive, g.gameStarted, nil
+}
+
+func (s *MemoryStorage) ResetGameState(ctx context.Context, gameID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		g.gameActive, g.gameStarted = false, false
+	}
+	return nil
+}
+
+func (s *MemoryStorage) SaveGameSettings(ctx context.Context, gameID int64, settings *domain.GameSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gameSettings[gameID] = clone(settings)
+	return nil
+}
+
+func (s *MemoryStorage) GetGameSettings(ctx context.Context, gameID int64) (*domain.GameSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.gameSettings[gameID]), nil
+}
+
+func (s *MemoryStorage) SaveDrawRecord(ctx context.Context, gameID int64, r *domain.DrawRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).draw = clone(r)
+	return nil
+}
+
+func (s *MemoryStorage) GetDrawRecord(ctx context.Context, gameID int64) (*domain.DrawRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.readGame(gameID).draw), nil
+}
+
+func (s *MemoryStorage) SaveEntropy(ctx context.Context, gameID, userID int64, e *domain.Entropy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).entropy[userID] = clone(e)
+	return nil
+}
+
+func (s *MemoryStorage) GetAllEntropy(ctx context.Context, gameID int64) (map[int64]*domain.Entropy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entropy := make(map[int64]*domain.Entropy)
+	for userID, e := range s.readGame(gameID).entropy {
+		entropy[userID] = clone(e)
+	}
+	return entropy, nil
+}
+
+func (s *MemoryStorage) SaveRevealSettings(ctx context.Context, gameID int64, r *domain.RevealSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).reveal = clone(r)
+	return nil
+}
+
+func (s *MemoryStorage) GetRevealSettings(ctx context.Context, gameID int64) (*domain.RevealSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.readGame(gameID).reveal), nil
+}
+
+func (s *MemoryStorage) SaveSeason(ctx context.Context, season *domain.Season) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[season.GameID] = append([]*domain.Season{clone(season)}, s.history[season.GameID]...)
+	return nil
+}
+
+func (s *MemoryStorage) GetSeasons(ctx context.Context, gameID int64) ([]*domain.Season, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seasons := make([]*domain.Season, 0, len(s.history[gameID]))
+	for _, season := range s.history[gameID] {
+		seasons = append(seasons, clone(season))
+	}
+	return seasons, nil
+}
+
+func (s *MemoryStorage) ClearGame(ctx context.Context, gameID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		for userID := range g.participants {
+			delete(s.userGames[userID], gameID)
+		}
+	}
+	delete(s.games, gameID)
+	return nil
+}
+
+func (s *MemoryStorage) SaveRelayRoute(ctx context.Context, chatID int64, messageID int, route *domain.RelayRoute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(route.GameID, true).relayRoutes[relayRouteField(chatID, messageID)] = route.Role
+	return nil
+}
+
+func (s *MemoryStorage) GetRelayRole(ctx context.Context, gameID, chatID int64, messageID int) (domain.RelayRole, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readGame(gameID).relayRoutes[relayRouteField(chatID, messageID)], nil
+}
+
+func (s *MemoryStorage) SaveRelayMessage(ctx context.Context, m *domain.RelayMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.game(m.GameID, true)
+	g.relayLog = append(g.relayLog, clone(m))
+	return nil
+}
+
+func (s *MemoryStorage) GetRelayMessages(ctx context.Context, gameID int64) ([]*domain.RelayMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	relayLog := s.readGame(gameID).relayLog
+	messages := make([]*domain.RelayMessage, 0, len(relayLog))
+	for _, m := range relayLog {
+		messages = append(messages, clone(m))
+	}
+	return messages, nil
+}
+
+func (s *MemoryStorage) SaveWish(ctx context.Context, gameID, userID int64, wish string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game(gameID, true).wishes[userID] = wish
+	return nil
+}
+
+func (s *MemoryStorage) GetWish(ctx context.Context, gameID, userID int64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readGame(gameID).wishes[userID], nil
+}
+
+func (s *MemoryStorage) DeleteWish(ctx context.Context, gameID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.wishes, userID)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) SaveTriggerMessage(ctx context.Context, triggerWord, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range s.triggers[triggerWord] {
+		if msg == message {
+			return nil
+		}
+	}
+	s.triggers[triggerWord] = append(s.triggers[triggerWord], message)
+	return nil
+}
+
+func (s *MemoryStorage) GetTriggerMessages(ctx context.Context, triggerWord string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string{}, s.triggers[triggerWord]...), nil
+}
+
+func (s *MemoryStorage) GetAllTriggerWords(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	triggerWords := make([]string, 0, len(s.triggers))
+	for triggerWord := range s.triggers {
+		if triggerWord != "" {
+			triggerWords = append(triggerWords, triggerWord)
+		}
+	}
+	return triggerWords, nil
+}
+
+func (s *MemoryStorage) DeleteTriggerMessage(ctx context.Context, triggerWord, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated []string
+	for _, msg := range s.triggers[triggerWord] {
+		if msg != message {
+			updated = append(updated, msg)
+		}
+	}
+	if len(updated) == 0 {
+		delete(s.triggers, triggerWord)
+		return nil
+	}
+	s.triggers[triggerWord] = updated
+	return nil
+}
+
+func (s *MemoryStorage) SaveComment(ctx context.Context, gameID, receiverID, authorID int64, comment string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.game(gameID, true)
+	if g.comments[receiverID] == nil {
+		g.comments[receiverID] = make(map[int64]string)
+	}
+	g.comments[receiverID][authorID] = comment
+	return nil
+}
+
+func (s *MemoryStorage) GetComments(ctx context.Context, gameID, receiverID int64) (map[int64]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	comments := make(map[int64]string)
+	for authorID, comment := range s.readGame(gameID).comments[receiverID] {
+		comments[authorID] = comment
+	}
+	return comments, nil
+}
+
+func (s *MemoryStorage) DeleteComment(ctx context.Context, gameID, receiverID, authorID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g := s.game(gameID, false); g != nil {
+		delete(g.comments[receiverID], authorID)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) EnqueueOutbound(ctx context.Context, m *domain.OutboundMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox[m.ID] = clone(m)
+	return nil
+}
+
+func (s *MemoryStorage) EnqueueOutboundBatch(ctx context.Context, b *domain.OutboundBatch, deliveries []*domain.Delivery, messages []*domain.OutboundMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outboxBatches[b.ID] = clone(b)
+	s.outboxDelivery[b.ID] = make(map[int64]*domain.Delivery, len(deliveries))
+	for _, d := range deliveries {
+		s.outboxDelivery[b.ID][d.ChatID] = clone(d)
+	}
+	for _, m := range messages {
+		s.outbox[m.ID] = clone(m)
+	}
+	return nil
+}
+
+// GetDueOutbound сравнивает время с точностью до миллисекунд, как сортированное множество в Redis.
+func (s *MemoryStorage) GetDueOutbound(ctx context.Context, now time.Time, limit int) ([]*domain.OutboundMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var due []*domain.OutboundMessage
+	for _, m := range s.outbox {
+		if m.NotBefore.UnixMilli() <= now.UnixMilli() {
+			due = append(due, m)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i].NotBefore.UnixMilli(), due[j].NotBefore.UnixMilli()
+		if a != b {
+			return a < b
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	messages := make([]*domain.OutboundMessage, 0, len(due))
+	for _, m := range due {
+		messages = append(messages, clone(m))
+	}
+	return messages, nil
+}
+
+func (s *MemoryStorage) RescheduleOutbound(ctx context.Context, m *domain.OutboundMessage) error {
+	return s.EnqueueOutbound(ctx, m)
+}
+
+func (s *MemoryStorage) DeleteOutbound(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.outbox, id)
+	return nil
+}
+
+func (s *MemoryStorage) SaveOutboundBatch(ctx context.Context, b *domain.OutboundBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outboxBatches[b.ID] = clone(b)
+	return nil
+}
+
+func (s *MemoryStorage) GetOutboundBatch(ctx context.Context, id string) (*domain.OutboundBatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.outboxBatches[id]), nil
+}
+
+func (s *MemoryStorage) DeleteOutboundBatch(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.outboxBatches, id)
+	delete(s.outboxDelivery, id)
+	return nil
+}
+
+func (s *MemoryStorage) SaveDelivery(ctx context.Context, batchID string, d *domain.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outboxDelivery[batchID] == nil {
+		s.outboxDelivery[batchID] = make(map[int64]*domain.Delivery)
+	}
+	s.outboxDelivery[batchID][d.ChatID] = clone(d)
+	return nil
+}
+
+func (s *MemoryStorage) GetDeliveries(ctx context.Context, batchID string) ([]*domain.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deliveries := make([]*domain.Delivery, 0, len(s.outboxDelivery[batchID]))
+	for _, d := range s.outboxDelivery[batchID] {
+		deliveries = append(deliveries, clone(d))
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ChatID < deliveries[j].ChatID })
+	return deliveries, nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messenger - часть Telegram Bot API, которой пользуются обработчики и очередь сообщений.
+// Ей удовлетворяет *tgbotapi.BotAPI, а в тестах настоящий клиент можно направить
+// на поддельный сервер из internal/telegramtest.
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	GetChatAdministrators(config tgbotapi.ChatAdministratorsConfig) ([]tgbotapi.ChatMember, error)
+	GetChatMembersCount(config tgbotapi.ChatMemberCountConfig) (int, error)
+}
+
+// NewTelegramAPI подключается к Bot API по адресу apiURL, например https://api.telegram.org
+// или адресу локального Bot API сервера.
+func NewTelegramAPI(token, apiURL string) (*tgbotapi.BotAPI, error) {
+	endpoint := strings.TrimSuffix(apiURL, "/") + "/bot%s/%s"
+	return tgbotapi.NewBotAPIWithAPIEndpoint(token, endpoint)
+}
@@ -0,0 +1,455 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	outboxGlobalInterval  = time.Second / 30
+	outboxPrivateInterval = time.Second
+	outboxGroupInterval   = 3 * time.Second
+	outboxPollInterval    = 500 * time.Millisecond
+	outboxBatchSize       = 50
+	outboxMaxAttempts     = 5
+	outboxBaseBackoff     = 2 * time.Second
+	outboxMaxBackoff      = 5 * time.Minute
+	outboxRestoreHorizon  = 24 * time.Hour
+	outboxRestoreLimit    = 10000
+)
+
+// Outbox - очередь исходящих сообщений в хранилище. Сообщения отправляются одним
+// обработчиком (Run или Flush, не одновременно) с соблюдением лимитов Telegram:
+// не больше 30 сообщений в секунду, одно в секунду в личный чат и одно в 3 секунды в группу.
+type Outbox struct {
+	storage domain.StorageInterface
+	api     Messenger
+	wake    chan struct{}
+	limits  outboxLimits
+
+	lastSend      time.Time
+	pausedUntil   time.Time
+	deferredUntil time.Time
+	// chatReady - время, раньше которого в чат нельзя отправлять: интервал между
+	// сообщениями или повтор сообщения, которое ждет после ошибки. Более поздние
+	// сообщения чата переносятся на то же время и, так как очередь упорядочена по
+	// времени и ID, уходят после него - порядок сообщений в чате сохраняется.
+	chatReady map[int64]time.Time
+	restored  bool
+}
+
+// outboxLimits - минимальные интервалы между отправками; тесты обнуляют их,
+// чтобы не ждать настоящих лимитов Telegram.
+type outboxLimits struct {
+	global  time.Duration
+	private time.Duration
+	group   time.Duration
+}
+
+type OutboundItem struct {
+	ChatID    int64
+	Recipient string
+	Text      string
+	Buttons   [][]domain.OutboundButton
+}
+
+func NewOutbox(storage domain.StorageInterface, api Messenger) *Outbox {
+	return &Outbox{
+		storage:   storage,
+		api:       api,
+		wake:      make(chan struct{}, 1),
+		limits:    outboxLimits{global: outboxGlobalInterval, private: outboxPrivateInterval, group: outboxGroupInterval},
+		chatReady: make(map[int64]time.Time),
+	}
+}
+
+func newOutboundID() string {
+	return fmt.Sprintf("%019d%06d", time.Now().UnixNano(), rand.Intn(1000000))
+}
+
+func (o *Outbox) Send(ctx context.Context, chatID int64, text string) error {
+	return o.enqueue(ctx, &domain.OutboundMessage{ChatID: chatID, Text: text})
+}
+
+func (o *Outbox) SendWithButtons(ctx context.Context, chatID int64, text string, buttons [][]domain.OutboundButton) error {
+	return o.enqueue(ctx, &domain.OutboundMessage{ChatID: chatID, Text: text, Buttons: buttons})
+}
+
+// SendRelay ставит в очередь анонимное сообщение; после доставки запоминается маршрут,
+// чтобы ответ на это сообщение можно было переслать обратно.
+func (o *Outbox) SendRelay(ctx context.Context, chatID int64, text string, route *domain.RelayRoute) error {
+	return o.enqueue(ctx, &domain.OutboundMessage{ChatID: chatID, Text: text, Relay: route})
+}
+
+// SendBatch ставит в очередь рассылку; когда судьба каждого сообщения станет известна,
+// в batch.ReportChatID придет отчет о доставке по каждому получателю. Рассылка
+// сохраняется одной транзакцией: при ошибке в очереди не остается ни ее части, ни записи о ней.
+func (o *Outbox) SendBatch(ctx context.Context, batch *domain.OutboundBatch, items []OutboundItem) error {
+	batch.ID = newOutboundID()
+	batch.Total = len(items)
+	batch.CreatedAt = time.Now()
+
+	deliveries := make([]*domain.Delivery, 0, len(items))
+	messages := make([]*domain.OutboundMessage, 0, len(items))
+	for _, item := range items {
+		deliveries = append(deliveries, &domain.Delivery{ChatID: item.ChatID, Recipient: item.Recipient, Status: domain.DeliveryPending})
+		m := &domain.OutboundMessage{ChatID: item.ChatID, Text: item.Text, Buttons: item.Buttons, BatchID: batch.ID}
+		prepareOutbound(m)
+		messages = append(messages, m)
+	}
+	if err := o.storage.EnqueueOutboundBatch(ctx, batch, deliveries, messages); err != nil {
+		return fmt.Errorf("failed to enqueue batch: %w", err)
+	}
+
+	o.notify()
+	return nil
+}
+
+func prepareOutbound(m *domain.OutboundMessage) {
+	now := time.Now()
+	m.ID = newOutboundID()
+	m.CreatedAt = now
+	if m.NotBefore.IsZero() {
+		m.NotBefore = now
+	}
+}
+
+func (o *Outbox) enqueue(ctx context.Context, m *domain.OutboundMessage) error {
+	prepareOutbound(m)
+	if err := o.storage.EnqueueOutbound(ctx, m); err != nil {
+		return fmt.Errorf("failed to enqueue message: %w", err)
+	}
+
+	o.notify()
+	return nil
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) Run(ctx context.Context) {
+	log.Printf("Outbox: started")
+	for {
+		attempted := o.processDue(ctx)
+		if ctx.Err() != nil {
+			log.Printf("Outbox: stopped")
+			return
+		}
+		if attempted > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Outbox: stopped")
+			return
+		case <-o.wake:
+		case <-time.After(outboxPollInterval):
+		}
+	}
+}
+
+// Flush отправляет все, что можно отправить до отмены ctx. Сообщения, ждущие повтора
+// после ошибки, остаются в хранилище и будут отправлены после перезапуска.
+func (o *Outbox) Flush(ctx context.Context) {
+	for ctx.Err() == nil {
+		if o.processDue(ctx) > 0 {
+			continue
+		}
+		if !time.Now().Before(o.deferredUntil) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (o *Outbox) chatInterval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return o.limits.group
+	}
+	return o.limits.private
+}
+
+// restoreChatReady после перезапуска восстанавливает chatReady по сообщениям, которые
+// ждут повтора, чтобы новые сообщения в те же чаты не ушли раньше них.
+func (o *Outbox) restoreChatReady(ctx context.Context) error {
+	now := time.Now()
+	messages, err := o.storage.GetDueOutbound(ctx, now.Add(outboxRestoreHorizon), outboxRestoreLimit)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if m.NotBefore.After(now) && m.NotBefore.After(o.chatReady[m.ChatID]) {
+			o.chatReady[m.ChatID] = m.NotBefore
+		}
+		if m.NotBefore.After(o.deferredUntil) {
+			o.deferredUntil = m.NotBefore
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) processDue(ctx context.Context) int {
+	if !o.restored {
+		if err := o.restoreChatReady(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Outbox: failed to read queue: %v", err)
+			}
+			return 0
+		}
+		o.restored = true
+	}
+
+	now := time.Now()
+	for chatID, ready := range o.chatReady {
+		if ready.Before(now) {
+			delete(o.chatReady, chatID)
+		}
+	}
+
+	messages, err := o.storage.GetDueOutbound(ctx, now, outboxBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Outbox: failed to read queue: %v", err)
+		}
+		return 0
+	}
+
+	attempted := 0
+	for _, m := range messages {
+		if ctx.Err() != nil {
+			return attempted
+		}
+
+		if ready, ok := o.chatReady[m.ChatID]; ok && time.Now().Before(ready) {
+			o.reschedule(ctx, m, ready)
+			continue
+		}
+
+		if !o.waitTurn(ctx) {
+			return attempted
+		}
+		attempted++
+		sent, err := o.deliver(m)
+		o.chatReady[m.ChatID] = time.Now().Add(o.chatInterval(m.ChatID))
+		if err == nil {
+			if m.Relay != nil {
+				if err := o.storage.SaveRelayRoute(ctx, m.ChatID, sent.MessageID, m.Relay); err != nil {
+					log.Printf("Outbox: failed to save relay route for message %s: %v", m.ID, err)
+				}
+			}
+			o.finish(ctx, m, domain.DeliverySent, nil)
+			continue
+		}
+		o.handleError(ctx, m, err)
+	}
+	return attempted
+}
+
+func (o *Outbox) waitTurn(ctx context.Context) bool {
+	next := o.lastSend.Add(o.limits.global)
+	if o.pausedUntil.After(next) {
+		next = o.pausedUntil
+	}
+	if wait := time.Until(next); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+	o.lastSend = time.Now()
+	return true
+}
+
+func (o *Outbox) deliver(m *domain.OutboundMessage) (tgbotapi.Message, error) {
+	msg := tgbotapi.NewMessage(m.ChatID, m.Text)
+	msg.ParseMode = m.ParseMode
+	if len(m.Buttons) > 0 {
+		rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(m.Buttons))
+		for _, buttons := range m.Buttons {
+			row := make([]tgbotapi.InlineKeyboardButton, 0, len(buttons))
+			for _, b := range buttons {
+				row = append(row, tgbotapi.NewInlineKeyboardButtonData(b.Text, b.Data))
+			}
+			rows = append(rows, row)
+		}
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	return o.api.Send(msg)
+}
+
+func (o *Outbox) reschedule(ctx context.Context, m *domain.OutboundMessage, at time.Time) {
+	m.NotBefore = at
+	if at.After(o.deferredUntil) {
+		o.deferredUntil = at
+	}
+	if at.After(o.chatReady[m.ChatID]) {
+		o.chatReady[m.ChatID] = at
+	}
+	if err := o.storage.RescheduleOutbound(ctx, m); err != nil {
+		log.Printf("Outbox: failed to reschedule message %s: %v", m.ID, err)
+	}
+}
+
+func (o *Outbox) handleError(ctx context.Context, m *domain.OutboundMessage, err error) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 || apiErr.Code == 429 {
+			retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+			if retryAfter <= 0 {
+				retryAfter = outboxBaseBackoff
+			}
+			log.Printf("Outbox: rate limited sending to chatID=%d, retrying after %s", m.ChatID, retryAfter)
+			o.pausedUntil = time.Now().Add(retryAfter)
+			o.reschedule(ctx, m, o.pausedUntil)
+			return
+		}
+		if apiErr.Code >= 400 && apiErr.Code < 500 {
+			log.Printf("Outbox: permanent error sending to chatID=%d: %v", m.ChatID, err)
+			o.finish(ctx, m, domain.DeliveryFailed, apiErr)
+			return
+		}
+	}
+
+	m.Attempts++
+	if m.Attempts >= outboxMaxAttempts {
+		log.Printf("Outbox: giving up on chatID=%d after %d attempts: %v", m.ChatID, m.Attempts, err)
+		o.finish(ctx, m, domain.DeliveryFailed, err)
+		return
+	}
+
+	backoff := outboxBaseBackoff << (m.Attempts - 1)
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	backoff += time.Duration(rand.Int63n(int64(backoff) / 2))
+	log.Printf("Outbox: attempt %d to chatID=%d failed, retrying in %s: %v", m.Attempts, m.ChatID, backoff, err)
+	o.reschedule(ctx, m, time.Now().Add(backoff))
+}
+
+func (o *Outbox) finish(ctx context.Context, m *domain.OutboundMessage, status domain.DeliveryStatus, sendErr error) {
+	if err := o.storage.DeleteOutbound(ctx, m.ID); err != nil {
+		log.Printf("Outbox: failed to delete message %s: %v", m.ID, err)
+	}
+	recordReachability(ctx, o.storage, m.ChatID, sendErr)
+	if m.BatchID == "" {
+		return
+	}
+
+	deliveries, err := o.storage.GetDeliveries(ctx, m.BatchID)
+	if err != nil {
+		log.Printf("Outbox: failed to get deliveries for batch %s: %v", m.BatchID, err)
+		return
+	}
+
+	delivery := &domain.Delivery{ChatID: m.ChatID}
+	for _, d := range deliveries {
+		if d.ChatID == m.ChatID {
+			delivery = d
+		}
+	}
+	delivery.Status = status
+	delivery.Attempts = m.Attempts + 1
+	delivery.ErrorCode, delivery.Error = 0, ""
+	if sendErr != nil {
+		delivery.Error = sendErr.Error()
+		var apiErr *tgbotapi.Error
+		if errors.As(sendErr, &apiErr) {
+			delivery.ErrorCode = apiErr.Code
+		}
+	}
+	if err := o.storage.SaveDelivery(ctx, m.BatchID, delivery); err != nil {
+		log.Printf("Outbox: failed to save delivery for batch %s: %v", m.BatchID, err)
+		return
+	}
+
+	o.reportIfComplete(ctx, m.BatchID)
+}
+
+func (o *Outbox) reportIfComplete(ctx context.Context, batchID string) {
+	batch, err := o.storage.GetOutboundBatch(ctx, batchID)
+	if err != nil || batch == nil {
+		return
+	}
+	deliveries, err := o.storage.GetDeliveries(ctx, batchID)
+	if err != nil {
+		return
+	}
+
+	sent := 0
+	for _, d := range deliveries {
+		switch d.Status {
+		case domain.DeliveryPending:
+			return
+		case domain.DeliverySent:
+			sent++
+		}
+	}
+	if len(deliveries) < batch.Total {
+		return
+	}
+
+	if batch.ReportChatID != 0 {
+		if err := o.Send(ctx, batch.ReportChatID, formatDeliveryReport(batch, deliveries, sent)); err != nil {
+			log.Printf("Outbox: failed to enqueue report for batch %s: %v", batchID, err)
+			return
+		}
+	}
+	if err := o.storage.DeleteOutboundBatch(ctx, batchID); err != nil {
+		log.Printf("Outbox: failed to delete batch %s: %v", batchID, err)
+	}
+}
+
+func deliveryErrorText(d *domain.Delivery) string {
+	switch {
+	case d.ErrorCode == 403:
+		return "бот заблокирован или пользователь не начинал с ним диалог"
+	case d.ErrorCode == 400 && strings.Contains(strings.ToLower(d.Error), "chat not found"):
+		return "пользователь не начинал диалог с ботом"
+	case d.Error != "":
+		return d.Error
+	}
+	return "неизвестная ошибка"
+}
+
+func formatDeliveryReport(batch *domain.OutboundBatch, deliveries []*domain.Delivery, sent int) string {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].Status != deliveries[j].Status {
+			return deliveries[i].Status == domain.DeliveryFailed
+		}
+		return deliveries[i].Recipient < deliveries[j].Recipient
+	})
+
+	var report strings.Builder
+	report.WriteString(fmt.Sprintf("📬 Отчет о доставке: %s\n\n", batch.Title))
+	report.WriteString(fmt.Sprintf("Доставлено: %d из %d\n\n", sent, batch.Total))
+	for _, d := range deliveries {
+		recipient := d.Recipient
+		if recipient == "" {
+			recipient = fmt.Sprintf("ID %d", d.ChatID)
+		}
+		if d.Status == domain.DeliverySent {
+			report.WriteString(fmt.Sprintf("✅ %s\n", recipient))
+			continue
+		}
+		report.WriteString(fmt.Sprintf("❌ %s — %s\n", recipient, deliveryErrorText(d)))
+	}
+	return report.String()
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// rateLimitedMessenger отвечает 429 на первые limited отправок, дальше записывает сообщения.
+type rateLimitedMessenger struct {
+	*recordingMessenger
+	limited int
+}
+
+func (m *rateLimitedMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if m.limited > 0 {
+		m.limited--
+		return tgbotapi.Message{}, &tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}
+	}
+	return m.recordingMessenger.Send(c)
+}
+
+func sentTexts(m *recordingMessenger, chatID int64) []string {
+	var texts []string
+	for _, msg := range m.messages() {
+		if msg.ChatID == chatID {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+func TestOutboxKeepsChatOrderAcrossRetriesAndRestarts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	storage := NewMemoryStorage()
+	messenger := &rateLimitedMessenger{recordingMessenger: newRecordingMessenger(), limited: 1}
+
+	outbox := NewOutbox(storage, messenger)
+	outbox.limits = outboxLimits{}
+	mustDo(t, outbox.Send(ctx, 7, "first"))
+	mustDo(t, outbox.Send(ctx, 7, "second"))
+	outbox.processDue(ctx)
+
+	if sent := sentTexts(messenger.recordingMessenger, 7); len(sent) != 0 {
+		t.Fatalf("sent %q to chat 7 while its first message waits for a retry", sent)
+	}
+
+	// Перезапуск: новый Outbox ничего не знает о том, что "first" ждет повтора.
+	outbox = NewOutbox(storage, messenger)
+	outbox.limits = outboxLimits{}
+	mustDo(t, outbox.Send(ctx, 7, "third"))
+	outbox.Flush(ctx)
+
+	if got, want := sentTexts(messenger.recordingMessenger, 7), []string{"first", "second", "third"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("chat 7 got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// preferencePenalties переводит пожелания в штрафы: пара «лучше не дарить» стоит 1,
+// а если у дарителя есть любимые получатели, 1 стоит любой другой получатель.
+func preferencePenalties(preferences map[int64]map[int64]domain.PreferenceKind, participantIDs []int64) map[int64]map[int64]int {
+	penalties := make(map[int64]map[int64]int)
+	for giverID, targets := range preferences {
+		hasLoves := false
+		for _, kind := range targets {
+			if kind == domain.PreferenceLove {
+				hasLoves = true
+				break
+			}
+		}
+
+		for _, receiverID := range participantIDs {
+			if receiverID == giverID {
+				continue
+			}
+			cost := 0
+			if targets[receiverID] == domain.PreferenceAvoid {
+				cost++
+			}
+			if hasLoves && targets[receiverID] != domain.PreferenceLove {
+				cost++
+			}
+			if cost == 0 {
+				continue
+			}
+			if penalties[giverID] == nil {
+				penalties[giverID] = make(map[int64]int)
+			}
+			penalties[giverID][receiverID] = cost
+		}
+	}
+	return penalties
+}
+
+func addPenalties(dst, src map[int64]map[int64]int) map[int64]map[int64]int {
+	if dst == nil {
+		dst = make(map[int64]map[int64]int)
+	}
+	for giverID, receivers := range src {
+		if dst[giverID] == nil {
+			dst[giverID] = make(map[int64]int)
+		}
+		for receiverID, value := range receivers {
+			dst[giverID][receiverID] += value
+		}
+	}
+	return dst
+}
+
+// preferenceScore считает, сколько пожеланий нарушено распределением: каждое «лучше не
+// дарить», которое досталось, и каждый даритель, не получивший никого из любимых.
+func preferenceScore(preferences map[int64]map[int64]domain.PreferenceKind, assignments map[int64]int64) (violated, total int) {
+	for giverID, targets := range preferences {
+		receiverID, assigned := assignments[giverID]
+		if !assigned {
+			continue
+		}
+		hasLoves, gotLove := false, false
+		for targetID, kind := range targets {
+			switch kind {
+			case domain.PreferenceAvoid:
+				if _, ok := assignments[targetID]; !ok {
+					continue
+				}
+				total++
+				if targetID == receiverID {
+					violated++
+				}
+			case domain.PreferenceLove:
+				hasLoves = true
+				if targetID == receiverID {
+					gotLove = true
+				}
+			}
+		}
+		if hasLoves {
+			total++
+			if !gotLove {
+				violated++
+			}
+		}
+	}
+	return violated, total
+}
+
+func (s *SecretSantaBot) removePreferencesForUser(ctx context.Context, gameID, userID int64) error {
+	preferences, err := s.Storage.GetAllPreferences(ctx, gameID)
+	if err != nil {
+		return err
+	}
+
+	for giverID, targets := range preferences {
+		for targetID := range targets {
+			if giverID != userID && targetID != userID {
+				continue
+			}
+			if err := s.Storage.DeletePreference(ctx, gameID, giverID, targetID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func preferenceTitle(kind domain.PreferenceKind) string {
+	if kind == domain.PreferenceLove {
+		return "хотели бы подарить"
+	}
+	return "лучше не дарить"
+}
+
+func preferenceIcon(kind domain.PreferenceKind) string {
+	if kind == domain.PreferenceLove {
+		return "💚"
+	}
+	return "🚫"
+}
+
+func (s *SecretSantaBot) handleSetPreference(ctx context.Context, msg *tgbotapi.Message, gameID int64, kind domain.PreferenceKind) {
+	userID := msg.From.ID
+	existing, err := s.Storage.GetParticipant(ctx, gameID, userID)
+	if err != nil || existing == nil {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Сначала добавьте себя в игру через /add")
+		return
+	}
+
+	command := "/avoid"
+	if kind == domain.PreferenceLove {
+		command = "/prefer"
+	}
+	usernameArg := strings.TrimSpace(msg.CommandArguments())
+	if usernameArg == "" {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Укажите username пользователя. Пример: %s @username", command))
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+
+	targetID, found := findParticipantByUsername(participants, usernameArg)
+	if !found {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь %s не найден среди участников.", usernameArg))
+		return
+	}
+	if targetID == userID {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Нельзя указать пожелание для самого себя.")
+		return
+	}
+
+	if err := s.Storage.SavePreference(ctx, gameID, userID, targetID, kind); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при сохранении пожелания: %v", err))
+		return
+	}
+	log.Printf("handleSetPreference: user %d set preference %s for %d in gameID=%d", userID, kind, targetID, gameID)
+
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Пожелание сохранено: %s — %s.\n\n"+
+		"Это мягкое пожелание: бот постарается его учесть, но не гарантирует. Для строгого запрета используйте /restrict.",
+		participantDisplayName(participants, targetID), preferenceTitle(kind)))
+}
+
+func (s *SecretSantaBot) handleRemovePreference(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	usernameArg := strings.TrimSpace(msg.CommandArguments())
+	if usernameArg == "" {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите username пользователя. Пример: /unprefer @username")
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+
+	targetID, found := findParticipantByUsername(participants, usernameArg)
+	if !found {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Пользователь %s не найден среди участников.", usernameArg))
+		return
+	}
+
+	if err := s.Storage.DeletePreference(ctx, gameID, msg.From.ID, targetID); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при удалении пожелания: %v", err))
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Пожелание для %s удалено.", participantDisplayName(participants, targetID)))
+}
+
+func (s *SecretSantaBot) handleListPreferences(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	userID := msg.From.ID
+	isAdmin := s.IsAdmin(msg.From.UserName)
+
+	preferences, err := s.Storage.GetAllPreferences(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения пожеланий: %v", err))
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+
+	writeTargets := func(list *strings.Builder, targets map[int64]domain.PreferenceKind) {
+		for _, kind := range []domain.PreferenceKind{domain.PreferenceLove, domain.PreferenceAvoid} {
+			for targetID, targetKind := range targets {
+				if targetKind == kind {
+					list.WriteString(fmt.Sprintf("  %s %s\n", preferenceIcon(kind), participantDisplayName(participants, targetID)))
+				}
+			}
+		}
+	}
+
+	var list strings.Builder
+	list.WriteString("⭐ Пожелания (💚 хотели бы подарить, 🚫 лучше не дарить):\n\n")
+	if isAdmin {
+		if len(preferences) == 0 {
+			s.sendMessage(ctx, msg.Chat.ID, "⭐ Пожеланий нет.")
+			return
+		}
+		for giverID, targets := range preferences {
+			list.WriteString(fmt.Sprintf("%s:\n", participantDisplayName(participants, giverID)))
+			writeTargets(&list, targets)
+			list.WriteString("\n")
+		}
+	} else {
+		targets := preferences[userID]
+		if len(targets) == 0 {
+			s.sendMessage(ctx, msg.Chat.ID, "⭐ У вас нет пожеланий. Добавить: /prefer @username или /avoid @username")
+			return
+		}
+		writeTargets(&list, targets)
+	}
+
+	s.sendMessage(ctx, msg.Chat.ID, list.String())
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	reachabilityMaxAge        = 6 * time.Hour
+	reachabilityProbeInterval = 50 * time.Millisecond
+	reachabilityProbeWorkers  = 4
+	startGamePrefix           = "g"
+	reachabilityLegend        = "✅ бот может написать в личку, 📭 не может, ❔ еще не проверялось"
+)
+
+// unreachableError определяет ошибки, после которых писать пользователю бесполезно,
+// пока он сам не откроет бота: бот заблокирован или диалог с ботом не начат.
+func unreachableError(err error) (*tgbotapi.Error, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return nil, false
+	}
+	switch {
+	case apiErr.Code == 403:
+		return apiErr, true
+	case apiErr.Code == 400 && strings.Contains(strings.ToLower(apiErr.Message), "chat not found"):
+		return apiErr, true
+	}
+	return nil, false
+}
+
+func unreachableReason(code int, message string) string {
+	return deliveryErrorText(&domain.Delivery{ErrorCode: code, Error: message})
+}
+
+// recordReachability запоминает результат отправки в личный чат. Временные ошибки
+// (сеть, 5xx, лимиты) ничего не говорят о доступности и не записываются.
+func recordReachability(ctx context.Context, storage domain.StorageInterface, chatID int64, sendErr error) {
+	if chatID <= 0 {
+		return
+	}
+
+	r := &domain.Reachability{Reachable: true, CheckedAt: time.Now()}
+	if sendErr != nil {
+		apiErr, ok := unreachableError(sendErr)
+		if !ok {
+			return
+		}
+		r = &domain.Reachability{ErrorCode: apiErr.Code, Reason: unreachableReason(apiErr.Code, apiErr.Message), CheckedAt: time.Now()}
+	}
+	if err := storage.SaveReachability(ctx, chatID, r); err != nil {
+		log.Printf("recordReachability: failed to save reachability for userID=%d: %v", chatID, err)
+	}
+}
+
+func (s *SecretSantaBot) markReachable(ctx context.Context, userID int64) {
+	r, err := s.Storage.GetReachability(ctx, userID)
+	if err != nil {
+		log.Printf("markReachable: failed to get reachability for userID=%d: %v", userID, err)
+		return
+	}
+	if r != nil && r.Reachable && time.Since(r.CheckedAt) < reachabilityMaxAge {
+		return
+	}
+	recordReachability(ctx, s.Storage, userID, nil)
+}
+
+// handleMyChatMember отслеживает, когда пользователь блокирует или разблокирует бота в личном чате.
+func (s *SecretSantaBot) handleMyChatMember(ctx context.Context, update *tgbotapi.ChatMemberUpdated) {
+	if !update.Chat.IsPrivate() {
+		return
+	}
+
+	userID := update.Chat.ID
+	switch update.NewChatMember.Status {
+	case "kicked":
+		r := &domain.Reachability{ErrorCode: 403, Reason: unreachableReason(403, ""), CheckedAt: time.Now()}
+		if err := s.Storage.SaveReachability(ctx, userID, r); err != nil {
+			log.Printf("handleMyChatMember: failed to save reachability for userID=%d: %v", userID, err)
+		}
+		log.Printf("handleMyChatMember: userID=%d blocked the bot", userID)
+	case "member":
+		recordReachability(ctx, s.Storage, userID, nil)
+		log.Printf("handleMyChatMember: userID=%d unblocked the bot", userID)
+	}
+}
+
+// probeReachability проверяет, может ли бот написать пользователю, незаметно для него:
+// getChat для пользователя, который не начинал диалог с ботом, возвращает «chat not found».
+// Блокировку бота getChat не показывает, о ней бот узнает из my_chat_member и ошибок отправки.
+func (s *SecretSantaBot) probeReachability(ctx context.Context, userID int64) *domain.Reachability {
+	_, err := s.Bot.Request(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: userID}})
+	if err != nil {
+		if _, ok := unreachableError(err); !ok {
+			log.Printf("probeReachability: failed to probe userID=%d: %v", userID, err)
+			return nil
+		}
+	}
+	recordReachability(ctx, s.Storage, userID, err)
+
+	r, err := s.Storage.GetReachability(ctx, userID)
+	if err != nil {
+		log.Printf("probeReachability: failed to get reachability for userID=%d: %v", userID, err)
+		return nil
+	}
+	return r
+}
+
+func (s *SecretSantaBot) participantsReachability(ctx context.Context, participants map[int64]*domain.Participant) map[int64]*domain.Reachability {
+	result := make(map[int64]*domain.Reachability, len(participants))
+	for userID := range participants {
+		r, err := s.Storage.GetReachability(ctx, userID)
+		if err != nil {
+			log.Printf("participantsReachability: failed to get reachability for userID=%d: %v", userID, err)
+			continue
+		}
+		if r != nil {
+			result[userID] = r
+		}
+	}
+	return result
+}
+
+// needsProbe сообщает, стоит ли перепроверить участника: о нем ничего не известно, он
+// не начинал диалог с ботом или проверялся давно. Заблокировавших бота getChat не
+// отличит от доступных, поэтому их запись меняют только my_chat_member и отправки.
+func needsProbe(r *domain.Reachability) bool {
+	switch {
+	case r == nil:
+		return true
+	case r.Reachable:
+		return time.Since(r.CheckedAt) > reachabilityMaxAge
+	default:
+		return r.ErrorCode != 403
+	}
+}
+
+// refreshReachability перепроверяет участников, которым это нужно, в несколько потоков.
+// Вызывается до lockGame: проверка большой игры занимает секунды, и держать на это
+// время блокировку игры незачем.
+func (s *SecretSantaBot) refreshReachability(ctx context.Context, participants map[int64]*domain.Participant) {
+	known := s.participantsReachability(ctx, participants)
+
+	sem := make(chan struct{}, reachabilityProbeWorkers)
+	var wg sync.WaitGroup
+	for userID := range participants {
+		if !needsProbe(known[userID]) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			s.probeReachability(ctx, userID)
+			select {
+			case <-ctx.Done():
+			case <-time.After(reachabilityProbeInterval):
+			}
+			<-sem
+		}(userID)
+	}
+	wg.Wait()
+}
+
+// findUnreachable возвращает участников, которым бот, по последним сведениям, написать не может.
+func (s *SecretSantaBot) findUnreachable(ctx context.Context, participants map[int64]*domain.Participant) []int64 {
+	var unreachable []int64
+	for userID, r := range s.participantsReachability(ctx, participants) {
+		if !r.Reachable {
+			unreachable = append(unreachable, userID)
+		}
+	}
+	sort.Slice(unreachable, func(i, j int) bool { return unreachable[i] < unreachable[j] })
+	return unreachable
+}
+
+func (s *SecretSantaBot) startLink(gameID int64) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s%d", s.Self.UserName, startGamePrefix, gameID)
+}
+
+func participantMention(participants map[int64]*domain.Participant, userID int64) string {
+	if p, ok := participants[userID]; ok && p.Username != "" {
+		return "@" + p.Username
+	}
+	return participantDisplayName(participants, userID)
+}
+
+// remindUnreachable просит в групповом чате игры тех, кому бот не может написать, открыть бота.
+func (s *SecretSantaBot) remindUnreachable(ctx context.Context, gameID int64, participants map[int64]*domain.Participant, userIDs []int64) {
+	if gameID >= 0 || len(userIDs) == 0 {
+		return
+	}
+
+	mentions := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		mentions = append(mentions, participantMention(participants, userID))
+	}
+	s.sendMessage(ctx, gameID, fmt.Sprintf("📭 %s, бот не может написать вам в личные сообщения, "+
+		"а значит не сможет прислать, кому вы дарите подарок.\n\n"+
+		"Откройте бота по ссылке и нажмите «Запустить» (или разблокируйте его):\n%s",
+		strings.Join(mentions, ", "), s.startLink(gameID)))
+}
+
+func countReachability(participants map[int64]*domain.Participant, reachability map[int64]*domain.Reachability) (reachable, unreachable, unknown int) {
+	for userID := range participants {
+		switch r := reachability[userID]; {
+		case r == nil:
+			unknown++
+		case r.Reachable:
+			reachable++
+		default:
+			unreachable++
+		}
+	}
+	return reachable, unreachable, unknown
+}
+
+func reachabilityIcon(r *domain.Reachability) string {
+	switch {
+	case r == nil:
+		return "❔"
+	case r.Reachable:
+		return "✅"
+	}
+	return "📭"
+}
+
+// handleStart обрабатывает /start, в том числе по ссылке из напоминания (/start g<ID игры>):
+// выбирает игру и досылает назначение, которое не удалось доставить раньше.
+func (s *SecretSantaBot) handleStart(ctx context.Context, msg *tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if msg.Chat.IsPrivate() && strings.HasPrefix(arg, startGamePrefix) {
+		if gameID, err := strconv.ParseInt(strings.TrimPrefix(arg, startGamePrefix), 10, 64); err == nil {
+			s.startFromLink(ctx, msg, gameID)
+			return
+		}
+	}
+	s.sendHelpMessage(msg)
+}
+
+func (s *SecretSantaBot) startFromLink(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	userID := msg.From.ID
+	gameIDs, err := s.Storage.GetUserGames(ctx, userID)
+	if err != nil || !containsGame(gameIDs, gameID) {
+		s.sendHelpMessage(msg)
+		return
+	}
+
+	if err := s.Storage.SaveSelectedGame(ctx, userID, gameID); err != nil {
+		log.Printf("startFromLink: failed to select gameID=%d for userID=%d: %v", gameID, userID, err)
+	}
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Готово! Теперь бот сможет прислать вам результаты игры «%s».\n\nСписок команд: /help", s.gameTitle(ctx, gameID)))
+
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil || !gameStarted {
+		return
+	}
+	if receiverID, err := s.Storage.GetAssignment(ctx, gameID, userID); err != nil || receiverID == 0 {
+		return
+	}
+	if err := s.SendAssignment(ctx, gameID, userID); err != nil {
+		log.Printf("startFromLink: failed to send assignment to userID=%d: %v", userID, err)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// santaOf ищет дарителя, которому достался receiverID.
+func (s *SecretSantaBot) santaOf(ctx context.Context, gameID, receiverID int64) (int64, error) {
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get assignments: %w", err)
+	}
+	for giverID, id := range assignments {
+		if id == receiverID {
+			return giverID, nil
+		}
+	}
+	return 0, nil
+}
+
+// relayPeer находит собеседника по текущему распределению: для Санты - его получателя,
+// для получателя - его Санту. Поэтому после изменения состава игры сообщения
+// уходят новому собеседнику, а не тому, кто писал раньше.
+func (s *SecretSantaBot) relayPeer(ctx context.Context, gameID, userID int64, role domain.RelayRole) (int64, error) {
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get game state: %w", err)
+	}
+	if !gameStarted {
+		return 0, nil
+	}
+	if role == domain.RelayRoleSanta {
+		return s.Storage.GetAssignment(ctx, gameID, userID)
+	}
+	return s.santaOf(ctx, gameID, userID)
+}
+
+func relayText(gameTitle string, fromRole domain.RelayRole, senderName, text string) string {
+	if fromRole == domain.RelayRoleSanta {
+		return fmt.Sprintf("🎅 Сообщение от вашего Тайного Санты (игра «%s»):\n\n%s\n\n"+
+			"↩️ Ответьте на это сообщение, и бот анонимно передаст ответ Санте. Или используйте /answer текст", gameTitle, text)
+	}
+	return fmt.Sprintf("🎁 Ответ от вашего получателя %s (игра «%s»):\n\n%s\n\n"+
+		"↩️ Ответьте на это сообщение, чтобы продолжить разговор, не раскрывая себя. Или используйте /ask текст", senderName, gameTitle, text)
+}
+
+func (s *SecretSantaBot) relay(ctx context.Context, msg *tgbotapi.Message, gameID int64, fromRole domain.RelayRole, text string) {
+	userID := msg.From.ID
+	peerID, err := s.relayPeer(ctx, gameID, userID, fromRole)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при отправке сообщения: %v", err))
+		return
+	}
+	if peerID == 0 {
+		if fromRole == domain.RelayRoleSanta {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ У вас пока нет получателя: писать ему можно после начала игры.")
+		} else {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ У вас пока нет Тайного Санты: писать ему можно после начала игры.")
+		}
+		return
+	}
+
+	senderName := msg.From.FirstName
+	if sender, err := s.Storage.GetParticipant(ctx, gameID, userID); err == nil && sender != nil {
+		senderName = sender.FullName
+	}
+
+	toRole := domain.RelayRoleReceiver
+	if fromRole == domain.RelayRoleReceiver {
+		toRole = domain.RelayRoleSanta
+	}
+	route := &domain.RelayRoute{GameID: gameID, Role: toRole}
+	if err := s.Outbox.SendRelay(ctx, peerID, relayText(s.gameTitle(ctx, gameID), fromRole, senderName, text), route); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка при отправке сообщения: %v", err))
+		return
+	}
+
+	entry := &domain.RelayMessage{GameID: gameID, FromID: userID, ToID: peerID, FromRole: fromRole, Text: text, CreatedAt: time.Now()}
+	if err := s.Storage.SaveRelayMessage(ctx, entry); err != nil {
+		log.Printf("relay: failed to log message in gameID=%d: %v", gameID, err)
+	}
+	log.Printf("relay: gameID=%d, message from %s relayed", gameID, fromRole)
+
+	result := "✅ Сообщение отправлено получателю. Он не узнает, от кого оно."
+	if fromRole == domain.RelayRoleReceiver {
+		result = "✅ Ответ отправлен вашему Тайному Санте."
+	}
+	if r, err := s.Storage.GetReachability(ctx, peerID); err == nil && r != nil && !r.Reachable {
+		result += "\n\n📭 Бот пока не может написать собеседнику, поэтому сообщение может не дойти."
+	}
+	s.sendMessage(ctx, msg.Chat.ID, result)
+}
+
+func (s *SecretSantaBot) handleRelayCommand(ctx context.Context, msg *tgbotapi.Message, gameID int64, fromRole domain.RelayRole) {
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🤫 Чтобы сохранить тайну, анонимные сообщения отправляются только из личных сообщений с ботом. Напишите боту /%s в личку.", msg.Command()))
+		return
+	}
+
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		if fromRole == domain.RelayRoleSanta {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Напишите вопрос получателю. Пример: /ask Какой у тебя размер свитера?")
+		} else {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Напишите ответ вашему Санте. Пример: /answer Размер M, люблю синий цвет")
+		}
+		return
+	}
+
+	if participant, err := s.Storage.GetParticipant(ctx, gameID, msg.From.ID); err != nil || participant == nil {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Вы не участвуете в игре.")
+		return
+	}
+	s.relay(ctx, msg, gameID, fromRole, text)
+}
+
+// HandleRelayReply пересылает ответ на анонимное сообщение. Возвращает false,
+// если сообщение не является ответом на пересланное ботом сообщение.
+func (s *SecretSantaBot) HandleRelayReply(ctx context.Context, msg *tgbotapi.Message) bool {
+	reply := msg.ReplyToMessage
+	if !msg.Chat.IsPrivate() || reply == nil || reply.From == nil || reply.From.ID != s.Self.ID {
+		return false
+	}
+
+	gameIDs, err := s.Storage.GetUserGames(ctx, msg.From.ID)
+	if err != nil {
+		log.Printf("HandleRelayReply: failed to get games: %v", err)
+		return false
+	}
+	for _, gameID := range gameIDs {
+		role, err := s.Storage.GetRelayRole(ctx, gameID, msg.Chat.ID, reply.MessageID)
+		if err != nil {
+			log.Printf("HandleRelayReply: failed to get relay route in gameID=%d: %v", gameID, err)
+			continue
+		}
+		if role == "" {
+			continue
+		}
+
+		if msg.Text == "" {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Анонимно можно передать только текст.")
+			return true
+		}
+		s.relay(ctx, msg, gameID, role, msg.Text)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,491 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// repairStepLimit ограничивает перебор при починке круга: она выполняется на каждое
+// изменение состава, поэтому не должна занимать столько же, сколько полная жеребьевка.
+const repairStepLimit = 200000
+
+// RepairReport описывает исправление распределения. NeedsConfirmation означает, что
+// новичка не удалось встроить в круг, не трогая чужих пар: распределение не изменено,
+// а Changed перечисляет дарителей, которых затронет /admit. Failed означает, что починить
+// распределение не удалось вовсе: оно тоже не изменено, новички в него не включены,
+// а Orphaned перечисляет дарителей, чей получатель вышел из игры. Решение о новой
+// жеребьевке остается за администратором.
+type RepairReport struct {
+	Changed           []int64
+	Assigned          []int64
+	Orphaned          []int64
+	Failed            bool
+	NeedsConfirmation bool
+}
+
+// repair достраивает распределение, в котором у части дарителей нет получателя
+// (current[giver] == -1), меняя получателей у как можно меньшего числа дарителей.
+// Сначала пробует просто сомкнуть круг (даритель ушедшего берет его получателя)
+// или вставить новичка в круг, разорвав одну пару.
+func (s *assignmentSolver) repair(mode domain.AssignmentMode, current []int) ([]int, error) {
+	n := len(current)
+	if n < 2 {
+		return nil, ErrNoValidAssignment
+	}
+
+	hasGiver := make([]bool, n)
+	var givers, receivers []int
+	for giver, receiver := range current {
+		if receiver == -1 {
+			givers = append(givers, giver)
+			continue
+		}
+		hasGiver[receiver] = true
+	}
+	for receiver, ok := range hasGiver {
+		if !ok {
+			receivers = append(receivers, receiver)
+		}
+	}
+	if len(givers) == 0 {
+		return current, nil
+	}
+
+	if len(givers) == 1 && len(receivers) == 1 {
+		giver, receiver := givers[0], receivers[0]
+		if giver == receiver {
+			if result, ok := s.insert(current, giver); ok {
+				return result, nil
+			}
+		}
+		if giver != receiver && s.allowed[giver][receiver] &&
+			!(mode == domain.AssignmentModeNoMutual && current[receiver] == giver) {
+			result := append([]int(nil), current...)
+			result[giver] = receiver
+			return result, nil
+		}
+		if mode == domain.AssignmentModeChain {
+			if result, ok := s.relocate(current, giver, receiver); ok {
+				return result, nil
+			}
+		}
+	}
+
+	if receiverOf, size := maxMatching(s.rng, s.allowed); size < n {
+		return nil, s.infeasibility(receiverOf)
+	}
+
+	if mode == domain.AssignmentModeChain {
+		return s.minChangeCycle(current)
+	}
+
+	result := s.minChangeMatching(current)
+	if mode == domain.AssignmentModeNoMutual {
+		return s.removeMutualPairs(result)
+	}
+	return result, nil
+}
+
+// insert вставляет нового участника в круг: пара A → B превращается в A → newcomer → B.
+// Из подходящих пар выбирается та, что меньше всего увеличивает штраф.
+func (s *assignmentSolver) insert(current []int, newcomer int) ([]int, bool) {
+	best, bestCost := -1, 0
+	for _, giver := range s.rng.Perm(len(current)) {
+		receiver := current[giver]
+		if giver == newcomer || receiver == -1 {
+			continue
+		}
+		if !s.allowed[giver][newcomer] || !s.allowed[newcomer][receiver] {
+			continue
+		}
+		cost := 0
+		if s.penalty != nil {
+			cost = s.penalty[giver][newcomer] + s.penalty[newcomer][receiver] - s.penalty[giver][receiver]
+		}
+		if best == -1 || cost < bestCost {
+			best, bestCost = giver, cost
+		}
+	}
+	if best == -1 {
+		return nil, false
+	}
+
+	result := append([]int(nil), current...)
+	result[newcomer] = current[best]
+	result[best] = newcomer
+	return result, true
+}
+
+// relocate чинит разорванный круг, когда сомкнуть его напрямую нельзя: один участник
+// переносится на место ушедшего (giver → X → receiver), а его соседи замыкаются друг на друга.
+func (s *assignmentSolver) relocate(current []int, giver, receiver int) ([]int, bool) {
+	n := len(current)
+	path := []int{receiver}
+	for node := receiver; node != giver; {
+		node = current[node]
+		if node == -1 || len(path) >= n {
+			return nil, false
+		}
+		path = append(path, node)
+	}
+	if len(path) != n || n < 3 {
+		return nil, false
+	}
+
+	for _, i := range s.rng.Perm(n - 2) {
+		prev, moved, next := path[i], path[i+1], path[i+2]
+		if s.allowed[prev][next] && s.allowed[giver][moved] && s.allowed[moved][receiver] {
+			result := append([]int(nil), current...)
+			result[prev] = next
+			result[giver] = moved
+			result[moved] = receiver
+			return result, true
+		}
+	}
+	return nil, false
+}
+
+// minChangeCycle ищет общий круг, в котором меньше всего дарителей сменили получателя:
+// перебор идет по кругу от участника к участнику и сначала пробует прежнего получателя,
+// ветви, где смен уже не меньше, чем в лучшем найденном круге, отсекаются.
+// Если круга нет или он не найден за repairStepLimit шагов, возвращает ошибку: заново
+// разыгрывать всех после начала игры нельзя, решать должен администратор.
+func (s *assignmentSolver) minChangeCycle(current []int) ([]int, error) {
+	n := len(current)
+	if n < 3 {
+		return nil, ErrModeUnsatisfiable
+	}
+
+	receiverOf := make([]int, n)
+	visited := make([]bool, n)
+	var best []int
+	bestChanges := n + 1
+
+	changed := func(giver, receiver int) int {
+		if current[giver] == receiver {
+			return 0
+		}
+		return 1
+	}
+
+	limit := repairStepLimit
+	if s.stepLimit < limit {
+		limit = s.stepLimit
+	}
+	steps := 0
+	var extend func(node, length, changes int) bool
+	extend = func(node, length, changes int) bool {
+		if changes >= bestChanges {
+			return false
+		}
+		if length == n {
+			if s.allowed[node][0] && changes+changed(node, 0) < bestChanges {
+				receiverOf[node] = 0
+				best = append(best[:0], receiverOf...)
+				bestChanges = changes + changed(node, 0)
+			}
+			return bestChanges == 0
+		}
+		steps++
+		if steps > limit {
+			return true
+		}
+
+		candidates := make([]int, 0, n)
+		for _, next := range s.rng.Perm(n) {
+			if visited[next] || !s.allowed[node][next] {
+				continue
+			}
+			if next == current[node] {
+				candidates = append([]int{next}, candidates...)
+				continue
+			}
+			candidates = append(candidates, next)
+		}
+
+		for _, next := range candidates {
+			visited[next] = true
+			receiverOf[node] = next
+			stop := extend(next, length+1, changes+changed(node, next))
+			visited[next] = false
+			if stop {
+				return true
+			}
+		}
+		return false
+	}
+
+	visited[0] = true
+	extend(0, 1, 0)
+	if best != nil {
+		return best, nil
+	}
+	if steps > limit {
+		return nil, ErrSearchLimit
+	}
+	return nil, ErrModeUnsatisfiable
+}
+
+// minChangeMatching ищет распределение, в котором меньше всего дарителей сменили
+// получателя; среди таких выбирается распределение с наименьшим штрафом.
+func (s *assignmentSolver) minChangeMatching(current []int) []int {
+	n := len(s.ids)
+	scale := n * n
+	maxPenalty := 0
+	for _, row := range s.penalty {
+		for _, value := range row {
+			if value > maxPenalty {
+				maxPenalty = value
+			}
+		}
+	}
+	changeCost := (n*maxPenalty + 1) * scale
+	impossible := (n + 1) * changeCost
+
+	cost := make([][]int, n)
+	for giver := range cost {
+		cost[giver] = make([]int, n)
+		for receiver := range cost[giver] {
+			if !s.allowed[giver][receiver] {
+				cost[giver][receiver] = impossible
+				continue
+			}
+			value := s.rng.Intn(n)
+			if s.penalty != nil {
+				value += s.penalty[giver][receiver] * scale
+			}
+			if current[giver] != receiver {
+				value += changeCost
+			}
+			cost[giver][receiver] = value
+		}
+	}
+	return minCostAssignment(cost)
+}
+
+// repairAssignments восстанавливает распределение после изменения состава игры.
+// previous - распределение до изменения, admit - новички, которых нужно в него включить;
+// участники, которых нет ни там, ни там (новички, ждущие /admit), не затрагиваются.
+// Без confirmed новичок включается, только если ради него меняется одна чужая пара
+// (вставка в круг); иначе распределение не меняется и отчет возвращается с NeedsConfirmation.
+// Если починить распределение нельзя, оно тоже не меняется, даже с confirmed:
+// сбросить жеребьевку может только сам администратор через /generate.
+// Сохраняются только изменившиеся пары.
+func (s *SecretSantaBot) repairAssignments(ctx context.Context, gameID int64, previous map[int64]int64, admit []int64, confirmed bool) (*RepairReport, error) {
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participants: %w", err)
+	}
+
+	participantIDs := make([]int64, 0, len(previous)+len(admit))
+	for id := range participants {
+		if _, assigned := previous[id]; assigned {
+			participantIDs = append(participantIDs, id)
+		}
+	}
+	for _, id := range admit {
+		if _, assigned := previous[id]; !assigned && participants[id] != nil {
+			participantIDs = append(participantIDs, id)
+		}
+	}
+	sort.Slice(participantIDs, func(i, j int) bool { return participantIDs[i] < participantIDs[j] })
+
+	index := make(map[int64]int, len(participantIDs))
+	for i, id := range participantIDs {
+		index[id] = i
+	}
+	current := make([]int, len(participantIDs))
+	var orphaned []int64
+	for i, giverID := range participantIDs {
+		current[i] = -1
+		if receiverID, ok := previous[giverID]; ok {
+			if receiver, ok := index[receiverID]; ok {
+				current[i] = receiver
+			} else {
+				orphaned = append(orphaned, giverID)
+			}
+		}
+	}
+
+	constraints, err := s.loadDrawConstraints(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	receiverOf, err := constraints.solver(participantIDs).repair(constraints.settings.Mode, current)
+	if err != nil {
+		log.Printf("repairAssignments: gameID=%d cannot be repaired, keeping assignments as they are: %v", gameID, err)
+		return &RepairReport{Orphaned: orphaned, Failed: true}, nil
+	}
+
+	report := &RepairReport{}
+	for giver, receiver := range receiverOf {
+		giverID, receiverID := participantIDs[giver], participantIDs[receiver]
+		previousID, assigned := previous[giverID]
+		if previousID == receiverID {
+			continue
+		}
+		if assigned {
+			report.Changed = append(report.Changed, giverID)
+		} else {
+			report.Assigned = append(report.Assigned, giverID)
+		}
+	}
+	if len(admit) > 0 && !confirmed && len(report.Changed) > len(report.Assigned) {
+		log.Printf("repairAssignments: gameID=%d, admitting %v would change %d givers, waiting for /admit", gameID, admit, len(report.Changed))
+		report.NeedsConfirmation = true
+		return report, nil
+	}
+
+	for giver, receiver := range receiverOf {
+		giverID, receiverID := participantIDs[giver], participantIDs[receiver]
+		if previousID, assigned := previous[giverID]; assigned && previousID == receiverID {
+			continue
+		}
+		if err := s.Storage.SaveAssignment(ctx, gameID, giverID, receiverID); err != nil {
+			return nil, fmt.Errorf("failed to save assignment: %w", err)
+		}
+	}
+	log.Printf("repairAssignments: gameID=%d, %d givers got a new receiver, %d newcomers assigned", gameID, len(report.Changed), len(report.Assigned))
+
+	// Новый отпечаток пишется в журнал, но не публикуется: иначе чат узнал бы, что пары
+	// менялись. Он будет раскрыт вместе с парами при /reveal.
+	seed, err := s.currentSeed(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get seed: %w", err)
+	}
+	if _, err := s.recordDraw(ctx, gameID, seed, "assignments repaired", ""); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// notifyChangedAssignments отправляет новые назначения, если игра уже начата:
+// до /startgame участники своих получателей еще не знают.
+func (s *SecretSantaBot) notifyChangedAssignments(ctx context.Context, gameID int64, report *RepairReport) {
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil || !gameStarted {
+		return
+	}
+
+	for _, giverID := range report.Changed {
+		s.sendMessage(ctx, giverID, fmt.Sprintf("🔄 Состав игры «%s» изменился, поэтому ваш получатель тоже изменился.", s.gameTitle(ctx, gameID)))
+		if err := s.SendAssignment(ctx, gameID, giverID); err != nil {
+			log.Printf("notifyChangedAssignments: failed to notify userID=%d: %v", giverID, err)
+		}
+	}
+	for _, giverID := range report.Assigned {
+		if err := s.SendAssignment(ctx, gameID, giverID); err != nil {
+			log.Printf("notifyChangedAssignments: failed to notify userID=%d: %v", giverID, err)
+		}
+	}
+	for _, giverID := range report.Orphaned {
+		s.sendMessage(ctx, giverID, fmt.Sprintf("⚠️ Ваш получатель в игре «%s» вышел из нее. "+
+			"Администратор решит, кому вы будете дарить подарок.", s.gameTitle(ctx, gameID)))
+	}
+}
+
+// admitParticipant включает в уже созданное распределение участника, добавленного после /generate.
+// confirmed разрешает менять получателей у других участников, если просто вставить новичка в круг нельзя.
+func (s *SecretSantaBot) admitParticipant(ctx context.Context, gameID, userID int64, confirmed bool) (*RepairReport, error) {
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
+	}
+	if _, assigned := assignments[userID]; len(assignments) == 0 || assigned {
+		return &RepairReport{}, nil
+	}
+
+	report, err := s.repairAssignments(ctx, gameID, assignments, []int64{userID}, confirmed)
+	if err != nil {
+		return nil, err
+	}
+	if !report.NeedsConfirmation && !report.Failed {
+		s.notifyChangedAssignments(ctx, gameID, report)
+	}
+	return report, nil
+}
+
+func (s *SecretSantaBot) admissionNote(ctx context.Context, gameID, userID int64) string {
+	report, err := s.admitParticipant(ctx, gameID, userID, false)
+	if err != nil {
+		log.Printf("admissionNote: failed to admit userID=%d into gameID=%d: %v", userID, gameID, err)
+		return fmt.Sprintf("\n\n⚠️ Не удалось включить участника в текущее распределение: %v", err)
+	}
+	if report.Failed {
+		return "\n\n⚠️ Участник пока не включен в распределение: ограничения не позволяют встроить его в текущий круг. " +
+			"Пары остальных участников не изменены. Включить его можно только новой жеребьевкой - " +
+			"решение за администратором (/generate и /startgame)."
+	}
+	if report.NeedsConfirmation {
+		target := fmt.Sprintf("%d", userID)
+		if p, err := s.Storage.GetParticipant(ctx, gameID, userID); err == nil && p != nil && p.Username != "" {
+			target = "@" + p.Username
+		}
+		return fmt.Sprintf("\n\n⚠️ Участник пока не включен в распределение: ограничения не позволяют встроить его в круг, не меняя чужих пар. "+
+			"Администратор может включить его командой /admit %s - тогда получатель изменится еще у %d участников, "+
+			"или заново выполнить /generate.", target, len(report.Changed))
+	}
+	if len(report.Assigned) == 0 {
+		return ""
+	}
+
+	_, gameStarted, _ := s.Storage.GetGameState(ctx, gameID)
+	if !gameStarted {
+		return "\n\n🔄 Участник включен в уже созданное распределение."
+	}
+	return fmt.Sprintf("\n\n🔄 Игра уже идет: участник включен в распределение, новые назначения отправлены только ему и еще %d участникам.", len(report.Changed))
+}
+
+// handleAdmit включает в распределение участника, которого не удалось встроить в круг
+// без изменения чужих пар: администратор соглашается, что получатели изменятся и у других.
+func (s *SecretSantaBot) handleAdmit(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		return
+	}
+
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Укажите участника. Пример: /admit @username")
+		return
+	}
+
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка получения участников: %v", err))
+		return
+	}
+	userID, found := findParticipant(participants, arg)
+	if !found {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Участник %s не найден в игре.", arg))
+		return
+	}
+	name := participantDisplayName(participants, userID)
+
+	report, err := s.admitParticipant(ctx, gameID, userID, true)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Не удалось включить участника %s в распределение: %v", name, err))
+		return
+	}
+
+	switch {
+	case report.Failed:
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("⚠️ Включить участника %s в текущее распределение не удалось даже с изменением чужих пар. "+
+			"Распределение не изменено. Чтобы включить участника, проведите жеребьевку заново: /generate и /startgame.", name))
+	case len(report.Assigned) == 0:
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("ℹ️ Участник %s уже включен в распределение или распределение еще не создано.", name))
+	default:
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("✅ Участник %s включен в распределение, получатель изменился еще у %d участников.", name, len(report.Changed)))
+	}
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"telegram-secret-santa/internal/domain"
+)
+
+// testSolver строит решатель для участников 0..n-1 с запретами forbidden[giver] = получатели.
+func testSolver(n int, forbidden map[int64][]int64) *assignmentSolver {
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = int64(i)
+	}
+	restrictions := make(map[int64]map[int64]bool)
+	for giver, receivers := range forbidden {
+		restrictions[giver] = make(map[int64]bool)
+		for _, receiver := range receivers {
+			restrictions[giver][receiver] = true
+		}
+	}
+	s := newAssignmentSolver(ids, restrictions)
+	s.setSeed(1)
+	return s
+}
+
+func checkAllowed(t *testing.T, s *assignmentSolver, receiverOf []int) {
+	t.Helper()
+	seen := make(map[int]bool)
+	for giver, receiver := range receiverOf {
+		if !s.allowed[giver][receiver] {
+			t.Fatalf("%d → %d is not allowed: %v", giver, receiver, receiverOf)
+		}
+		if seen[receiver] {
+			t.Fatalf("%d receives twice: %v", receiver, receiverOf)
+		}
+		seen[receiver] = true
+	}
+}
+
+func isSingleCycle(receiverOf []int) bool {
+	node, length := 0, 0
+	for {
+		node = receiverOf[node]
+		length++
+		if node == 0 {
+			return length == len(receiverOf)
+		}
+		if length > len(receiverOf) {
+			return false
+		}
+	}
+}
+
+func changedGivers(current, result []int) int {
+	changed := 0
+	for giver, receiver := range result {
+		if current[giver] != receiver {
+			changed++
+		}
+	}
+	return changed
+}
+
+// bruteForceMinChanges перебирает все общие круги и возвращает наименьшее число смен.
+func bruteForceMinChanges(s *assignmentSolver, current []int) int {
+	n := len(current)
+	best := -1
+	receiverOf := make([]int, n)
+	visited := make([]bool, n)
+	var extend func(node, length int)
+	extend = func(node, length int) {
+		if length == n {
+			if s.allowed[node][0] {
+				receiverOf[node] = 0
+				if changes := changedGivers(current, receiverOf); best == -1 || changes < best {
+					best = changes
+				}
+			}
+			return
+		}
+		for next := 0; next < n; next++ {
+			if !visited[next] && s.allowed[node][next] {
+				visited[next] = true
+				receiverOf[node] = next
+				extend(next, length+1)
+				visited[next] = false
+			}
+		}
+	}
+	visited[0] = true
+	extend(0, 1)
+	return best
+}
+
+func TestMinChangeCycleMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for trial := 0; trial < 200; trial++ {
+		n := 4 + rng.Intn(4)
+		forbidden := make(map[int64][]int64)
+		for giver := 0; giver < n; giver++ {
+			for receiver := 0; receiver < n; receiver++ {
+				if giver != receiver && rng.Intn(3) == 0 {
+					forbidden[int64(giver)] = append(forbidden[int64(giver)], int64(receiver))
+				}
+			}
+		}
+		s := testSolver(n, forbidden)
+
+		// Прежний круг по порядку, из которого ушел участник между n-1 и 0.
+		current := make([]int, n)
+		for giver := range current {
+			current[giver] = (giver + 1) % n
+		}
+		current[n-1] = -1
+
+		want := bruteForceMinChanges(s, current)
+		got, err := s.minChangeCycle(current)
+		if want == -1 {
+			if !errors.Is(err, ErrModeUnsatisfiable) {
+				t.Fatalf("trial %d: err = %v, want ErrModeUnsatisfiable", trial, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		checkAllowed(t, s, got)
+		if !isSingleCycle(got) {
+			t.Fatalf("trial %d: %v is not a single cycle", trial, got)
+		}
+		if changes := changedGivers(current, got); changes != want {
+			t.Fatalf("trial %d: %d givers changed, brute force needs %d", trial, changes, want)
+		}
+	}
+}
+
+func TestRepairChainFallsBackToMinimalChange(t *testing.T) {
+	// Круг 0 → 1 → 2 → X → 3 → 4 → 0, X ушел. Сомкнуть 2 → 3 нельзя, и перенести
+	// одного участника на место X тоже: 2 может дарить только 1.
+	s := testSolver(5, map[int64][]int64{2: {3, 4, 0}, 1: {3}})
+	current := []int{1, 2, -1, 4, 0}
+
+	got, err := s.repair(domain.AssignmentModeChain, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAllowed(t, s, got)
+	if !isSingleCycle(got) {
+		t.Fatalf("%v is not a single cycle", got)
+	}
+	if changes, want := changedGivers(current, got), bruteForceMinChanges(s, current); changes != want {
+		t.Fatalf("%d givers changed, %d is enough", changes, want)
+	}
+}
+
+func TestRepairChainRefusesWhenNoCycleExists(t *testing.T) {
+	// Круг 0 → 1 → 2 → X → 0, X ушел; 2 не может дарить 0, другого круга из трех нет.
+	s := testSolver(3, map[int64][]int64{2: {0}, 0: {2}})
+	if result, err := s.repair(domain.AssignmentModeChain, []int{1, 2, -1}); err == nil {
+		t.Fatalf("repair = %v, want an error instead of a new draw", result)
+	}
+}
+
+func TestAdmitParticipantAsksBeforeChangingOtherPairs(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3)
+	for giver, receiver := range map[int64]int64{1: 2, 2: 3, 3: 1} {
+		mustDo(t, storage.SaveAssignment(ctx, testGameID, giver, receiver))
+	}
+	if _, err := bot.recordDraw(ctx, testGameID, "seed", "test", "🎲 Распределение создано."); err != nil {
+		t.Fatal(err)
+	}
+	published := queuedTexts(t, storage, testGameID)
+
+	// Новичка 4 нельзя вставить ни в одну пару круга 1 → 2 → 3 → 1.
+	addTestParticipants(t, storage, 4)
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 4, 2, 4))
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 4, 3, 4))
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 3, 4, 3))
+
+	report, err := bot.admitParticipant(ctx, testGameID, 4, false)
+	mustDo(t, err)
+	if !report.NeedsConfirmation || len(report.Changed) < 2 {
+		t.Fatalf("report = %+v; want a confirmation request naming the changed givers", report)
+	}
+	assignments, err := storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if !reflect.DeepEqual(assignments, map[int64]int64{1: 2, 2: 3, 3: 1}) {
+		t.Fatalf("assignments changed without confirmation: %v", assignments)
+	}
+
+	report, err = bot.admitParticipant(ctx, testGameID, 4, true)
+	mustDo(t, err)
+	if report.NeedsConfirmation || !reflect.DeepEqual(report.Assigned, []int64{4}) {
+		t.Fatalf("confirmed report = %+v", report)
+	}
+	assignments, err = storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if len(assignments) != 4 || assignments[4] != 1 {
+		t.Fatalf("assignments after /admit = %v", assignments)
+	}
+
+	if texts := queuedTexts(t, storage, testGameID); !reflect.DeepEqual(texts, published) {
+		t.Fatalf("repair posted to the group: %q", texts[len(published):])
+	}
+	record, err := storage.GetDrawRecord(ctx, testGameID)
+	mustDo(t, err)
+	if record.PublishedCommitment() == record.Commitment || !strings.Contains(published[0], record.PublishedCommitment()) {
+		t.Fatalf("record = %+v; want the new commitment kept apart from the published one", record)
+	}
+	proof, err := bot.formatProof(ctx, testGameID)
+	mustDo(t, err)
+	if !strings.Contains(proof, record.PublishedCommitment()) || !strings.Contains(proof, "-commitment "+record.Commitment) {
+		t.Fatalf("proof does not explain the repaired commitment:\n%s", proof)
+	}
+}
+
+func TestRepairStrategies(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      domain.AssignmentMode
+		n         int
+		forbidden map[int64][]int64
+		current   []int // -1 - у дарителя нет получателя
+		want      []int
+	}{
+		{
+			// 0 → 1 → 2 → X → 3 → 0: Санта ушедшего берет его получателя.
+			name:    "splice",
+			mode:    domain.AssignmentModeChain,
+			n:       4,
+			current: []int{1, 2, -1, 0},
+			want:    []int{1, 2, 3, 0},
+		},
+		{
+			name:    "splice in a game of two",
+			mode:    domain.AssignmentModeChain,
+			n:       2,
+			current: []int{1, -1},
+			want:    []int{1, 0},
+		},
+		{
+			// 0 → X → 1 → 0 и 2 → 3 → 4 → 2: сомкнуть 0 → 1 нельзя, получилась бы
+			// взаимная пара 0 ↔ 1.
+			name:    "nomutual splice falls back",
+			mode:    domain.AssignmentModeNoMutual,
+			n:       5,
+			current: []int{-1, 0, 3, 4, 2},
+			want:    nil,
+		},
+		{
+			// Новичок 3 встает между 2 и 0: в остальные пары его не пускают ограничения.
+			name:      "insert",
+			mode:      domain.AssignmentModeChain,
+			n:         4,
+			forbidden: map[int64][]int64{0: {3}, 1: {3}},
+			current:   []int{1, 2, 0, -1},
+			want:      []int{1, 2, 3, 0},
+		},
+		{
+			// 0 → 1 → 2 → 3 → X → 0; 3 не может дарить 0, а 3 → 2 запрещено, поэтому
+			// на место X переносится 1: 0 → 2 → 3 → 1 → 0.
+			name:      "relocate",
+			mode:      domain.AssignmentModeChain,
+			n:         4,
+			forbidden: map[int64][]int64{3: {0, 2}},
+			current:   []int{1, 2, 3, -1},
+			want:      []int{2, 0, 3, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSolver(tt.n, tt.forbidden)
+			got, err := s.repair(tt.mode, append([]int(nil), tt.current...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAllowed(t, s, got)
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("repair = %v, want %v", got, tt.want)
+			}
+			switch tt.mode {
+			case domain.AssignmentModeChain:
+				if !isSingleCycle(got) {
+					t.Fatalf("%v is not a single cycle", got)
+				}
+				if changes, want := changedGivers(tt.current, got), bruteForceMinChanges(s, tt.current); changes != want {
+					t.Fatalf("%d givers changed, %d is enough", changes, want)
+				}
+			case domain.AssignmentModeNoMutual:
+				if hasMutualPair(got) {
+					t.Fatalf("mutual pair in %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestMinChangeCycleStepLimit(t *testing.T) {
+	n := exactCycleMaxNodes + 4
+	current := make([]int, n)
+	for giver := range current {
+		current[giver] = (giver + 1) % n
+	}
+	current[n-1] = -1
+
+	s := testSolver(n, nil)
+	s.stepLimit = 1
+	if result, err := s.minChangeCycle(current); !errors.Is(err, ErrSearchLimit) {
+		t.Fatalf("minChangeCycle = %v, %v; want ErrSearchLimit", result, err)
+	}
+}
+
+func TestFailedAdmissionKeepsStartedDraw(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3)
+	want := map[int64]int64{1: 2, 2: 3, 3: 1}
+	for giver, receiver := range want {
+		mustDo(t, storage.SaveAssignment(ctx, testGameID, giver, receiver))
+	}
+	mustDo(t, storage.SaveGameState(ctx, testGameID, true, true))
+
+	// Новичку 4 некому дарить: включить его нельзя даже с изменением чужих пар.
+	addTestParticipants(t, storage, 4)
+	for _, receiver := range []int64{1, 2, 3} {
+		mustDo(t, storage.SaveRestriction(ctx, testGameID, 4, receiver, 4))
+	}
+
+	for _, confirmed := range []bool{false, true} {
+		report, err := bot.admitParticipant(ctx, testGameID, 4, confirmed)
+		mustDo(t, err)
+		if !report.Failed || len(report.Assigned) != 0 {
+			t.Fatalf("confirmed=%v: report = %+v, want a failed admission", confirmed, report)
+		}
+		assignments, err := storage.GetAllAssignments(ctx, testGameID)
+		mustDo(t, err)
+		if !reflect.DeepEqual(assignments, want) {
+			t.Fatalf("confirmed=%v: assignments = %v, want %v", confirmed, assignments, want)
+		}
+		active, started, err := storage.GetGameState(ctx, testGameID)
+		mustDo(t, err)
+		if !active || !started {
+			t.Fatalf("confirmed=%v: game state = %v, %v; want the started game kept", confirmed, active, started)
+		}
+	}
+}
+
+func TestFailedRemovalKeepsOtherPairs(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	bot := newTestBot(storage)
+	addTestParticipants(t, storage, 1, 2, 3)
+	for giver, receiver := range map[int64]int64{1: 2, 2: 3, 3: 1} {
+		mustDo(t, storage.SaveAssignment(ctx, testGameID, giver, receiver))
+	}
+	mustDo(t, storage.SaveGameState(ctx, testGameID, true, true))
+	// Без 3 остается только 1 ↔ 2, но 2 не может дарить 1.
+	mustDo(t, storage.SaveRestriction(ctx, testGameID, 2, 1, 2))
+
+	report, err := bot.RemoveParticipant(ctx, testGameID, 3)
+	mustDo(t, err)
+	if !report.Failed || !reflect.DeepEqual(report.Orphaned, []int64{2}) {
+		t.Fatalf("report = %+v, want a failed repair naming the orphaned Santa", report)
+	}
+	assignments, err := storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if !reflect.DeepEqual(assignments, map[int64]int64{1: 2, 2: 3}) {
+		t.Fatalf("assignments = %v, want the other pairs kept", assignments)
+	}
+	if active, started, err := storage.GetGameState(ctx, testGameID); err != nil || !active || !started {
+		t.Fatalf("game state = %v, %v, %v; want the started game kept", active, started, err)
+	}
+
+	// Новичок закрывает разрыв: 2 → 4 → 1, остальные пары не меняются.
+	addTestParticipants(t, storage, 4)
+	report, err = bot.admitParticipant(ctx, testGameID, 4, false)
+	mustDo(t, err)
+	if report.Failed || report.NeedsConfirmation {
+		t.Fatalf("report = %+v, want the newcomer admitted", report)
+	}
+	assignments, err = storage.GetAllAssignments(ctx, testGameID)
+	mustDo(t, err)
+	if !reflect.DeepEqual(assignments, map[int64]int64{1: 2, 2: 4, 4: 1}) {
+		t.Fatalf("assignments after the admission = %v", assignments)
+	}
+}
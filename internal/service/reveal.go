@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	revealCheckInterval = 30 * time.Second
+	revealDateLayout    = "02.01.2006 15:04"
+	maxMessageLength    = 4000
+)
+
+func parseRevealTime(args []string) (time.Time, bool) {
+	value := strings.Join(args, " ")
+	for _, layout := range []string{revealDateLayout, "02.01.2006"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// splitMessage разбивает длинный текст по строкам на части, которые Telegram примет одним сообщением.
+func splitMessage(text string) []string {
+	var parts []string
+	var part strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if part.Len() > 0 && part.Len()+len(line) > maxMessageLength {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		part.WriteString(line)
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return parts
+}
+
+func (s *SecretSantaBot) getRevealSettings(ctx context.Context, gameID int64) *domain.RevealSettings {
+	r, err := s.Storage.GetRevealSettings(ctx, gameID)
+	if err != nil {
+		log.Printf("getRevealSettings: failed to get reveal settings for gameID=%d: %v", gameID, err)
+	}
+	if r == nil {
+		r = &domain.RevealSettings{}
+	}
+	return r
+}
+
+// RevealGame публикует в групповом чате игры все пары «даритель → получатель».
+func (s *SecretSantaBot) RevealGame(ctx context.Context, gameID int64) error {
+	unlock := s.lockGame(gameID)
+	defer unlock()
+
+	_, gameStarted, err := s.Storage.GetGameState(ctx, gameID)
+	if err != nil {
+		return fmt.Errorf("failed to get game state: %w", err)
+	}
+	assignments, err := s.Storage.GetAllAssignments(ctx, gameID)
+	if err != nil {
+		return fmt.Errorf("failed to get assignments: %w", err)
+	}
+	if !gameStarted || len(assignments) == 0 {
+		return fmt.Errorf("game has not started")
+	}
+	participants, err := s.Storage.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		return fmt.Errorf("failed to get participants: %w", err)
+	}
+
+	pairs := make([]string, 0, len(assignments))
+	for giverID, receiverID := range assignments {
+		pairs = append(pairs, fmt.Sprintf("🎁 %s → %s", participantDisplayName(participants, giverID), participantDisplayName(participants, receiverID)))
+	}
+	sort.Strings(pairs)
+
+	text := fmt.Sprintf("🎉 Тайный Санта раскрыт! Игра «%s»\n\nКто кому дарил:\n\n%s",
+		s.gameTitle(ctx, gameID), strings.Join(pairs, "\n"))
+	for _, part := range splitMessage(text) {
+		s.sendMessage(ctx, gameID, part)
+	}
+
+	proof, err := s.formatProof(ctx, gameID)
+	if err != nil {
+		log.Printf("RevealGame: failed to build proof for gameID=%d: %v", gameID, err)
+	}
+	for _, part := range splitMessage(proof) {
+		s.sendMessage(ctx, gameID, part)
+	}
+
+	settings := s.getRevealSettings(ctx, gameID)
+	settings.RevealedAt = time.Now()
+	settings.ScheduledAt = time.Time{}
+	if err := s.Storage.SaveRevealSettings(ctx, gameID, settings); err != nil {
+		return fmt.Errorf("failed to save reveal settings: %w", err)
+	}
+	log.Printf("RevealGame: revealed %d pairings for gameID=%d", len(pairs), gameID)
+	return nil
+}
+
+// RunScheduler раскрывает пары в играх, для которых администратор назначил время раскрытия.
+func (s *SecretSantaBot) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(revealCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.revealDue(ctx)
+		}
+	}
+}
+
+func (s *SecretSantaBot) revealDue(ctx context.Context) {
+	games, err := s.Storage.GetAllGames(ctx)
+	if err != nil {
+		log.Printf("revealDue: failed to get games: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for gameID := range games {
+		settings := s.getRevealSettings(ctx, gameID)
+		if settings.ScheduledAt.IsZero() || settings.ScheduledAt.After(now) {
+			continue
+		}
+		if err := s.RevealGame(ctx, gameID); err != nil {
+			log.Printf("revealDue: failed to reveal gameID=%d, cancelling schedule: %v", gameID, err)
+			settings.ScheduledAt = time.Time{}
+			if err := s.Storage.SaveRevealSettings(ctx, gameID, settings); err != nil {
+				log.Printf("revealDue: failed to save reveal settings for gameID=%d: %v", gameID, err)
+			}
+		}
+	}
+}
+
+func formatRevealSettings(settings *domain.RevealSettings) string {
+	var text strings.Builder
+	switch {
+	case !settings.RevealedAt.IsZero():
+		text.WriteString(fmt.Sprintf("🎉 Пары раскрыты %s.\n", settings.RevealedAt.Format(revealDateLayout)))
+	case !settings.ScheduledAt.IsZero():
+		text.WriteString(fmt.Sprintf("⏰ Раскрытие пар назначено на %s.\n", settings.ScheduledAt.Format(revealDateLayout)))
+	default:
+		text.WriteString("Раскрытие пар не назначено.\n")
+	}
+	if settings.SantaLookup {
+		text.WriteString(fmt.Sprintf("🔎 Узнать своего Санту через /mysanta можно с %s.\n", settings.SantaLookupFrom.Format(revealDateLayout)))
+	} else {
+		text.WriteString("🔎 Узнать своего Санту до раскрытия нельзя.\n")
+	}
+	return text.String()
+}
+
+func (s *SecretSantaBot) handleReveal(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if !s.IsAdmin(msg.From.UserName) {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ Эта команда доступна только администраторам.")
+		return
+	}
+
+	usage := "/reveal now - раскрыть пары сейчас\n" +
+		"/reveal ДД.ММ.ГГГГ [ЧЧ:ММ] - раскрыть пары в заданное время\n" +
+		"/reveal cancel - отменить запланированное раскрытие\n" +
+		"/reveal santa ДД.ММ.ГГГГ [ЧЧ:ММ] - разрешить участникам узнавать своего Санту через /mysanta с этой даты\n" +
+		"/reveal santa off - запретить узнавать Санту до раскрытия"
+
+	settings := s.getRevealSettings(ctx, gameID)
+	args := strings.Fields(strings.ToLower(msg.CommandArguments()))
+	if len(args) == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, formatRevealSettings(settings)+"\n"+usage)
+		return
+	}
+
+	switch args[0] {
+	case "now":
+		if err := s.RevealGame(ctx, gameID); err != nil {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Не удалось раскрыть пары: %v\n\nПары можно раскрыть только после /startgame.", err))
+			return
+		}
+		if !isGroupChat(msg.Chat) {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🎉 Пары раскрыты в чате игры «%s».", s.gameTitle(ctx, gameID)))
+		}
+		return
+
+	case "cancel":
+		settings.ScheduledAt = time.Time{}
+
+	case "santa":
+		if len(args) == 2 && args[1] == "off" {
+			settings.SantaLookup = false
+			settings.SantaLookupFrom = time.Time{}
+			break
+		}
+		from, ok := parseRevealTime(args[1:])
+		if !ok {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Неверная дата. Пример: /reveal santa 25.12.2026 18:00")
+			return
+		}
+		settings.SantaLookup = true
+		settings.SantaLookupFrom = from
+
+	default:
+		at, ok := parseRevealTime(args)
+		if !ok {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Неизвестная команда.\n\n"+usage)
+			return
+		}
+		if at.Before(time.Now()) {
+			s.sendMessage(ctx, msg.Chat.ID, "❌ Это время уже прошло. Чтобы раскрыть пары сейчас, используйте /reveal now")
+			return
+		}
+		settings.ScheduledAt = at
+	}
+
+	if err := s.Storage.SaveRevealSettings(ctx, gameID, settings); err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка сохранения настроек раскрытия: %v", err))
+		return
+	}
+	s.sendMessage(ctx, msg.Chat.ID, "✅ Настройки раскрытия сохранены.\n\n"+formatRevealSettings(settings))
+}
+
+func (s *SecretSantaBot) handleMySanta(ctx context.Context, msg *tgbotapi.Message, gameID int64) {
+	if isGroupChat(msg.Chat) {
+		s.sendMessage(ctx, msg.Chat.ID, "🤫 Эта команда работает только в личных сообщениях с ботом.")
+		return
+	}
+
+	settings := s.getRevealSettings(ctx, gameID)
+	now := time.Now()
+	allowed := !settings.RevealedAt.IsZero() || (settings.SantaLookup && !now.Before(settings.SantaLookupFrom))
+	if !allowed {
+		if settings.SantaLookup {
+			s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🤫 Узнать своего Санту можно будет с %s.", settings.SantaLookupFrom.Format(revealDateLayout)))
+			return
+		}
+		s.sendMessage(ctx, msg.Chat.ID, "🤫 Пока это секрет! Ваш Санта откроется, когда администратор раскроет пары.")
+		return
+	}
+
+	santaID, err := s.santaOf(ctx, gameID, msg.From.ID)
+	if err != nil {
+		s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("❌ Ошибка: %v", err))
+		return
+	}
+	if santaID == 0 {
+		s.sendMessage(ctx, msg.Chat.ID, "❌ У вас нет Тайного Санты в этой игре.")
+		return
+	}
+
+	participants, _ := s.Storage.GetAllParticipants(ctx, gameID)
+	s.sendMessage(ctx, msg.Chat.ID, fmt.Sprintf("🎅 Вашим Тайным Сантой в игре «%s» был(а): %s",
+		s.gameTitle(ctx, gameID), participantDisplayName(participants, santaID)))
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+)
+
+// startGame добавляет игроков в игру, создает распределение и рассылает его,
+// проверяя все сообщения, которые при этом получают участники.
+func (sc *scenario) startGame(players ...string) {
+	sc.t.Helper()
+	for _, name := range players {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру, " + sc.user(name).FirstName)
+	}
+	sc.group("admin", "/generate")
+	sc.expectGroup("🔐 Отпечаток распределения", "✅ Распределение успешно создано (режим: любое распределение)")
+	sc.group("admin", "/startgame")
+	sc.expectGroup("✅ Игра начата!")
+	sc.expectAssignments(players...)
+}
+
+// expectAssignments проверяет рассылку после /startgame: каждый игрок получает своего
+// получателя, а администратор еще и отчет о доставке.
+func (sc *scenario) expectAssignments(players ...string) {
+	sc.t.Helper()
+	for _, name := range players {
+		assignment := "Вы дарите подарок: " + sc.display(sc.receiver(name))
+		if name == "admin" {
+			sc.expect(name, "✅ Игра начата!", assignment, fmt.Sprintf("Доставлено: %d из %d", len(players), len(players)))
+		} else {
+			sc.expect(name, assignment)
+		}
+	}
+}
+
+func TestScenarioRestrictionsAndRemovalAfterStart(t *testing.T) {
+	sc := newScenario(t)
+	players := []string{"admin", "anya", "boris", "vera", "gleb"}
+	for _, name := range players {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру, " + sc.user(name).FirstName)
+	}
+	sc.group("anya", "/restrict @boris")
+	sc.expectGroup("вы не получите @boris")
+	sc.group("vera", "/restrict @gleb")
+	sc.expectGroup("вы не получите @gleb")
+
+	sc.group("admin", "/generate")
+	sc.expectGroup("🔐 Отпечаток распределения", "✅ Распределение успешно создано")
+	if sc.receiver("anya") == "boris" || sc.receiver("vera") == "gleb" {
+		t.Fatalf("restriction ignored: anya -> %s, vera -> %s", sc.receiver("anya"), sc.receiver("vera"))
+	}
+
+	sc.group("admin", "/startgame")
+	sc.expectGroup("✅ Игра начата!")
+	sc.expectAssignments(players...)
+
+	sc.group("gleb", "/remove")
+	sc.expectGroup("Выйти из игры можно только с подтверждения администратора: попросите его выполнить /remove @gleb")
+
+	before := make(map[string]string)
+	for _, name := range players {
+		before[name] = sc.receiver(name)
+	}
+	sc.group("admin", "/remove @gleb")
+
+	var changed []string
+	for _, name := range players[:4] {
+		receiver := sc.receiver(name)
+		if receiver == "gleb" || receiver == name {
+			t.Fatalf("%s gives to %s after gleb left", name, receiver)
+		}
+		if receiver != before[name] {
+			changed = append(changed, name)
+			sc.expect(name, "ваш получатель тоже изменился", "Вы дарите подарок: "+sc.display(receiver))
+		}
+	}
+	if len(changed) == 0 {
+		t.Fatalf("nobody got a new receiver after gleb left: %v", before)
+	}
+	sc.expectGroup(fmt.Sprintf("✅ Участник Gleb удален из игры.\n\n🔄 Распределение исправлено: получатель изменился у %d участников", len(changed)))
+	sc.expectNothingElse()
+}
+
+func TestScenarioWishesAndComments(t *testing.T) {
+	sc := newScenario(t)
+	sc.private("anya", "/wish книги")
+	sc.expect("anya", "❌ Вы пока не участвуете ни в одной игре")
+
+	for _, name := range []string{"admin", "anya", "boris"} {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру")
+	}
+	sc.group("anya", "/wish книги")
+	sc.expectGroup("✅ Ваше желание сохранено:\n\nкниги")
+	sc.private("anya", "/mywish")
+	sc.expect("anya", "💝 Ваше желание:\n\nкниги")
+
+	sc.private("boris", "/wish носки")
+	sc.expect("boris", "✅ Ваше желание сохранено:\n\nноски")
+	sc.private("boris", "/deletewish")
+	sc.expect("boris", "✅ Ваше желание удалено.")
+	sc.private("boris", "/mywish")
+	sc.expect("boris", "💝 У вас пока нет сохраненного желания.")
+
+	sc.group("boris", "/comment @anya любит фантастику")
+	sc.expectGroup("✅ Комментарий добавлен для Anya (@anya)!\n\n💬 Ваш комментарий:\nлюбит фантастику")
+
+	sc.group("admin", "/generate")
+	sc.expectGroup("🔐 Отпечаток распределения", "✅ Распределение успешно создано")
+	sc.group("admin", "/send")
+	sc.expectGroup("✅ Игра начата!")
+	sc.expectAssignments("admin", "anya", "boris")
+
+	santa := sc.santa("anya")
+	sc.private(santa, "/myassignment")
+	sc.expect(santa, "Вы дарите подарок: Anya (@anya)\n\n💝 Желание получателя:\nкниги\n\n💬 Комментарии от участников:\n\n👤 Boris (@boris):\nлюбит фантастику")
+
+	sc.group("admin", "/resend @"+santa)
+	sc.expect(santa, "💝 Желание получателя:\nкниги")
+	sc.expectGroup("✅ Назначение повторно отправлено участнику " + sc.display(santa))
+	sc.expectNothingElse()
+}
+
+func TestScenarioRestrictionsPreferencesAndGroups(t *testing.T) {
+	sc := newScenario(t)
+	for _, name := range []string{"admin", "anya", "boris", "vera", "gleb"} {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру")
+	}
+
+	sc.group("anya", "/restrict @boris")
+	sc.expectGroup("✅ Ограничение добавлено и сохранено: вы не получите @boris")
+	sc.group("anya", "/restrictions")
+	sc.expectGroup("*Вы* не получите:\n  \\- Boris \\(@boris\\)")
+	sc.group("admin", "/restrictions")
+	sc.expectGroup("*Anya* не получит:\n  \\- Boris \\(@boris\\)")
+	sc.group("anya", "/unrestrict @boris")
+	sc.expectGroup("✅ Ограничение удалено")
+	sc.group("anya", "/restrictions")
+	sc.expectGroup("📋 У вас нет ограничений.")
+
+	sc.group("boris", "/prefer @vera")
+	sc.expectGroup("✅ Пожелание сохранено: Vera (@vera) — хотели бы подарить.")
+	sc.group("boris", "/avoid @anya")
+	sc.expectGroup("✅ Пожелание сохранено: Anya (@anya) — лучше не дарить.")
+	sc.group("boris", "/unprefer @anya")
+	sc.expectGroup("✅ Пожелание для Anya (@anya) удалено.")
+	sc.private("boris", "/preferences")
+	sc.expect("boris", "⭐ Пожелания (💚 хотели бы подарить, 🚫 лучше не дарить):\n\n  💚 Vera (@vera)\n")
+
+	sc.group("admin", "/group create Семья")
+	sc.expectGroup("✅ Группа «Семья» создана.")
+	sc.group("admin", "/group add Семья @anya @vera @gleb")
+	sc.expectGroup("✅ В группу «Семья» добавлены:\n• Anya (@anya)\n• Vera (@vera)\n• Gleb (@gleb)")
+	sc.group("admin", "/group remove Семья @gleb")
+	sc.expectGroup("✅ Из группы «Семья» убраны:\n• Gleb (@gleb)")
+	sc.group("admin", "/group")
+	sc.expectGroup("Семья (не дарят друг другу):\n  - Anya (@anya)\n  - Vera (@vera)")
+	sc.group("admin", "/group nonsense")
+	sc.expectGroup("❌ Используйте:")
+
+	sc.group("anya", "/generate")
+	sc.expectGroup("❌ Эта команда доступна только администраторам.")
+	sc.group("admin", "/generate chain")
+	sc.expectGroup("🔐 Отпечаток распределения", "✅ Распределение успешно создано (режим: один общий круг)")
+	if sc.receiver("anya") == "vera" || sc.receiver("vera") == "anya" {
+		t.Fatalf("group members give to each other: anya -> %s, vera -> %s", sc.receiver("anya"), sc.receiver("vera"))
+	}
+
+	sc.group("admin", "/group delete Семья")
+	sc.expectGroup("✅ Группа «Семья» удалена.")
+	sc.group("admin", "/generate nomutual")
+	sc.expectGroup("🔐 Отпечаток распределения", "✅ Распределение успешно создано (режим: без взаимных пар)")
+	sc.expectNothingElse()
+}
+
+func TestScenarioAnonymousChatAndGifts(t *testing.T) {
+	sc := newScenario(t)
+	sc.startGame("admin", "anya", "boris")
+	santa := sc.santa("anya")
+
+	sc.private(santa, "/ask какой размер?")
+	sc.expect("anya", "🎅 Сообщение от вашего Тайного Санты (игра «Офис»):\n\nкакой размер?")
+	sc.expect(santa, "✅ Сообщение отправлено получателю.")
+
+	sc.reply("anya", "M")
+	sc.expect(santa, "🎁 Ответ от вашего получателя Anya (игра «Офис»):\n\nM")
+	sc.expect("anya", "✅ Ответ отправлен вашему Тайному Санте.")
+	sc.private("anya", "/answer и шарф")
+	sc.expect(santa, "🎁 Ответ от вашего получателя Anya (игра «Офис»):\n\nи шарф")
+	sc.expect("anya", "✅ Ответ отправлен вашему Тайному Санте.")
+
+	if answer := sc.press(santa, fmt.Sprintf("gift:%d:bought", testGameID)); answer != "✅ Статус подарка: 🛍 куплен" {
+		t.Fatalf("button answer = %q", answer)
+	}
+	sc.private(santa, "/gift delivered")
+	sc.expect("anya", "🚚 Ваш Тайный Санта (игра «Офис») сообщает, что подарок доставлен.")
+	sc.expect(santa, "✅ Статус подарка: 🚚 доставлен")
+
+	sc.private("anya", "/gotit")
+	sc.expect(santa, "🎉 Получатель подтвердил, что получил ваш подарок (игра «Офис»).")
+	sc.expect("anya", "🎉 Спасибо! Ваш Тайный Санта узнает, что подарок дошел.")
+
+	sc.group("admin", "/status")
+	sc.expectGroup("💬 Анонимных сообщений между Сантами и получателями: 3")
+	sc.expectNothingElse()
+}
+
+func TestScenarioEntropyRevealAndHistory(t *testing.T) {
+	sc := newScenario(t)
+	for _, name := range []string{"admin", "anya", "boris"} {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру")
+	}
+	sc.group("anya", "/entropy снег")
+	sc.expectGroup("🤫 Вклад в жеребьевку отправляется в личных сообщениях")
+	sc.private("anya", "/entropy снег")
+	sc.expectGroup("🎲 Anya внес(ла) вклад в зерно жеребьевки.")
+	sc.expect("anya", "✅ Вклад принят.")
+
+	sc.group("admin", "/generate")
+	sc.expectGroup("🔐 Отпечаток распределения", "✅ Распределение успешно создано")
+	sc.group("admin", "/startgame")
+	sc.expectGroup("✅ Игра начата!")
+	sc.expectAssignments("admin", "anya", "boris")
+
+	sc.private("anya", "/pairs")
+	sc.expect("anya", "❌ Эта команда доступна только администраторам.")
+	sc.group("admin", "/pairs")
+	sc.expectGroup("🤫 Распределение можно посмотреть только в личных сообщениях с ботом.")
+	sc.private("admin", "/pairs")
+	sc.expect("admin", "🎁 Anya (@anya) → "+sc.display(sc.receiver("anya")))
+	sc.expectGroup("🔍 Администратор @admin посмотрел распределение игры.")
+
+	sc.private("anya", "/mysanta")
+	sc.expect("anya", "🤫 Пока это секрет!")
+	sc.group("admin", "/reveal santa 25.12.2099 18:00")
+	sc.expectGroup("✅ Настройки раскрытия сохранены.\n\nРаскрытие пар не назначено.\n🔎 Узнать своего Санту через /mysanta можно с 25.12.2099 18:00.")
+	sc.group("admin", "/reveal")
+	sc.expectGroup("/reveal now - раскрыть пары сейчас")
+	sc.group("admin", "/reveal now")
+	sc.expectGroup("🎉 Тайный Санта раскрыт! Игра «Офис»", "🔐 Проверка честности жеребьевки")
+	sc.private("anya", "/mysanta")
+	sc.expect("anya", "🎅 Вашим Тайным Сантой в игре «Офис» был(а): "+sc.display(sc.santa("anya")))
+
+	sc.group("admin", "/history")
+	sc.expectGroup("📜 История сезонов отправлена вам в личные сообщения.")
+	sc.expect("admin", "Завершенных сезонов пока нет.")
+	sc.group("anya", "/reset")
+	sc.expectGroup("❌ Эта команда доступна только администраторам.")
+	sc.group("admin", "/reset")
+	sc.expectGroup("🔄 Игра сброшена, распределение сезона сохранено в историю.")
+	sc.group("admin", "/history")
+	sc.expectGroup("📜 История сезонов отправлена вам в личные сообщения.")
+	sc.expect("admin", "(пар: 3)")
+	sc.group("admin", "/history avoid 1 hard")
+	sc.expectGroup("✅ При генерации повторы пар из последних 1 сезонов будут исключаться строго.")
+	sc.group("admin", "/status")
+	sc.expectGroup("Участников: 0\nРаспределение создано: ❌ Нет")
+	sc.expectNothingElse()
+}
+
+func TestScenarioUnreachableParticipant(t *testing.T) {
+	sc := newScenario(t)
+	players := []string{"admin", "anya", "boris", "vera"}
+	sc.messenger.blocked[sc.userID("vera")] = true
+	for _, name := range players {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру")
+	}
+
+	sc.group("admin", "/generate")
+	sc.expectGroup(
+		"📭 @vera, бот не может написать вам в личные сообщения",
+		"📭 Бот не может написать в личные сообщения участникам (1): Vera (@vera).",
+	)
+	sc.group("admin", "/list")
+	sc.expectGroup("📭 Vera \\(@vera\\)")
+	sc.group("admin", "/generate force")
+	sc.expectGroup("🔐 Отпечаток распределения", "📭 Бот пока не может написать участникам: 1.")
+
+	sc.group("admin", "/startgame")
+	sc.expectGroup("✅ Игра начата!")
+	for _, name := range []string{"anya", "boris"} {
+		sc.expect(name, "Вы дарите подарок: "+sc.display(sc.receiver(name)))
+	}
+	sc.expect("admin", "✅ Игра начата!", "Вы дарите подарок: "+sc.display(sc.receiver("admin")),
+		"Доставлено: 3 из 4\n\n❌ Vera (@vera) — бот заблокирован или пользователь не начинал с ним диалог")
+
+	delete(sc.messenger.blocked, sc.userID("vera"))
+	sc.private("vera", fmt.Sprintf("/start g%d", testGameID))
+	sc.expect("vera", "✅ Готово! Теперь бот сможет прислать вам результаты игры «Офис».", "Вы дарите подарок: "+sc.display(sc.receiver("vera")))
+	sc.expectNothingElse()
+}
+
+func TestScenarioGeneralCommands(t *testing.T) {
+	sc := newScenario(t)
+	sc.messenger.memberCount = 9
+
+	sc.private("anya", "/start")
+	sc.expect("anya", "🎅 *Бот для Тайного Санты*")
+	sc.group("anya", "/help")
+	sc.expectGroup("/add - Добавить себя в игру")
+	sc.group("admin", "/help")
+	sc.expectGroup("*Команды для администраторов:*")
+	sc.group("anya", "/foo")
+	sc.expectGroup("Неизвестная команда. Используйте /help для списка команд.")
+
+	for _, name := range []string{"admin", "anya", "boris"} {
+		sc.group(name, "/add")
+		sc.expectGroup("✅ Вы добавлены в игру")
+	}
+	sc.private("anya", "/games")
+	sc.expect("anya", "• Офис — /game -100")
+
+	sc.group("admin", "/adduser @gleb")
+	sc.expectGroup("❌ Не удалось найти пользователя @gleb в группе.")
+	sc.private("gleb", "/start")
+	sc.expect("gleb", "🎅 *Бот для Тайного Санты*")
+	sc.group("admin", "/adduser @gleb")
+	sc.expectGroup("✅ Пользователь Gleb (@gleb) добавлен в игру!")
+	sc.group("gleb", "/remove")
+	sc.expectGroup("✅ Вы удалены из игры.")
+
+	sc.group("admin", "/list")
+	sc.expectGroup("✅ Anya \\(@anya\\)")
+	sc.group("anya", "/status")
+	sc.expectGroup("Участников: 3\nРаспределение создано: ❌ Нет\nРезультаты отправлены: ❌ Нет")
+	sc.group("admin", "/members")
+	sc.expectGroup("Участников в группе: 9\nАдминистраторов: 0\nСохранено ботом: 4 пользователей\nУчаствует в игре: 3")
+
+	sc.private("anya", "/addtrigger ёлка")
+	sc.expect("anya", "✅ Слово-триггер 'ёлка' добавлено!")
+	// В тексте команды есть триггер anya, у которого еще нет сообщений, поэтому бот
+	// отвечает стандартным сообщением триггера.
+	sc.private("anya", "/addtriggermessage ёлка|🎄 Ёлочка!")
+	sc.expect("anya", "💩 Санта проклинает тебя", "✅ Сообщение добавлено к триггеру 'ёлка'!")
+	sc.group("boris", "у нас ёлка")
+	sc.expectGroup("🎄 Ёлочка!")
+	sc.group("boris", "ну это мат")
+	sc.expectGroup("💩 Санта проклинает тебя")
+	sc.expectNothingElse()
+}
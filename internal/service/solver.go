@@ -0,0 +1,937 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+)
+
+var (
+	ErrNoValidAssignment = errors.New("no valid assignment exists with current restrictions")
+	ErrModeUnsatisfiable = errors.New("no valid assignment exists for the selected mode")
+	ErrSearchLimit       = errors.New("search limit reached before a valid assignment was found")
+)
+
+const (
+	searchStepLimit    = 2000000
+	exactCycleMaxNodes = 16
+)
+
+type Restriction struct {
+	UserID          int64
+	ForbiddenUserID int64
+}
+
+// InfeasibilityError доказывает невозможность распределения: группа People может
+// дарить (или, если ByReceivers, получать подарки) только от Partners, которых меньше.
+// DropRestrictions - минимальный набор ограничений, после снятия которых распределение возможно.
+type InfeasibilityError struct {
+	ByReceivers      bool
+	People           []int64
+	Partners         []int64
+	DropRestrictions []Restriction
+}
+
+func (e *InfeasibilityError) Error() string {
+	side := "givers"
+	if e.ByReceivers {
+		side = "receivers"
+	}
+	return fmt.Sprintf("%v: %d %s have only %d possible partners", ErrNoValidAssignment, len(e.People), side, len(e.Partners))
+}
+
+func (e *InfeasibilityError) Unwrap() error {
+	return ErrNoValidAssignment
+}
+
+// assignmentSolver ищет распределение для участников ids. stepLimit ограничивает
+// переборные поиски (searchStepLimit; тесты уменьшают его, чтобы проверить поведение
+// при исчерпании лимита).
+type assignmentSolver struct {
+	ids        []int64
+	allowed    [][]bool
+	restricted [][]bool
+	penalty    [][]int
+	rng        *rand.Rand
+	stepLimit  int
+}
+
+func newAssignmentSolver(participantIDs []int64, restrictions map[int64]map[int64]bool) *assignmentSolver {
+	n := len(participantIDs)
+	allowed := make([][]bool, n)
+	restricted := make([][]bool, n)
+	for i, giverID := range participantIDs {
+		allowed[i] = make([]bool, n)
+		restricted[i] = make([]bool, n)
+		for j, receiverID := range participantIDs {
+			if i == j {
+				continue
+			}
+			if userRestrictions, ok := restrictions[giverID]; ok && userRestrictions[receiverID] {
+				restricted[i][j] = true
+				continue
+			}
+			allowed[i][j] = true
+		}
+	}
+
+	return &assignmentSolver{
+		ids:        participantIDs,
+		allowed:    allowed,
+		restricted: restricted,
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		stepLimit:  searchStepLimit,
+	}
+}
+
+// setSeed делает распределение воспроизводимым: при одном и том же зерне, составе
+// и ограничениях решатель выдает одно и то же распределение.
+func (s *assignmentSolver) setSeed(seed int64) {
+	s.rng = rand.New(rand.NewSource(seed))
+}
+
+// setPenalties задает мягкие ограничения: штраф за каждую пару даритель → получатель.
+// Распределение по возможности строится без штрафов, иначе с наименьшим суммарным штрафом.
+func (s *assignmentSolver) setPenalties(penalties map[int64]map[int64]int) {
+	n := len(s.ids)
+	s.penalty = nil
+	for i, giverID := range s.ids {
+		for j, receiverID := range s.ids {
+			value := penalties[giverID][receiverID]
+			if value <= 0 {
+				continue
+			}
+			if s.penalty == nil {
+				s.penalty = make([][]int, n)
+				for row := range s.penalty {
+					s.penalty[row] = make([]int, n)
+				}
+			}
+			s.penalty[i][j] = value
+		}
+	}
+}
+
+func (s *assignmentSolver) penaltyOf(receiverOf []int) int {
+	if s.penalty == nil {
+		return 0
+	}
+	total := 0
+	for giver, receiver := range receiverOf {
+		total += s.penalty[giver][receiver]
+	}
+	return total
+}
+
+func (s *assignmentSolver) penaltyFree() *assignmentSolver {
+	n := len(s.ids)
+	allowed := make([][]bool, n)
+	for giver := range allowed {
+		allowed[giver] = make([]bool, n)
+		for receiver, ok := range s.allowed[giver] {
+			allowed[giver][receiver] = ok && s.penalty[giver][receiver] == 0
+		}
+	}
+	return &assignmentSolver{
+		ids:        s.ids,
+		allowed:    allowed,
+		restricted: s.restricted,
+		rng:        s.rng,
+		stepLimit:  s.stepLimit,
+	}
+}
+
+// minPenaltyMatching ищет распределение с наименьшим суммарным штрафом. Небольшой
+// случайный шум в стоимостях не влияет на штраф, но делает выбор среди равных случайным.
+func (s *assignmentSolver) minPenaltyMatching() []int {
+	n := len(s.ids)
+	scale := n * n
+	maxPenalty := 0
+	for _, row := range s.penalty {
+		for _, value := range row {
+			if value > maxPenalty {
+				maxPenalty = value
+			}
+		}
+	}
+	impossible := (n*maxPenalty + 2) * scale
+
+	cost := make([][]int, n)
+	for giver := range cost {
+		cost[giver] = make([]int, n)
+		for receiver := range cost[giver] {
+			if !s.allowed[giver][receiver] {
+				cost[giver][receiver] = impossible
+				continue
+			}
+			cost[giver][receiver] = s.penalty[giver][receiver]*scale + s.rng.Intn(n)
+		}
+	}
+	return minCostAssignment(cost)
+}
+
+// maxMatching ищет максимальное паросочетание дарителей и получателей алгоритмом Куна.
+// Порядок обхода перемешивается, поэтому каждое найденное паросочетание случайно.
+// Возвращает для каждого дарителя индекс получателя (-1, если получателя нет).
+func maxMatching(rng *rand.Rand, allowed [][]bool) ([]int, int) {
+	n := len(allowed)
+	order := rng.Perm(n)
+	adjacency := make([][]int, n)
+	for giver := range adjacency {
+		for receiver, ok := range allowed[giver] {
+			if ok {
+				adjacency[giver] = append(adjacency[giver], receiver)
+			}
+		}
+		rng.Shuffle(len(adjacency[giver]), func(i, j int) {
+			adjacency[giver][i], adjacency[giver][j] = adjacency[giver][j], adjacency[giver][i]
+		})
+	}
+
+	giverOf := make([]int, n)
+	receiverOf := make([]int, n)
+	for i := range giverOf {
+		giverOf[i] = -1
+		receiverOf[i] = -1
+	}
+
+	var visited []bool
+	var augment func(giver int) bool
+	augment = func(giver int) bool {
+		for _, receiver := range adjacency[giver] {
+			if visited[receiver] {
+				continue
+			}
+			visited[receiver] = true
+			if giverOf[receiver] == -1 || augment(giverOf[receiver]) {
+				giverOf[receiver] = giver
+				receiverOf[giver] = receiver
+				return true
+			}
+		}
+		return false
+	}
+
+	size := 0
+	for _, giver := range order {
+		visited = make([]bool, n)
+		if augment(giver) {
+			size++
+		}
+	}
+
+	return receiverOf, size
+}
+
+// hallViolation строит по максимальному паросочетанию наименьшее найденное множество
+// дарителей, которым доступно меньше получателей, чем их самих (нарушение условия Холла).
+func hallViolation(allowed [][]bool, receiverOf []int) (people, partners []int) {
+	n := len(allowed)
+	giverOf := make([]int, n)
+	for i := range giverOf {
+		giverOf[i] = -1
+	}
+	for giver, receiver := range receiverOf {
+		if receiver != -1 {
+			giverOf[receiver] = giver
+		}
+	}
+
+	for start, receiver := range receiverOf {
+		if receiver != -1 {
+			continue
+		}
+
+		seenGivers := make([]bool, n)
+		seenReceivers := make([]bool, n)
+		seenGivers[start] = true
+		queue := []int{start}
+		var reachedGivers, reachedReceivers []int
+		for len(queue) > 0 {
+			giver := queue[0]
+			queue = queue[1:]
+			reachedGivers = append(reachedGivers, giver)
+			for receiver, ok := range allowed[giver] {
+				if !ok || seenReceivers[receiver] {
+					continue
+				}
+				seenReceivers[receiver] = true
+				reachedReceivers = append(reachedReceivers, receiver)
+				if next := giverOf[receiver]; next != -1 && !seenGivers[next] {
+					seenGivers[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+
+		if people == nil || len(reachedGivers) < len(people) {
+			people, partners = reachedGivers, reachedReceivers
+		}
+	}
+
+	return people, partners
+}
+
+// minCostAssignment решает задачу о назначениях венгерским алгоритмом и возвращает
+// для каждой строки матрицы стоимостей индекс выбранного столбца.
+func minCostAssignment(cost [][]int) []int {
+	n := len(cost)
+	const inf = int(^uint(0) >> 2)
+	u := make([]int, n+1)
+	v := make([]int, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+
+	for row := 1; row <= n; row++ {
+		p[0] = row
+		col0 := 0
+		minv := make([]int, n+1)
+		used := make([]bool, n+1)
+		for i := range minv {
+			minv[i] = inf
+		}
+		for {
+			used[col0] = true
+			row0 := p[col0]
+			delta := inf
+			col1 := 0
+			for col := 1; col <= n; col++ {
+				if used[col] {
+					continue
+				}
+				cur := cost[row0-1][col-1] - u[row0] - v[col]
+				if cur < minv[col] {
+					minv[col] = cur
+					way[col] = col0
+				}
+				if minv[col] < delta {
+					delta = minv[col]
+					col1 = col
+				}
+			}
+			for col := 0; col <= n; col++ {
+				if used[col] {
+					u[p[col]] += delta
+					v[col] -= delta
+				} else {
+					minv[col] -= delta
+				}
+			}
+			col0 = col1
+			if p[col0] == 0 {
+				break
+			}
+		}
+		for col0 != 0 {
+			col1 := way[col0]
+			p[col0] = p[col1]
+			col0 = col1
+		}
+	}
+
+	result := make([]int, n)
+	for col := 1; col <= n; col++ {
+		if p[col] != 0 {
+			result[p[col]-1] = col - 1
+		}
+	}
+	return result
+}
+
+// shuffleMatching перемешивает готовое распределение случайными обменами получателей
+// между двумя и тремя дарителями, сохраняя все ограничения. При noMutual обмены,
+// создающие взаимные пары, отклоняются.
+func (s *assignmentSolver) shuffleMatching(receiverOf []int, noMutual bool) {
+	n := len(receiverOf)
+	if n < 3 {
+		return
+	}
+
+	createsMutual := func(givers ...int) bool {
+		if !noMutual {
+			return false
+		}
+		for _, giver := range givers {
+			if receiverOf[receiverOf[giver]] == giver {
+				return true
+			}
+		}
+		return false
+	}
+
+	penaltyOf := func(givers ...int) int {
+		if s.penalty == nil {
+			return 0
+		}
+		total := 0
+		for _, giver := range givers {
+			total += s.penalty[giver][receiverOf[giver]]
+		}
+		return total
+	}
+
+	steps := 10*n*n + 100
+	for step := 0; step < steps; step++ {
+		a, b := s.rng.Intn(n), s.rng.Intn(n)
+		if a == b {
+			continue
+		}
+		if s.rng.Intn(2) == 0 {
+			if s.allowed[a][receiverOf[b]] && s.allowed[b][receiverOf[a]] {
+				before := penaltyOf(a, b)
+				receiverOf[a], receiverOf[b] = receiverOf[b], receiverOf[a]
+				if createsMutual(a, b) || penaltyOf(a, b) > before {
+					receiverOf[a], receiverOf[b] = receiverOf[b], receiverOf[a]
+				}
+			}
+			continue
+		}
+
+		c := s.rng.Intn(n)
+		if c == a || c == b {
+			continue
+		}
+		if s.allowed[a][receiverOf[b]] && s.allowed[b][receiverOf[c]] && s.allowed[c][receiverOf[a]] {
+			before := penaltyOf(a, b, c)
+			receiverOf[a], receiverOf[b], receiverOf[c] = receiverOf[b], receiverOf[c], receiverOf[a]
+			if createsMutual(a, b, c) || penaltyOf(a, b, c) > before {
+				receiverOf[a], receiverOf[b], receiverOf[c] = receiverOf[c], receiverOf[a], receiverOf[b]
+			}
+		}
+	}
+}
+
+// solve возвращает распределение и его суммарный штраф по мягким ограничениям.
+func (s *assignmentSolver) solve(mode domain.AssignmentMode) (map[int64]int64, int, error) {
+	receiverOf, size := maxMatching(s.rng, s.allowed)
+	if size < len(s.ids) {
+		return nil, 0, s.infeasibility(receiverOf)
+	}
+
+	if s.penalty != nil {
+		strict := s.penaltyFree()
+		if strictReceiverOf, size := maxMatching(s.rng, strict.allowed); size == len(s.ids) {
+			if arranged, err := strict.arrange(mode, strictReceiverOf); err == nil {
+				return s.assignments(arranged), 0, nil
+			}
+		}
+		receiverOf = s.minPenaltyMatching()
+	}
+
+	receiverOf, err := s.arrange(mode, receiverOf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.assignments(receiverOf), s.penaltyOf(receiverOf), nil
+}
+
+func (s *assignmentSolver) arrange(mode domain.AssignmentMode, receiverOf []int) ([]int, error) {
+	switch mode {
+	case domain.AssignmentModeChain:
+		if s.penalty != nil {
+			return s.minPenaltyCycle()
+		}
+		return s.hamiltonianCycle()
+	case domain.AssignmentModeNoMutual:
+		receiverOf, err := s.removeMutualPairs(receiverOf)
+		if err != nil {
+			return nil, err
+		}
+		s.improveMatching(receiverOf, true)
+		s.shuffleMatching(receiverOf, true)
+		return receiverOf, nil
+	default:
+		s.shuffleMatching(receiverOf, false)
+		return receiverOf, nil
+	}
+}
+
+func (s *assignmentSolver) assignments(receiverOf []int) map[int64]int64 {
+	assignments := make(map[int64]int64, len(s.ids))
+	for giver, receiver := range receiverOf {
+		assignments[s.ids[giver]] = s.ids[receiver]
+	}
+	return assignments
+}
+
+// removeMutualPairs разбивает взаимные пары (A→B, B→A), сливая каждую с другим циклом
+// распределения; из возможных слияний выбирается то, что меньше всего увеличивает штраф.
+// Если слить пару не удается, выполняется полный перебор.
+func (s *assignmentSolver) removeMutualPairs(receiverOf []int) ([]int, error) {
+	n := len(receiverOf)
+	if n < 3 {
+		return nil, ErrModeUnsatisfiable
+	}
+
+	for giver := range receiverOf {
+		partner := receiverOf[giver]
+		if receiverOf[partner] != giver {
+			continue
+		}
+
+		bestA, bestOther, bestCost := -1, -1, 0
+		for _, other := range s.rng.Perm(n) {
+			if other == giver || other == partner {
+				continue
+			}
+			for _, a := range []int{giver, partner} {
+				b, c := receiverOf[a], receiverOf[other]
+				if !s.allowed[a][c] || !s.allowed[other][b] {
+					continue
+				}
+				cost := 0
+				if s.penalty != nil {
+					cost = s.penalty[a][c] + s.penalty[other][b] - s.penalty[a][b] - s.penalty[other][c]
+				}
+				if bestA == -1 || cost < bestCost {
+					bestA, bestOther, bestCost = a, other, cost
+				}
+			}
+		}
+		if bestA == -1 {
+			return s.searchCycleCover(3)
+		}
+		receiverOf[bestA], receiverOf[bestOther] = receiverOf[bestOther], receiverOf[bestA]
+	}
+
+	return receiverOf, nil
+}
+
+// improveMatching обменивает получателей у пар дарителей, пока это уменьшает суммарный
+// штраф, не нарушая ограничений (и, при noMutual, не создавая взаимных пар).
+func (s *assignmentSolver) improveMatching(receiverOf []int, noMutual bool) {
+	if s.penalty == nil {
+		return
+	}
+	n := len(receiverOf)
+	for improved := true; improved; {
+		improved = false
+		for _, a := range s.rng.Perm(n) {
+			for _, b := range s.rng.Perm(n) {
+				ra, rb := receiverOf[a], receiverOf[b]
+				if a == b || !s.allowed[a][rb] || !s.allowed[b][ra] {
+					continue
+				}
+				if s.penalty[a][rb]+s.penalty[b][ra] >= s.penalty[a][ra]+s.penalty[b][rb] {
+					continue
+				}
+				receiverOf[a], receiverOf[b] = rb, ra
+				if noMutual && (receiverOf[rb] == a || receiverOf[ra] == b) {
+					receiverOf[a], receiverOf[b] = ra, rb
+					continue
+				}
+				improved = true
+			}
+		}
+	}
+}
+
+// searchCycleCover перебором ищет распределение, все циклы которого не короче minCycle.
+func (s *assignmentSolver) searchCycleCover(minCycle int) ([]int, error) {
+	n := len(s.ids)
+	receiverOf := make([]int, n)
+	giverOf := make([]int, n)
+	for i := range receiverOf {
+		receiverOf[i] = -1
+		giverOf[i] = -1
+	}
+
+	order := s.rng.Perm(n)
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(s.candidates(order[i])) < len(s.candidates(order[j]))
+	})
+
+	closesShortCycle := func(giver, receiver int) bool {
+		length := 1
+		for current := receiver; current != giver; current = receiverOf[current] {
+			if current == -1 {
+				return false
+			}
+			length++
+			if length >= minCycle {
+				return false
+			}
+		}
+		return true
+	}
+
+	steps := 0
+	var place func(index int) bool
+	place = func(index int) bool {
+		if index == n {
+			return true
+		}
+		steps++
+		if steps > s.stepLimit {
+			return false
+		}
+
+		giver := order[index]
+		candidates := s.candidates(giver)
+		s.rng.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		for _, receiver := range candidates {
+			if giverOf[receiver] != -1 || closesShortCycle(giver, receiver) {
+				continue
+			}
+			receiverOf[giver] = receiver
+			giverOf[receiver] = giver
+			if place(index + 1) {
+				return true
+			}
+			receiverOf[giver] = -1
+			giverOf[receiver] = -1
+		}
+		return false
+	}
+
+	if place(0) {
+		return receiverOf, nil
+	}
+	if steps > s.stepLimit {
+		return nil, ErrSearchLimit
+	}
+	return nil, ErrModeUnsatisfiable
+}
+
+func (s *assignmentSolver) candidates(giver int) []int {
+	result := make([]int, 0, len(s.ids))
+	for receiver, ok := range s.allowed[giver] {
+		if ok {
+			result = append(result, receiver)
+		}
+	}
+	return result
+}
+
+// hamiltonianCycle ищет распределение в виде одного общего цикла. Для небольших игр
+// используется точное динамическое программирование по подмножествам, для больших -
+// перебор с отсечениями и ограничением числа шагов.
+func (s *assignmentSolver) hamiltonianCycle() ([]int, error) {
+	if len(s.ids) <= exactCycleMaxNodes {
+		return s.exactHamiltonianCycle()
+	}
+	return s.searchHamiltonianCycle()
+}
+
+// minPenaltyCycle ищет общий круг с наименьшим суммарным штрафом. Для небольших игр
+// круг находится точно, для больших найденный круг улучшается переносами участников.
+func (s *assignmentSolver) minPenaltyCycle() ([]int, error) {
+	if len(s.ids) <= exactCycleMaxNodes {
+		return s.exactMinPenaltyCycle()
+	}
+	receiverOf, err := s.searchHamiltonianCycle()
+	if err != nil {
+		return nil, err
+	}
+	s.improveCycle(receiverOf)
+	return receiverOf, nil
+}
+
+// exactMinPenaltyCycle - динамика по подмножествам: best[mask*n+last] - наименьший штраф
+// пути из участника 0 через mask, который заканчивается на last. Среди кругов с
+// одинаковым штрафом выбирается случайный.
+func (s *assignmentSolver) exactMinPenaltyCycle() ([]int, error) {
+	n := len(s.ids)
+	const inf = int32(1<<31 - 1)
+	full := 1<<n - 1
+	best := make([]int32, (full+1)*n)
+	for i := range best {
+		best[i] = inf
+	}
+	best[1*n+0] = 0
+
+	for mask := 1; mask <= full; mask += 2 {
+		for last := 0; last < n; last++ {
+			cost := best[mask*n+last]
+			if cost == inf {
+				continue
+			}
+			for next := 0; next < n; next++ {
+				if mask&(1<<next) != 0 || !s.allowed[last][next] {
+					continue
+				}
+				nextCost := cost + int32(s.penalty[last][next])
+				if index := (mask|1<<next)*n + next; nextCost < best[index] {
+					best[index] = nextCost
+				}
+			}
+		}
+	}
+
+	bestTotal := inf
+	var closing []int
+	for last := 1; last < n; last++ {
+		if best[full*n+last] == inf || !s.allowed[last][0] {
+			continue
+		}
+		total := best[full*n+last] + int32(s.penalty[last][0])
+		if total < bestTotal {
+			bestTotal, closing = total, nil
+		}
+		if total == bestTotal {
+			closing = append(closing, last)
+		}
+	}
+	if len(closing) == 0 {
+		return nil, ErrModeUnsatisfiable
+	}
+
+	receiverOf := make([]int, n)
+	current := closing[s.rng.Intn(len(closing))]
+	receiverOf[current] = 0
+	mask := full
+	for current != 0 {
+		rest := mask &^ (1 << current)
+		var previous []int
+		for candidate := 0; candidate < n; candidate++ {
+			if rest&(1<<candidate) == 0 || !s.allowed[candidate][current] || best[rest*n+candidate] == inf {
+				continue
+			}
+			if best[rest*n+candidate]+int32(s.penalty[candidate][current]) == best[mask*n+current] {
+				previous = append(previous, candidate)
+			}
+		}
+		giver := previous[s.rng.Intn(len(previous))]
+		receiverOf[giver] = current
+		current, mask = giver, rest
+	}
+
+	return receiverOf, nil
+}
+
+// improveCycle переносит участников на другое место круга (p → x → q, a → b превращается
+// в p → q, a → x → b), пока это уменьшает суммарный штраф. Круг остается единым.
+func (s *assignmentSolver) improveCycle(receiverOf []int) {
+	n := len(receiverOf)
+	if n < 4 {
+		return
+	}
+	giverOf := make([]int, n)
+	for improved := true; improved; {
+		improved = false
+		for giver, receiver := range receiverOf {
+			giverOf[receiver] = giver
+		}
+		for _, x := range s.rng.Perm(n) {
+			p, q := giverOf[x], receiverOf[x]
+			if !s.allowed[p][q] {
+				continue
+			}
+			removed := s.penalty[p][x] + s.penalty[x][q] - s.penalty[p][q]
+			for _, a := range s.rng.Perm(n) {
+				b := receiverOf[a]
+				if a == x || b == x || !s.allowed[a][x] || !s.allowed[x][b] {
+					continue
+				}
+				if s.penalty[a][x]+s.penalty[x][b]-s.penalty[a][b] >= removed {
+					continue
+				}
+				receiverOf[p], receiverOf[a], receiverOf[x] = q, x, b
+				improved = true
+				break
+			}
+			if improved {
+				break
+			}
+		}
+	}
+}
+
+func (s *assignmentSolver) exactHamiltonianCycle() ([]int, error) {
+	n := len(s.ids)
+	out := make([]uint32, n)
+	for giver := range out {
+		for receiver, ok := range s.allowed[giver] {
+			if ok {
+				out[giver] |= 1 << receiver
+			}
+		}
+	}
+
+	full := uint32(1)<<n - 1
+	ends := make([]uint32, full+1)
+	ends[1] = 1
+	for mask := uint32(1); mask <= full; mask += 2 {
+		for rest := ends[mask]; rest != 0; rest &= rest - 1 {
+			last := bits.TrailingZeros32(rest)
+			for next := out[last] &^ mask; next != 0; next &= next - 1 {
+				receiver := bits.TrailingZeros32(next)
+				ends[mask|1<<receiver] |= 1 << receiver
+			}
+		}
+	}
+
+	var closing []int
+	for rest := ends[full]; rest != 0; rest &= rest - 1 {
+		last := bits.TrailingZeros32(rest)
+		if out[last]&1 != 0 {
+			closing = append(closing, last)
+		}
+	}
+	if len(closing) == 0 {
+		return nil, ErrModeUnsatisfiable
+	}
+
+	receiverOf := make([]int, n)
+	current := closing[s.rng.Intn(len(closing))]
+	receiverOf[current] = 0
+	mask := full
+	for current != 0 {
+		mask &^= 1 << current
+		var previous []int
+		for rest := ends[mask]; rest != 0; rest &= rest - 1 {
+			candidate := bits.TrailingZeros32(rest)
+			if out[candidate]&(1<<current) != 0 {
+				previous = append(previous, candidate)
+			}
+		}
+		giver := previous[s.rng.Intn(len(previous))]
+		receiverOf[giver] = current
+		current = giver
+	}
+
+	return receiverOf, nil
+}
+
+func (s *assignmentSolver) searchHamiltonianCycle() ([]int, error) {
+	n := len(s.ids)
+	start := s.rng.Intn(n)
+	receiverOf := make([]int, n)
+	visited := make([]bool, n)
+	visited[start] = true
+
+	reachable := func(current int) bool {
+		for vertex := 0; vertex < n; vertex++ {
+			if visited[vertex] {
+				continue
+			}
+			hasIn, hasOut := false, false
+			for other := 0; other < n && !(hasIn && hasOut); other++ {
+				if s.allowed[other][vertex] && (other == current || !visited[other]) {
+					hasIn = true
+				}
+				if s.allowed[vertex][other] && (other == start || !visited[other]) {
+					hasOut = true
+				}
+			}
+			if !hasIn || !hasOut {
+				return false
+			}
+		}
+		return true
+	}
+
+	steps := 0
+	var extend func(current, length int) bool
+	extend = func(current, length int) bool {
+		if length == n {
+			if s.allowed[current][start] {
+				receiverOf[current] = start
+				return true
+			}
+			return false
+		}
+		steps++
+		if steps > s.stepLimit {
+			return false
+		}
+
+		candidates := make([]int, 0, n)
+		for _, next := range s.candidates(current) {
+			if !visited[next] {
+				candidates = append(candidates, next)
+			}
+		}
+		s.rng.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+
+		for _, next := range candidates {
+			visited[next] = true
+			if reachable(next) {
+				receiverOf[current] = next
+				if extend(next, length+1) {
+					return true
+				}
+			}
+			visited[next] = false
+		}
+		return false
+	}
+
+	if extend(start, 1) {
+		return receiverOf, nil
+	}
+	if steps > s.stepLimit {
+		return nil, ErrSearchLimit
+	}
+	return nil, ErrModeUnsatisfiable
+}
+
+func (s *assignmentSolver) infeasibility(receiverOf []int) *InfeasibilityError {
+	n := len(s.ids)
+	result := &InfeasibilityError{}
+
+	givers, receivers := hallViolation(s.allowed, receiverOf)
+
+	transposed := make([][]bool, n)
+	for i := range transposed {
+		transposed[i] = make([]bool, n)
+		for j := range transposed[i] {
+			transposed[i][j] = s.allowed[j][i]
+		}
+	}
+	giverOf, _ := maxMatching(s.rng, transposed)
+	receiverSide, giverSide := hallViolation(transposed, giverOf)
+
+	if givers == nil || (receiverSide != nil && len(receiverSide) < len(givers)) {
+		result.ByReceivers = true
+		givers, receivers = receiverSide, giverSide
+	}
+	for _, i := range givers {
+		result.People = append(result.People, s.ids[i])
+	}
+	for _, i := range receivers {
+		result.Partners = append(result.Partners, s.ids[i])
+	}
+
+	result.DropRestrictions = s.minimalRelaxation()
+	return result
+}
+
+// minimalRelaxation находит наименьшее число ограничений, без которых распределение существует.
+func (s *assignmentSolver) minimalRelaxation() []Restriction {
+	n := len(s.ids)
+	if n < 2 {
+		return nil
+	}
+
+	impossible := n + 1
+	cost := make([][]int, n)
+	for i := range cost {
+		cost[i] = make([]int, n)
+		for j := range cost[i] {
+			switch {
+			case i == j:
+				cost[i][j] = impossible
+			case s.restricted[i][j]:
+				cost[i][j] = 1
+			}
+		}
+	}
+
+	var drop []Restriction
+	for giver, receiver := range minCostAssignment(cost) {
+		if s.restricted[giver][receiver] {
+			drop = append(drop, Restriction{UserID: s.ids[giver], ForbiddenUserID: s.ids[receiver]})
+		}
+	}
+	return drop
+}
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"telegram-secret-santa/internal/domain"
+)
+
+// randomPenaltySolver - решатель на n участников со случайными запретами и штрафами.
+func randomPenaltySolver(rng *rand.Rand, n int) *assignmentSolver {
+	forbidden := make(map[int64][]int64)
+	penalties := make(map[int64]map[int64]int)
+	for giver := 0; giver < n; giver++ {
+		penalties[int64(giver)] = make(map[int64]int)
+		for receiver := 0; receiver < n; receiver++ {
+			if giver == receiver {
+				continue
+			}
+			switch rng.Intn(6) {
+			case 0:
+				forbidden[int64(giver)] = append(forbidden[int64(giver)], int64(receiver))
+			case 1, 2, 3:
+				penalties[int64(giver)][int64(receiver)] = 1 + rng.Intn(3)
+			}
+		}
+	}
+	s := testSolver(n, forbidden)
+	s.setPenalties(penalties)
+	s.setSeed(rng.Int63())
+	return s
+}
+
+// bruteForceMinPenalty перебирает все допустимые распределения, для которых accept
+// возвращает true, и находит наименьший штраф (-1, если распределений нет).
+func bruteForceMinPenalty(s *assignmentSolver, accept func(receiverOf []int) bool) int {
+	n := len(s.ids)
+	best := -1
+	receiverOf := make([]int, n)
+	used := make([]bool, n)
+	var place func(giver int)
+	place = func(giver int) {
+		if giver == n {
+			if accept(receiverOf) {
+				if penalty := s.penaltyOf(receiverOf); best == -1 || penalty < best {
+					best = penalty
+				}
+			}
+			return
+		}
+		for receiver := 0; receiver < n; receiver++ {
+			if !used[receiver] && s.allowed[giver][receiver] {
+				used[receiver] = true
+				receiverOf[giver] = receiver
+				place(giver + 1)
+				used[receiver] = false
+			}
+		}
+	}
+	place(0)
+	return best
+}
+
+func hasMutualPair(receiverOf []int) bool {
+	for giver, receiver := range receiverOf {
+		if receiverOf[receiver] == giver {
+			return true
+		}
+	}
+	return false
+}
+
+func TestChainModeMinimizesPenalty(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	for trial := 0; trial < 100; trial++ {
+		s := randomPenaltySolver(rng, 3+rng.Intn(5))
+		want := bruteForceMinPenalty(s, isSingleCycle)
+		if want == -1 {
+			continue
+		}
+
+		assignments, penalty, err := s.solve(domain.AssignmentModeChain)
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		receiverOf := make([]int, len(s.ids))
+		for giver, receiver := range assignments {
+			receiverOf[giver] = int(receiver)
+		}
+		checkAllowed(t, s, receiverOf)
+		if !isSingleCycle(receiverOf) {
+			t.Fatalf("trial %d: %v is not a single cycle", trial, receiverOf)
+		}
+		if penalty != want || s.penaltyOf(receiverOf) != want {
+			t.Fatalf("trial %d: penalty %d, the best chain has %d", trial, penalty, want)
+		}
+	}
+}
+
+func TestImproveCycleKeepsSingleCycleAndLowersPenalty(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	for trial := 0; trial < 100; trial++ {
+		s := randomPenaltySolver(rng, 6+rng.Intn(6))
+		receiverOf, err := s.hamiltonianCycle()
+		if err != nil {
+			continue
+		}
+		before := s.penaltyOf(receiverOf)
+		s.improveCycle(receiverOf)
+		checkAllowed(t, s, receiverOf)
+		if !isSingleCycle(receiverOf) {
+			t.Fatalf("trial %d: %v is not a single cycle", trial, receiverOf)
+		}
+		if after := s.penaltyOf(receiverOf); after > before {
+			t.Fatalf("trial %d: penalty grew from %d to %d", trial, before, after)
+		}
+	}
+}
+
+func TestNoMutualModeKeepsPenaltyLow(t *testing.T) {
+	// Наименьший штраф у распределения 0 ↔ 1, 2 ↔ 3; разбить взаимные пары
+	// можно без штрафа (0 → 1 → 2 → 3 → 0), но и со штрафом (0 → 2 → 1 → 3 → 0).
+	s := testSolver(4, nil)
+	s.setPenalties(map[int64]map[int64]int{
+		0: {2: 5, 3: 5},
+		1: {0: 1, 3: 5},
+		2: {0: 5, 1: 5},
+		3: {1: 5, 2: 1},
+	})
+	for seed := int64(0); seed < 20; seed++ {
+		s.setSeed(seed)
+		assignments, penalty, err := s.solve(domain.AssignmentModeNoMutual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if penalty != 0 {
+			t.Fatalf("seed %d: penalty %d for %v, a penalty-free chain exists", seed, penalty, assignments)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for trial := 0; trial < 100; trial++ {
+		s := randomPenaltySolver(rng, 3+rng.Intn(5))
+		want := bruteForceMinPenalty(s, func(receiverOf []int) bool { return !hasMutualPair(receiverOf) })
+		assignments, penalty, err := s.solve(domain.AssignmentModeNoMutual)
+		if want == -1 {
+			if err == nil {
+				t.Fatalf("trial %d: got %v, but no assignment without mutual pairs exists", trial, assignments)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		receiverOf := make([]int, len(s.ids))
+		for giver, receiver := range assignments {
+			receiverOf[giver] = int(receiver)
+		}
+		checkAllowed(t, s, receiverOf)
+		if hasMutualPair(receiverOf) {
+			t.Fatalf("trial %d: mutual pair in %v", trial, receiverOf)
+		}
+		if penalty != want {
+			t.Fatalf("trial %d: penalty %d, the best assignment has %d", trial, penalty, want)
+		}
+	}
+}
+
+// receiversOf переводит распределение решателя testSolver обратно в receiverOf.
+func receiversOf(assignments map[int64]int64) []int {
+	receiverOf := make([]int, len(assignments))
+	for giver, receiver := range assignments {
+		receiverOf[giver] = int(receiver)
+	}
+	return receiverOf
+}
+
+func TestInfeasibilityCertificate(t *testing.T) {
+	tests := []struct {
+		name         string
+		n            int
+		forbidden    map[int64][]int64
+		byReceivers  bool
+		people       []int64 // People - непустое подмножество people
+		partners     []int64
+		minimumDrops int
+	}{
+		{
+			name:         "three givers share one receiver",
+			n:            4,
+			forbidden:    map[int64][]int64{0: {1, 2}, 1: {0, 2}, 2: {0, 1}},
+			people:       []int64{0, 1, 2},
+			partners:     []int64{3},
+			minimumDrops: 2,
+		},
+		{
+			name:         "two receivers share one giver",
+			n:            4,
+			forbidden:    map[int64][]int64{1: {2, 3}, 2: {3}, 3: {2}},
+			byReceivers:  true,
+			people:       []int64{2, 3},
+			partners:     []int64{0},
+			minimumDrops: 1,
+		},
+		{
+			name:         "nobody may give to one participant",
+			n:            3,
+			forbidden:    map[int64][]int64{0: {2}, 1: {2}},
+			byReceivers:  true,
+			people:       []int64{2},
+			partners:     nil,
+			minimumDrops: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSolver(tt.n, tt.forbidden)
+			_, _, err := s.solve(domain.AssignmentModeAny)
+			var infeasible *InfeasibilityError
+			if !errors.As(err, &infeasible) || !errors.Is(err, ErrNoValidAssignment) {
+				t.Fatalf("err = %v, want an InfeasibilityError", err)
+			}
+			if infeasible.ByReceivers != tt.byReceivers || len(infeasible.People) <= len(infeasible.Partners) ||
+				!reflect.DeepEqual(sortedIDs(infeasible.Partners), tt.partners) {
+				t.Fatalf("certificate = %+v, want people from %v limited to %v", infeasible, tt.people, tt.partners)
+			}
+			for _, id := range infeasible.People {
+				found := false
+				for _, want := range tt.people {
+					found = found || id == want
+				}
+				if !found {
+					t.Fatalf("People = %v, want a subset of %v", infeasible.People, tt.people)
+				}
+			}
+
+			// Сертификат должен быть честным: у каждого из People все возможные
+			// партнеры входят в Partners.
+			partners := make(map[int]bool)
+			for _, id := range infeasible.Partners {
+				partners[int(id)] = true
+			}
+			for _, id := range infeasible.People {
+				for other := 0; other < tt.n; other++ {
+					ok := s.allowed[id][other]
+					if infeasible.ByReceivers {
+						ok = s.allowed[other][id]
+					}
+					if ok && !partners[other] {
+						t.Fatalf("%d can be paired with %d, which is not among Partners %v", id, other, infeasible.Partners)
+					}
+				}
+			}
+
+			if len(infeasible.DropRestrictions) != tt.minimumDrops {
+				t.Fatalf("DropRestrictions = %v, want %d restrictions", infeasible.DropRestrictions, tt.minimumDrops)
+			}
+			relaxed := make(map[int64][]int64)
+			for giver, receivers := range tt.forbidden {
+				for _, receiver := range receivers {
+					drop := false
+					for _, r := range infeasible.DropRestrictions {
+						drop = drop || (r.UserID == giver && r.ForbiddenUserID == receiver)
+					}
+					if !drop {
+						relaxed[giver] = append(relaxed[giver], receiver)
+					}
+				}
+			}
+			if _, _, err := testSolver(tt.n, relaxed).solve(domain.AssignmentModeAny); err != nil {
+				t.Fatalf("still infeasible after dropping %v: %v", infeasible.DropRestrictions, err)
+			}
+		})
+	}
+}
+
+func TestModesOnSmallGames(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      domain.AssignmentMode
+		n         int
+		forbidden map[int64][]int64
+		want      [][]int // допустимые результаты; nil - ожидается wantErr
+		wantErr   error
+	}{
+		{
+			name: "chain of two is a single swap",
+			mode: domain.AssignmentModeChain,
+			n:    2,
+			want: [][]int{{1, 0}},
+		},
+		{
+			name:      "chain of two with a restriction",
+			mode:      domain.AssignmentModeChain,
+			n:         2,
+			forbidden: map[int64][]int64{1: {0}},
+			wantErr:   ErrNoValidAssignment,
+		},
+		{
+			name:    "chain of one",
+			mode:    domain.AssignmentModeChain,
+			n:       1,
+			wantErr: ErrNoValidAssignment,
+		},
+		{
+			name:    "nomutual of two",
+			mode:    domain.AssignmentModeNoMutual,
+			n:       2,
+			wantErr: ErrModeUnsatisfiable,
+		},
+		{
+			name: "nomutual of three has two cycles",
+			mode: domain.AssignmentModeNoMutual,
+			n:    3,
+			want: [][]int{{1, 2, 0}, {2, 0, 1}},
+		},
+		{
+			name:      "nomutual of three with one cycle restricted",
+			mode:      domain.AssignmentModeNoMutual,
+			n:         3,
+			forbidden: map[int64][]int64{0: {1}},
+			want:      [][]int{{2, 0, 1}},
+		},
+		{
+			name: "nomutual of four with only two swaps allowed",
+			mode: domain.AssignmentModeNoMutual,
+			n:    4,
+			forbidden: map[int64][]int64{
+				0: {2, 3}, 1: {2, 3}, 2: {0, 1}, 3: {0, 1},
+			},
+			wantErr: ErrModeUnsatisfiable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for seed := int64(0); seed < 20; seed++ {
+				s := testSolver(tt.n, tt.forbidden)
+				s.setSeed(seed)
+				assignments, _, err := s.solve(tt.mode)
+				if tt.wantErr != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("err = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := receiversOf(assignments)
+				found := false
+				for _, want := range tt.want {
+					found = found || reflect.DeepEqual(got, want)
+				}
+				if !found {
+					t.Fatalf("seed %d: got %v, want one of %v", seed, got, tt.want)
+				}
+				seen[fmt.Sprint(got)] = true
+			}
+			if len(seen) != len(tt.want) {
+				t.Fatalf("only %d of %d possible assignments were ever drawn", len(seen), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestSearchStepLimit(t *testing.T) {
+	// 0 может дарить только 1, поэтому взаимную пару 0 ↔ 1 нельзя разбить обменом
+	// с другим циклом, и nomutual переходит к перебору. Круг без взаимных пар есть:
+	// 0 → 1 → 2 → 4 → 3 → 0.
+	tests := []struct {
+		name      string
+		n         int
+		forbidden map[int64][]int64
+		search    func(s *assignmentSolver) ([]int, error)
+		check     func(receiverOf []int) bool
+	}{
+		{
+			name:   "chain",
+			n:      exactCycleMaxNodes + 4,
+			search: func(s *assignmentSolver) ([]int, error) { return s.hamiltonianCycle() },
+			check:  isSingleCycle,
+		},
+		{
+			name:      "nomutual fallback",
+			n:         5,
+			forbidden: map[int64][]int64{0: {2, 3, 4}, 1: {3, 4}, 2: {1}, 3: {1}, 4: {0, 1}},
+			search: func(s *assignmentSolver) ([]int, error) {
+				return s.removeMutualPairs([]int{1, 0, 3, 4, 2})
+			},
+			check: func(receiverOf []int) bool { return !hasMutualPair(receiverOf) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSolver(tt.n, tt.forbidden)
+			receiverOf, err := tt.search(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAllowed(t, s, receiverOf)
+			if !tt.check(receiverOf) {
+				t.Fatalf("%v does not satisfy the mode", receiverOf)
+			}
+
+			s = testSolver(tt.n, tt.forbidden)
+			s.stepLimit = 1
+			if receiverOf, err := tt.search(s); !errors.Is(err, ErrSearchLimit) {
+				t.Fatalf("search = %v, %v; want ErrSearchLimit", receiverOf, err)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"telegram-secret-santa/internal/domain"
 
@@ -14,91 +16,391 @@ import (
 
 type Storage struct {
 	client *redis.Client
-	ctx    context.Context
 }
 
-func NewStorage(host, port, password string, db int) (*Storage, error) {
+// NewStorage подключается к Redis и переносит данные из прежних раскладок. legacyGameID -
+// чат, в игру которого попадут данные версии с одной общей игрой; без него бот с такими
+// данными не запускается.
+func NewStorage(ctx context.Context, host, port, password string, db int, legacyGameID int64) (*Storage, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", host, port),
 		Password: password,
 		DB:       db,
 	})
 
-	ctx := context.Background()
-
 	_, err := rdb.Ping(ctx).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &Storage{
+	s := &Storage{
 		client: rdb,
-		ctx:    ctx,
-	}, nil
+	}
+	if err := s.migrate(ctx, legacyGameID); err != nil {
+		return nil, fmt.Errorf("failed to migrate Redis data: %w", err)
+	}
+
+	return s, nil
+}
+
+func userKey(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func userGamesKey(userID int64) string {
+	return fmt.Sprintf("user_games:%d", userID)
+}
+
+func reachabilityKey(userID int64) string {
+	return fmt.Sprintf("reachability:%d", userID)
+}
+
+func selectedGameKey(userID int64) string {
+	return fmt.Sprintf("selected_game:%d", userID)
+}
+
+func gameKeyPrefix(gameID int64) string {
+	return fmt.Sprintf("game:%d:", gameID)
+}
+
+func gameInfoKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "info"
+}
+
+func participantKey(gameID, userID int64) string {
+	return fmt.Sprintf("%sparticipant:%d", gameKeyPrefix(gameID), userID)
+}
+
+func restrictionKey(gameID, userID, forbiddenUserID int64) string {
+	return fmt.Sprintf("%srestriction:%d:%d", gameKeyPrefix(gameID), userID, forbiddenUserID)
+}
+
+func restrictionCreatorKey(gameID, userID, forbiddenUserID int64) string {
+	return fmt.Sprintf("%srestriction_creator:%d:%d", gameKeyPrefix(gameID), userID, forbiddenUserID)
+}
+
+func preferenceKey(gameID, userID, targetID int64) string {
+	return fmt.Sprintf("%spreference:%d:%d", gameKeyPrefix(gameID), userID, targetID)
+}
+
+func groupKey(gameID int64, name string) string {
+	return fmt.Sprintf("%sgroup:%s", gameKeyPrefix(gameID), strings.ToLower(name))
+}
+
+func assignmentKey(gameID, giverID int64) string {
+	return fmt.Sprintf("%sassignment:%d", gameKeyPrefix(gameID), giverID)
 }
 
-func participantKey(userID int64) string {
-	return fmt.Sprintf("participant:%d", userID)
+func giftStatusKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "gift_status"
 }
 
-func restrictionKey(userID, forbiddenUserID int64) string {
-	return fmt.Sprintf("restriction:%d:%d", userID, forbiddenUserID)
+func gameStateKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "state"
 }
 
-func restrictionCreatorKey(userID, forbiddenUserID int64) string {
-	return fmt.Sprintf("restriction_creator:%d:%d", userID, forbiddenUserID)
+func gameSettingsKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "settings"
 }
 
-func assignmentKey(giverID int64) string {
-	return fmt.Sprintf("assignment:%d", giverID)
+func relayRoutesKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "relay_routes"
 }
 
-func gameStateKey() string {
-	return "game:state"
+func relayLogKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "relay_log"
 }
 
-func (s *Storage) SaveParticipant(p *domain.Participant) error {
+func drawRecordKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "draw"
+}
+
+func entropyKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "entropy"
+}
+
+func revealSettingsKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "reveal"
+}
+
+func historyKey(gameID int64) string {
+	return fmt.Sprintf("history:%d", gameID)
+}
+
+// Все, что бот читает списком, лежит в хешах-индексах: одна команда HGETALL вместо
+// KEYS и отдельного GET на каждый ключ. Ключи по одному на запись (userKey, participantKey
+// и т.п.) остались только в BoltStorage и в миграции данных из старой раскладки.
+const (
+	usersKey         = "users"
+	gamesKey         = "games"
+	triggerWordsKey  = "trigger_words"
+	schemaVersionKey = "schema_version"
+	scanBatchSize    = 500
+)
+
+func participantsKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "participants"
+}
+
+func restrictionsKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "restrictions"
+}
+
+func preferencesKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "preferences"
+}
+
+func groupsKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "groups"
+}
+
+func assignmentsKey(gameID int64) string {
+	return gameKeyPrefix(gameID) + "assignments"
+}
+
+func commentsKey(gameID, receiverID int64) string {
+	return fmt.Sprintf("%scomments:%d", gameKeyPrefix(gameID), receiverID)
+}
+
+func idField(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func pairField(first, second int64) string {
+	return fmt.Sprintf("%d:%d", first, second)
+}
+
+func parsePairField(field string) (int64, int64, bool) {
+	first, second, ok := strings.Cut(field, ":")
+	if !ok {
+		return 0, 0, false
+	}
+	a, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	b, err := strconv.ParseInt(second, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+// scanKeys обходит ключи по шаблону порциями через SCAN: в отличие от KEYS, Redis не блокируется на весь обход.
+func (s *Storage) scanKeys(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys %s: %w", pattern, err)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (s *Storage) SaveUser(ctx context.Context, p *domain.Participant) error {
 	data, err := json.Marshal(p)
 	if err != nil {
-		return fmt.Errorf("failed to serialize participant: %w", err)
+		return fmt.Errorf("failed to serialize user: %w", err)
 	}
 
-	key := participantKey(p.UserID)
-	return s.client.Set(s.ctx, key, data, 0).Err()
+	return s.client.HSet(ctx, usersKey, idField(p.UserID), data).Err()
 }
 
-func (s *Storage) GetParticipant(userID int64) (*domain.Participant, error) {
-	key := participantKey(userID)
-	data, err := s.client.Get(s.ctx, key).Result()
+func (s *Storage) GetUser(ctx context.Context, userID int64) (*domain.Participant, error) {
+	data, err := s.client.HGet(ctx, usersKey, idField(userID)).Result()
 	if err == redis.Nil {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get participant: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	var p domain.Participant
 	if err := json.Unmarshal([]byte(data), &p); err != nil {
-		return nil, fmt.Errorf("failed to deserialize participant: %w", err)
+		return nil, fmt.Errorf("failed to deserialize user: %w", err)
 	}
 
 	return &p, nil
 }
 
-func (s *Storage) GetAllParticipants() (map[int64]*domain.Participant, error) {
-	participants := make(map[int64]*domain.Participant)
+func (s *Storage) GetAllUsers(ctx context.Context) (map[int64]*domain.Participant, error) {
+	items, err := s.client.HGetAll(ctx, usersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	users := make(map[int64]*domain.Participant, len(items))
+	for _, data := range items {
+		var p domain.Participant
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			continue
+		}
+
+		users[p.UserID] = &p
+	}
+
+	return users, nil
+}
 
-	keys, err := s.client.Keys(s.ctx, "participant:*").Result()
+func (s *Storage) SaveGame(ctx context.Context, g *domain.Game) error {
+	data, err := json.Marshal(g)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get participant keys: %w", err)
+		return fmt.Errorf("failed to serialize game: %w", err)
 	}
 
-	for _, key := range keys {
-		data, err := s.client.Get(s.ctx, key).Result()
+	return s.client.HSet(ctx, gamesKey, idField(g.ID), data).Err()
+}
+
+func (s *Storage) GetGame(ctx context.Context, gameID int64) (*domain.Game, error) {
+	data, err := s.client.HGet(ctx, gamesKey, idField(gameID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game: %w", err)
+	}
+
+	var g domain.Game
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return nil, fmt.Errorf("failed to deserialize game: %w", err)
+	}
+
+	return &g, nil
+}
+
+func (s *Storage) GetAllGames(ctx context.Context) (map[int64]*domain.Game, error) {
+	items, err := s.client.HGetAll(ctx, gamesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get games: %w", err)
+	}
+
+	games := make(map[int64]*domain.Game, len(items))
+	for _, data := range items {
+		var g domain.Game
+		if err := json.Unmarshal([]byte(data), &g); err != nil {
+			continue
+		}
+
+		games[g.ID] = &g
+	}
+
+	return games, nil
+}
+
+func (s *Storage) SaveReachability(ctx context.Context, userID int64, r *domain.Reachability) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to serialize reachability: %w", err)
+	}
+
+	return s.client.Set(ctx, reachabilityKey(userID), data, 0).Err()
+}
+
+func (s *Storage) GetReachability(ctx context.Context, userID int64) (*domain.Reachability, error) {
+	data, err := s.client.Get(ctx, reachabilityKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reachability: %w", err)
+	}
+
+	var r domain.Reachability
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, fmt.Errorf("failed to deserialize reachability: %w", err)
+	}
+
+	return &r, nil
+}
+
+func (s *Storage) GetUserGames(ctx context.Context, userID int64) ([]int64, error) {
+	members, err := s.client.SMembers(ctx, userGamesKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user games: %w", err)
+	}
+
+	gameIDs := make([]int64, 0, len(members))
+	for _, member := range members {
+		gameID, err := strconv.ParseInt(member, 10, 64)
 		if err != nil {
 			continue
 		}
+		gameIDs = append(gameIDs, gameID)
+	}
+
+	return gameIDs, nil
+}
+
+func (s *Storage) SaveSelectedGame(ctx context.Context, userID, gameID int64) error {
+	return s.client.Set(ctx, selectedGameKey(userID), strconv.FormatInt(gameID, 10), 0).Err()
+}
+
+func (s *Storage) GetSelectedGame(ctx context.Context, userID int64) (int64, error) {
+	data, err := s.client.Get(ctx, selectedGameKey(userID)).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get selected game: %w", err)
+	}
+
+	gameID, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse game ID: %w", err)
+	}
+
+	return gameID, nil
+}
+
+func (s *Storage) SaveParticipant(ctx context.Context, gameID int64, p *domain.Participant) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to serialize participant: %w", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, participantsKey(gameID), idField(p.UserID), data)
+	pipe.SAdd(ctx, userGamesKey(p.UserID), idField(gameID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save participant: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) GetParticipant(ctx context.Context, gameID, userID int64) (*domain.Participant, error) {
+	data, err := s.client.HGet(ctx, participantsKey(gameID), idField(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participant: %w", err)
+	}
+
+	var p domain.Participant
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, fmt.Errorf("failed to deserialize participant: %w", err)
+	}
+
+	return &p, nil
+}
+
+func (s *Storage) GetAllParticipants(ctx context.Context, gameID int64) (map[int64]*domain.Participant, error) {
+	items, err := s.client.HGetAll(ctx, participantsKey(gameID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participants: %w", err)
+	}
 
+	participants := make(map[int64]*domain.Participant, len(items))
+	for _, data := range items {
 		var p domain.Participant
 		if err := json.Unmarshal([]byte(data), &p); err != nil {
 			continue
@@ -110,38 +412,36 @@ func (s *Storage) GetAllParticipants() (map[int64]*domain.Participant, error) {
 	return participants, nil
 }
 
-func (s *Storage) DeleteParticipant(userID int64) error {
-	key := participantKey(userID)
-	return s.client.Del(s.ctx, key).Err()
+func (s *Storage) DeleteParticipant(ctx context.Context, gameID, userID int64) error {
+	pipe := s.client.TxPipeline()
+	pipe.HDel(ctx, participantsKey(gameID), idField(userID))
+	pipe.SRem(ctx, userGamesKey(userID), idField(gameID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete participant: %w", err)
+	}
+	return nil
 }
 
-func (s *Storage) SaveRestriction(userID, forbiddenUserID, creatorID int64) error {
-	key := restrictionKey(userID, forbiddenUserID)
-	creatorKey := restrictionCreatorKey(userID, forbiddenUserID)
-
-	if err := s.client.Set(s.ctx, key, "1", 0).Err(); err != nil {
+// Ограничения хранятся в хеше игры: поле «кто:кого нельзя», значение - ID того, кто
+// добавил ограничение (0, если неизвестно).
+func (s *Storage) SaveRestriction(ctx context.Context, gameID, userID, forbiddenUserID, creatorID int64) error {
+	err := s.client.HSet(ctx, restrictionsKey(gameID), pairField(userID, forbiddenUserID), idField(creatorID)).Err()
+	if err != nil {
 		return fmt.Errorf("failed to save restriction: %w", err)
 	}
-
-	if err := s.client.Set(s.ctx, creatorKey, strconv.FormatInt(creatorID, 10), 0).Err(); err != nil {
-		return fmt.Errorf("failed to save restriction creator: %w", err)
-	}
-
 	return nil
 }
 
-func (s *Storage) HasRestriction(userID, forbiddenUserID int64) (bool, error) {
-	key := restrictionKey(userID, forbiddenUserID)
-	exists, err := s.client.Exists(s.ctx, key).Result()
+func (s *Storage) HasRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) (bool, error) {
+	exists, err := s.client.HExists(ctx, restrictionsKey(gameID), pairField(userID, forbiddenUserID)).Result()
 	if err != nil {
 		return false, fmt.Errorf("failed to check restriction: %w", err)
 	}
-	return exists > 0, nil
+	return exists, nil
 }
 
-func (s *Storage) GetRestrictionCreator(userID, forbiddenUserID int64) (int64, error) {
-	key := restrictionCreatorKey(userID, forbiddenUserID)
-	data, err := s.client.Get(s.ctx, key).Result()
+func (s *Storage) GetRestrictionCreator(ctx context.Context, gameID, userID, forbiddenUserID int64) (int64, error) {
+	data, err := s.client.HGet(ctx, restrictionsKey(gameID), pairField(userID, forbiddenUserID)).Result()
 	if err == redis.Nil {
 		return 0, nil
 	}
@@ -157,19 +457,17 @@ func (s *Storage) GetRestrictionCreator(userID, forbiddenUserID int64) (int64, e
 	return creatorID, nil
 }
 
-func (s *Storage) GetAllRestrictions() (map[int64]map[int64]bool, map[int64]map[int64]int64, error) {
-	restrictions := make(map[int64]map[int64]bool)
-	creators := make(map[int64]map[int64]int64)
-
-	keys, err := s.client.Keys(s.ctx, "restriction:*").Result()
+func (s *Storage) GetAllRestrictions(ctx context.Context, gameID int64) (map[int64]map[int64]bool, map[int64]map[int64]int64, error) {
+	items, err := s.client.HGetAll(ctx, restrictionsKey(gameID)).Result()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get restriction keys: %w", err)
+		return nil, nil, fmt.Errorf("failed to get restrictions: %w", err)
 	}
 
-	for _, key := range keys {
-		var userID, forbiddenUserID int64
-		_, err := fmt.Sscanf(key, "restriction:%d:%d", &userID, &forbiddenUserID)
-		if err != nil {
+	restrictions := make(map[int64]map[int64]bool)
+	creators := make(map[int64]map[int64]int64)
+	for field, data := range items {
+		userID, forbiddenUserID, ok := parsePairField(field)
+		if !ok {
 			continue
 		}
 
@@ -178,7 +476,7 @@ func (s *Storage) GetAllRestrictions() (map[int64]map[int64]bool, map[int64]map[
 		}
 		restrictions[userID][forbiddenUserID] = true
 
-		creatorID, err := s.GetRestrictionCreator(userID, forbiddenUserID)
+		creatorID, err := strconv.ParseInt(data, 10, 64)
 		if err == nil && creatorID != 0 {
 			if creators[userID] == nil {
 				creators[userID] = make(map[int64]int64)
@@ -190,48 +488,131 @@ func (s *Storage) GetAllRestrictions() (map[int64]map[int64]bool, map[int64]map[
 	return restrictions, creators, nil
 }
 
-func (s *Storage) DeleteRestriction(userID, forbiddenUserID int64) error {
-	key := restrictionKey(userID, forbiddenUserID)
-	creatorKey := restrictionCreatorKey(userID, forbiddenUserID)
-
-	if err := s.client.Del(s.ctx, key, creatorKey).Err(); err != nil {
+func (s *Storage) DeleteRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) error {
+	if err := s.client.HDel(ctx, restrictionsKey(gameID), pairField(userID, forbiddenUserID)).Err(); err != nil {
 		return fmt.Errorf("failed to delete restriction: %w", err)
 	}
-
 	return nil
 }
 
-func (s *Storage) DeleteAllRestrictionsForUser(userID int64) error {
-	pattern := fmt.Sprintf("restriction:%d:*", userID)
-	keys, err := s.client.Keys(s.ctx, pattern).Result()
+func (s *Storage) DeleteAllRestrictionsForUser(ctx context.Context, gameID, userID int64) error {
+	key := restrictionsKey(gameID)
+	iter := s.client.HScan(ctx, key, 0, fmt.Sprintf("%d:*", userID), scanBatchSize).Iterator()
+
+	var fields []string
+	for i := 0; iter.Next(ctx); i++ {
+		// HSCAN возвращает поля вперемешку со значениями.
+		if i%2 == 0 {
+			fields = append(fields, iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan restrictions: %w", err)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.client.HDel(ctx, key, fields...).Err()
+}
+
+func (s *Storage) SavePreference(ctx context.Context, gameID, userID, targetID int64, kind domain.PreferenceKind) error {
+	return s.client.HSet(ctx, preferencesKey(gameID), pairField(userID, targetID), string(kind)).Err()
+}
+
+func (s *Storage) GetAllPreferences(ctx context.Context, gameID int64) (map[int64]map[int64]domain.PreferenceKind, error) {
+	items, err := s.client.HGetAll(ctx, preferencesKey(gameID)).Result()
 	if err != nil {
-		return fmt.Errorf("failed to get restriction keys: %w", err)
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
 	}
 
-	if len(keys) > 0 {
-		creatorKeys := make([]string, 0, len(keys))
-		for _, key := range keys {
-			var forbiddenUserID int64
-			_, err := fmt.Sscanf(key, "restriction:%d:%d", &userID, &forbiddenUserID)
-			if err == nil {
-				creatorKeys = append(creatorKeys, restrictionCreatorKey(userID, forbiddenUserID))
-			}
+	preferences := make(map[int64]map[int64]domain.PreferenceKind)
+	for field, data := range items {
+		userID, targetID, ok := parsePairField(field)
+		if !ok {
+			continue
+		}
+
+		if preferences[userID] == nil {
+			preferences[userID] = make(map[int64]domain.PreferenceKind)
 		}
-		keys = append(keys, creatorKeys...)
-		return s.client.Del(s.ctx, keys...).Err()
+		preferences[userID][targetID] = domain.PreferenceKind(data)
 	}
 
-	return nil
+	return preferences, nil
+}
+
+func (s *Storage) DeletePreference(ctx context.Context, gameID, userID, targetID int64) error {
+	return s.client.HDel(ctx, preferencesKey(gameID), pairField(userID, targetID)).Err()
+}
+
+func (s *Storage) SaveGroup(ctx context.Context, gameID int64, g *domain.Group) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return fmt.Errorf("failed to serialize group: %w", err)
+	}
+
+	return s.client.HSet(ctx, groupsKey(gameID), strings.ToLower(g.Name), data).Err()
+}
+
+func (s *Storage) GetGroup(ctx context.Context, gameID int64, name string) (*domain.Group, error) {
+	data, err := s.client.HGet(ctx, groupsKey(gameID), strings.ToLower(name)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	var g domain.Group
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return nil, fmt.Errorf("failed to deserialize group: %w", err)
+	}
+
+	return &g, nil
+}
+
+func (s *Storage) GetAllGroups(ctx context.Context, gameID int64) (map[string]*domain.Group, error) {
+	items, err := s.client.HGetAll(ctx, groupsKey(gameID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+
+	groups := make(map[string]*domain.Group, len(items))
+	for _, data := range items {
+		var g domain.Group
+		if err := json.Unmarshal([]byte(data), &g); err != nil {
+			continue
+		}
+
+		groups[strings.ToLower(g.Name)] = &g
+	}
+
+	return groups, nil
 }
 
-func (s *Storage) SaveAssignment(giverID, receiverID int64) error {
-	key := assignmentKey(giverID)
-	return s.client.Set(s.ctx, key, strconv.FormatInt(receiverID, 10), 0).Err()
+func (s *Storage) DeleteGroup(ctx context.Context, gameID int64, name string) error {
+	return s.client.HDel(ctx, groupsKey(gameID), strings.ToLower(name)).Err()
+}
+
+// SaveAssignment при смене получателя сбрасывает статус подарка: он относится к паре, а не к дарителю.
+func (s *Storage) SaveAssignment(ctx context.Context, gameID, giverID, receiverID int64) error {
+	previous, err := s.GetAssignment(ctx, gameID, giverID)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, assignmentsKey(gameID), idField(giverID), idField(receiverID))
+	if previous != receiverID {
+		pipe.HDel(ctx, giftStatusKey(gameID), idField(giverID))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-func (s *Storage) GetAssignment(giverID int64) (int64, error) {
-	key := assignmentKey(giverID)
-	data, err := s.client.Get(s.ctx, key).Result()
+func (s *Storage) GetAssignment(ctx context.Context, gameID, giverID int64) (int64, error) {
+	data, err := s.client.HGet(ctx, assignmentsKey(gameID), idField(giverID)).Result()
 	if err == redis.Nil {
 		return 0, nil
 	}
@@ -247,57 +628,82 @@ func (s *Storage) GetAssignment(giverID int64) (int64, error) {
 	return receiverID, nil
 }
 
-func (s *Storage) GetAllAssignments() (map[int64]int64, error) {
-	assignments := make(map[int64]int64)
-
-	keys, err := s.client.Keys(s.ctx, "assignment:*").Result()
+func (s *Storage) GetAllAssignments(ctx context.Context, gameID int64) (map[int64]int64, error) {
+	items, err := s.client.HGetAll(ctx, assignmentsKey(gameID)).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get assignment keys: %w", err)
+		return nil, fmt.Errorf("failed to get assignments: %w", err)
 	}
 
-	for _, key := range keys {
-		var giverID int64
-		_, err := fmt.Sscanf(key, "assignment:%d", &giverID)
+	assignments := make(map[int64]int64, len(items))
+	for field, data := range items {
+		giverID, err := strconv.ParseInt(field, 10, 64)
 		if err != nil {
 			continue
 		}
-
-		receiverID, err := s.GetAssignment(giverID)
-		if err == nil {
-			assignments[giverID] = receiverID
+		receiverID, err := strconv.ParseInt(data, 10, 64)
+		if err != nil {
+			continue
 		}
+		assignments[giverID] = receiverID
 	}
 
 	return assignments, nil
 }
 
-func (s *Storage) DeleteAssignment(giverID int64) error {
-	key := assignmentKey(giverID)
-	return s.client.Del(s.ctx, key).Err()
+func (s *Storage) DeleteAssignment(ctx context.Context, gameID, giverID int64) error {
+	pipe := s.client.TxPipeline()
+	pipe.HDel(ctx, assignmentsKey(gameID), idField(giverID))
+	pipe.HDel(ctx, giftStatusKey(gameID), idField(giverID))
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
-func (s *Storage) DeleteAllAssignments() error {
-	keys, err := s.client.Keys(s.ctx, "assignment:*").Result()
+func (s *Storage) DeleteAllAssignments(ctx context.Context, gameID int64) error {
+	return s.client.Del(ctx, assignmentsKey(gameID), giftStatusKey(gameID)).Err()
+}
+
+func (s *Storage) SaveGiftStatus(ctx context.Context, gameID, giverID int64, status domain.GiftStatus) error {
+	return s.client.HSet(ctx, giftStatusKey(gameID), strconv.FormatInt(giverID, 10), string(status)).Err()
+}
+
+func (s *Storage) GetGiftStatus(ctx context.Context, gameID, giverID int64) (domain.GiftStatus, error) {
+	status, err := s.client.HGet(ctx, giftStatusKey(gameID), strconv.FormatInt(giverID, 10)).Result()
+	if err == redis.Nil {
+		return domain.GiftNotStarted, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get gift status: %w", err)
+	}
+	return domain.GiftStatus(status), nil
+}
+
+func (s *Storage) GetAllGiftStatuses(ctx context.Context, gameID int64) (map[int64]domain.GiftStatus, error) {
+	items, err := s.client.HGetAll(ctx, giftStatusKey(gameID)).Result()
 	if err != nil {
-		return fmt.Errorf("failed to get assignment keys: %w", err)
+		return nil, fmt.Errorf("failed to get gift statuses: %w", err)
 	}
 
-	if len(keys) > 0 {
-		return s.client.Del(s.ctx, keys...).Err()
+	statuses := make(map[int64]domain.GiftStatus, len(items))
+	for field, status := range items {
+		giverID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		statuses[giverID] = domain.GiftStatus(status)
 	}
 
-	return nil
+	return statuses, nil
 }
 
-func (s *Storage) SaveGameState(gameActive, gameStarted bool) error {
-	key := gameStateKey()
+func (s *Storage) SaveGameState(ctx context.Context, gameID int64, gameActive, gameStarted bool) error {
+	key := gameStateKey(gameID)
 	data := fmt.Sprintf("%t:%t", gameActive, gameStarted)
-	return s.client.Set(s.ctx, key, data, 0).Err()
+	return s.client.Set(ctx, key, data, 0).Err()
 }
 
-func (s *Storage) GetGameState() (bool, bool, error) {
-	key := gameStateKey()
-	data, err := s.client.Get(s.ctx, key).Result()
+func (s *Storage) GetGameState(ctx context.Context, gameID int64) (bool, bool, error) {
+	key := gameStateKey(gameID)
+	data, err := s.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return false, false, nil
 	}
@@ -314,36 +720,245 @@ func (s *Storage) GetGameState() (bool, bool, error) {
 	return gameActive, gameStarted, nil
 }
 
-func (s *Storage) ResetGameState() error {
-	key := gameStateKey()
-	return s.client.Del(s.ctx, key).Err()
+func (s *Storage) ResetGameState(ctx context.Context, gameID int64) error {
+	key := gameStateKey(gameID)
+	return s.client.Del(ctx, key).Err()
 }
 
-func (s *Storage) ClearAll() error {
-	keys, err := s.client.Keys(s.ctx, "*").Result()
+func (s *Storage) SaveGameSettings(ctx context.Context, gameID int64, settings *domain.GameSettings) error {
+	data, err := json.Marshal(settings)
 	if err != nil {
-		return fmt.Errorf("failed to get all keys: %w", err)
+		return fmt.Errorf("failed to serialize game settings: %w", err)
 	}
 
-	if len(keys) > 0 {
-		return s.client.Del(s.ctx, keys...).Err()
+	return s.client.Set(ctx, gameSettingsKey(gameID), data, 0).Err()
+}
+
+func (s *Storage) GetGameSettings(ctx context.Context, gameID int64) (*domain.GameSettings, error) {
+	data, err := s.client.Get(ctx, gameSettingsKey(gameID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game settings: %w", err)
 	}
 
-	return nil
+	var settings domain.GameSettings
+	if err := json.Unmarshal([]byte(data), &settings); err != nil {
+		return nil, fmt.Errorf("failed to deserialize game settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+func (s *Storage) SaveDrawRecord(ctx context.Context, gameID int64, r *domain.DrawRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to serialize draw record: %w", err)
+	}
+
+	return s.client.Set(ctx, drawRecordKey(gameID), data, 0).Err()
 }
 
-func wishKey(userID int64) string {
-	return fmt.Sprintf("wish:%d", userID)
+func (s *Storage) GetDrawRecord(ctx context.Context, gameID int64) (*domain.DrawRecord, error) {
+	data, err := s.client.Get(ctx, drawRecordKey(gameID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get draw record: %w", err)
+	}
+
+	var r domain.DrawRecord
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, fmt.Errorf("failed to deserialize draw record: %w", err)
+	}
+
+	return &r, nil
 }
 
-func (s *Storage) SaveWish(userID int64, wish string) error {
-	key := wishKey(userID)
-	return s.client.Set(s.ctx, key, wish, 0).Err()
+func (s *Storage) SaveEntropy(ctx context.Context, gameID, userID int64, e *domain.Entropy) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to serialize entropy: %w", err)
+	}
+
+	return s.client.HSet(ctx, entropyKey(gameID), strconv.FormatInt(userID, 10), data).Err()
 }
 
-func (s *Storage) GetWish(userID int64) (string, error) {
-	key := wishKey(userID)
-	data, err := s.client.Get(s.ctx, key).Result()
+func (s *Storage) GetAllEntropy(ctx context.Context, gameID int64) (map[int64]*domain.Entropy, error) {
+	items, err := s.client.HGetAll(ctx, entropyKey(gameID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entropy: %w", err)
+	}
+
+	entropy := make(map[int64]*domain.Entropy, len(items))
+	for field, data := range items {
+		userID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		entropy[userID] = decodeEntropy([]byte(data))
+	}
+
+	return entropy, nil
+}
+
+// decodeEntropy читает сохраненный вклад. Вклады, принятые до появления nonce,
+// хранились просто текстом.
+func decodeEntropy(data []byte) *domain.Entropy {
+	var e domain.Entropy
+	if err := json.Unmarshal(data, &e); err != nil || e.Text == "" {
+		return &domain.Entropy{Text: string(data)}
+	}
+	return &e
+}
+
+func (s *Storage) SaveRevealSettings(ctx context.Context, gameID int64, r *domain.RevealSettings) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to serialize reveal settings: %w", err)
+	}
+
+	return s.client.Set(ctx, revealSettingsKey(gameID), data, 0).Err()
+}
+
+func (s *Storage) GetRevealSettings(ctx context.Context, gameID int64) (*domain.RevealSettings, error) {
+	data, err := s.client.Get(ctx, revealSettingsKey(gameID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reveal settings: %w", err)
+	}
+
+	var r domain.RevealSettings
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, fmt.Errorf("failed to deserialize reveal settings: %w", err)
+	}
+
+	return &r, nil
+}
+
+func (s *Storage) SaveSeason(ctx context.Context, season *domain.Season) error {
+	data, err := json.Marshal(season)
+	if err != nil {
+		return fmt.Errorf("failed to serialize season: %w", err)
+	}
+
+	return s.client.LPush(ctx, historyKey(season.GameID), data).Err()
+}
+
+func (s *Storage) GetSeasons(ctx context.Context, gameID int64) ([]*domain.Season, error) {
+	items, err := s.client.LRange(ctx, historyKey(gameID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+
+	seasons := make([]*domain.Season, 0, len(items))
+	for _, item := range items {
+		var season domain.Season
+		if err := json.Unmarshal([]byte(item), &season); err != nil {
+			continue
+		}
+		seasons = append(seasons, &season)
+	}
+
+	return seasons, nil
+}
+
+func (s *Storage) ClearGame(ctx context.Context, gameID int64) error {
+	participants, err := s.GetAllParticipants(ctx, gameID)
+	if err != nil {
+		return err
+	}
+
+	if len(participants) > 0 {
+		pipe := s.client.Pipeline()
+		for userID := range participants {
+			pipe.SRem(ctx, userGamesKey(userID), idField(gameID))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to remove game from users: %w", err)
+		}
+	}
+
+	preserved := gameSettingsKey(gameID)
+	return s.scanKeys(ctx, gameKeyPrefix(gameID)+"*", func(keys []string) error {
+		toDelete := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if key != preserved {
+				toDelete = append(toDelete, key)
+			}
+		}
+		if len(toDelete) == 0 {
+			return nil
+		}
+		if err := s.client.Del(ctx, toDelete...).Err(); err != nil {
+			return fmt.Errorf("failed to delete game keys: %w", err)
+		}
+		return nil
+	})
+}
+
+func relayRouteField(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+func (s *Storage) SaveRelayRoute(ctx context.Context, chatID int64, messageID int, route *domain.RelayRoute) error {
+	return s.client.HSet(ctx, relayRoutesKey(route.GameID), relayRouteField(chatID, messageID), string(route.Role)).Err()
+}
+
+func (s *Storage) GetRelayRole(ctx context.Context, gameID, chatID int64, messageID int) (domain.RelayRole, error) {
+	role, err := s.client.HGet(ctx, relayRoutesKey(gameID), relayRouteField(chatID, messageID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get relay route: %w", err)
+	}
+	return domain.RelayRole(role), nil
+}
+
+func (s *Storage) SaveRelayMessage(ctx context.Context, m *domain.RelayMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to serialize relay message: %w", err)
+	}
+
+	return s.client.RPush(ctx, relayLogKey(m.GameID), data).Err()
+}
+
+func (s *Storage) GetRelayMessages(ctx context.Context, gameID int64) ([]*domain.RelayMessage, error) {
+	items, err := s.client.LRange(ctx, relayLogKey(gameID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get relay messages: %w", err)
+	}
+
+	messages := make([]*domain.RelayMessage, 0, len(items))
+	for _, item := range items {
+		var m domain.RelayMessage
+		if err := json.Unmarshal([]byte(item), &m); err != nil {
+			continue
+		}
+		messages = append(messages, &m)
+	}
+
+	return messages, nil
+}
+
+func wishKey(gameID, userID int64) string {
+	return fmt.Sprintf("%swish:%d", gameKeyPrefix(gameID), userID)
+}
+
+func (s *Storage) SaveWish(ctx context.Context, gameID, userID int64, wish string) error {
+	key := wishKey(gameID, userID)
+	return s.client.Set(ctx, key, wish, 0).Err()
+}
+
+func (s *Storage) GetWish(ctx context.Context, gameID, userID int64) (string, error) {
+	key := wishKey(gameID, userID)
+	data, err := s.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return "", nil
 	}
@@ -353,18 +968,18 @@ func (s *Storage) GetWish(userID int64) (string, error) {
 	return data, nil
 }
 
-func (s *Storage) DeleteWish(userID int64) error {
-	key := wishKey(userID)
-	return s.client.Del(s.ctx, key).Err()
+func (s *Storage) DeleteWish(ctx context.Context, gameID, userID int64) error {
+	key := wishKey(gameID, userID)
+	return s.client.Del(ctx, key).Err()
 }
 
 func triggerMessagesKey(triggerWord string) string {
 	return fmt.Sprintf("trigger_messages:%s", triggerWord)
 }
 
-func (s *Storage) SaveTriggerMessage(triggerWord, message string) error {
+func (s *Storage) SaveTriggerMessage(ctx context.Context, triggerWord, message string) error {
 	key := triggerMessagesKey(triggerWord)
-	existing, err := s.GetTriggerMessages(triggerWord)
+	existing, err := s.GetTriggerMessages(ctx, triggerWord)
 	if err != nil {
 		return fmt.Errorf("failed to get existing messages: %w", err)
 	}
@@ -381,12 +996,16 @@ func (s *Storage) SaveTriggerMessage(triggerWord, message string) error {
 		return fmt.Errorf("failed to serialize messages: %w", err)
 	}
 
-	return s.client.Set(s.ctx, key, data, 0).Err()
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, key, data, 0)
+	pipe.SAdd(ctx, triggerWordsKey, triggerWord)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-func (s *Storage) GetTriggerMessages(triggerWord string) ([]string, error) {
+func (s *Storage) GetTriggerMessages(ctx context.Context, triggerWord string) ([]string, error) {
 	key := triggerMessagesKey(triggerWord)
-	data, err := s.client.Get(s.ctx, key).Result()
+	data, err := s.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return []string{}, nil
 	}
@@ -402,26 +1021,17 @@ func (s *Storage) GetTriggerMessages(triggerWord string) ([]string, error) {
 	return messages, nil
 }
 
-func (s *Storage) GetAllTriggerWords() ([]string, error) {
-	keys, err := s.client.Keys(s.ctx, "trigger_messages:*").Result()
+func (s *Storage) GetAllTriggerWords(ctx context.Context) ([]string, error) {
+	triggerWords, err := s.client.SMembers(ctx, triggerWordsKey).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get trigger message keys: %w", err)
+		return nil, fmt.Errorf("failed to get trigger words: %w", err)
 	}
-
-	triggerWords := make([]string, 0, len(keys))
-	for _, key := range keys {
-		triggerWord := strings.TrimPrefix(key, "trigger_messages:")
-		if triggerWord != "" {
-			triggerWords = append(triggerWords, triggerWord)
-		}
-	}
-
 	return triggerWords, nil
 }
 
-func (s *Storage) DeleteTriggerMessage(triggerWord, message string) error {
+func (s *Storage) DeleteTriggerMessage(ctx context.Context, triggerWord, message string) error {
 	key := triggerMessagesKey(triggerWord)
-	existing, err := s.GetTriggerMessages(triggerWord)
+	existing, err := s.GetTriggerMessages(ctx, triggerWord)
 	if err != nil {
 		return fmt.Errorf("failed to get existing messages: %w", err)
 	}
@@ -434,7 +1044,11 @@ func (s *Storage) DeleteTriggerMessage(triggerWord, message string) error {
 	}
 
 	if len(updated) == 0 {
-		return s.client.Del(s.ctx, key).Err()
+		pipe := s.client.TxPipeline()
+		pipe.Del(ctx, key)
+		pipe.SRem(ctx, triggerWordsKey, triggerWord)
+		_, err := pipe.Exec(ctx)
+		return err
 	}
 
 	data, err := json.Marshal(updated)
@@ -442,53 +1056,206 @@ func (s *Storage) DeleteTriggerMessage(triggerWord, message string) error {
 		return fmt.Errorf("failed to serialize messages: %w", err)
 	}
 
-	return s.client.Set(s.ctx, key, data, 0).Err()
+	return s.client.Set(ctx, key, data, 0).Err()
 }
 
-func commentKey(receiverID, authorID int64) string {
-	return fmt.Sprintf("comment:%d:%d", receiverID, authorID)
+func commentKey(gameID, receiverID, authorID int64) string {
+	return fmt.Sprintf("%scomment:%d:%d", gameKeyPrefix(gameID), receiverID, authorID)
 }
 
-func commentKeysPattern(receiverID int64) string {
-	return fmt.Sprintf("comment:%d:*", receiverID)
+func (s *Storage) SaveComment(ctx context.Context, gameID, receiverID, authorID int64, comment string) error {
+	return s.client.HSet(ctx, commentsKey(gameID, receiverID), idField(authorID), comment).Err()
 }
 
-func (s *Storage) SaveComment(receiverID, authorID int64, comment string) error {
-	key := commentKey(receiverID, authorID)
-	return s.client.Set(s.ctx, key, comment, 0).Err()
+func (s *Storage) GetComments(ctx context.Context, gameID, receiverID int64) (map[int64]string, error) {
+	items, err := s.client.HGetAll(ctx, commentsKey(gameID, receiverID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments: %w", err)
+	}
+
+	comments := make(map[int64]string, len(items))
+	for field, comment := range items {
+		authorID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		comments[authorID] = comment
+	}
+
+	return comments, nil
+}
+
+func (s *Storage) DeleteComment(ctx context.Context, gameID, receiverID, authorID int64) error {
+	return s.client.HDel(ctx, commentsKey(gameID, receiverID), idField(authorID)).Err()
+}
+
+const outboxQueueKey = "outbox:queue"
+
+func outboxMessageKey(id string) string {
+	return fmt.Sprintf("outbox:message:%s", id)
+}
+
+func outboxBatchKey(id string) string {
+	return fmt.Sprintf("outbox:batch:%s", id)
+}
+
+func outboxDeliveriesKey(batchID string) string {
+	return fmt.Sprintf("outbox:deliveries:%s", batchID)
+}
+
+func (s *Storage) EnqueueOutbound(ctx context.Context, m *domain.OutboundMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to serialize outbound message: %w", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, outboxMessageKey(m.ID), data, 0)
+	pipe.ZAdd(ctx, outboxQueueKey, redis.Z{Score: float64(m.NotBefore.UnixMilli()), Member: m.ID})
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-func (s *Storage) GetComments(receiverID int64) (map[int64]string, error) {
-	pattern := commentKeysPattern(receiverID)
-	keys, err := s.client.Keys(s.ctx, pattern).Result()
+func (s *Storage) EnqueueOutboundBatch(ctx context.Context, b *domain.OutboundBatch, deliveries []*domain.Delivery, messages []*domain.OutboundMessage) error {
+	batchData, err := json.Marshal(b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get comment keys: %w", err)
+		return fmt.Errorf("failed to serialize outbound batch: %w", err)
 	}
 
-	comments := make(map[int64]string)
-	for _, key := range keys {
-		var keyReceiverID, authorID int64
-		_, err := fmt.Sscanf(key, "comment:%d:%d", &keyReceiverID, &authorID)
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, outboxBatchKey(b.ID), batchData, 0)
+	for _, d := range deliveries {
+		data, err := json.Marshal(d)
 		if err != nil {
-			continue
+			return fmt.Errorf("failed to serialize delivery: %w", err)
 		}
+		pipe.HSet(ctx, outboxDeliveriesKey(b.ID), strconv.FormatInt(d.ChatID, 10), data)
+	}
+	for _, m := range messages {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to serialize outbound message: %w", err)
+		}
+		pipe.Set(ctx, outboxMessageKey(m.ID), data, 0)
+		pipe.ZAdd(ctx, outboxQueueKey, redis.Z{Score: float64(m.NotBefore.UnixMilli()), Member: m.ID})
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *Storage) GetDueOutbound(ctx context.Context, now time.Time, limit int) ([]*domain.OutboundMessage, error) {
+	ids, err := s.client.ZRangeByScore(ctx, outboxQueueKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbound queue: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
 
-		if keyReceiverID != receiverID {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = outboxMessageKey(id)
+	}
+	items, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbound messages: %w", err)
+	}
+
+	messages := make([]*domain.OutboundMessage, 0, len(ids))
+	var missing []interface{}
+	for i, item := range items {
+		data, ok := item.(string)
+		if !ok {
+			missing = append(missing, ids[i])
 			continue
 		}
 
-		data, err := s.client.Get(s.ctx, key).Result()
-		if err == nil {
-			comments[authorID] = data
+		var m domain.OutboundMessage
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			continue
 		}
+		messages = append(messages, &m)
+	}
+	if len(missing) > 0 {
+		s.client.ZRem(ctx, outboxQueueKey, missing...)
 	}
 
-	return comments, nil
+	return messages, nil
+}
+
+func (s *Storage) RescheduleOutbound(ctx context.Context, m *domain.OutboundMessage) error {
+	return s.EnqueueOutbound(ctx, m)
 }
 
-func (s *Storage) DeleteComment(receiverID, authorID int64) error {
-	key := commentKey(receiverID, authorID)
-	return s.client.Del(s.ctx, key).Err()
+func (s *Storage) DeleteOutbound(ctx context.Context, id string) error {
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(ctx, outboxQueueKey, id)
+	pipe.Del(ctx, outboxMessageKey(id))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *Storage) SaveOutboundBatch(ctx context.Context, b *domain.OutboundBatch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("failed to serialize outbound batch: %w", err)
+	}
+
+	return s.client.Set(ctx, outboxBatchKey(b.ID), data, 0).Err()
+}
+
+func (s *Storage) GetOutboundBatch(ctx context.Context, id string) (*domain.OutboundBatch, error) {
+	data, err := s.client.Get(ctx, outboxBatchKey(id)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbound batch: %w", err)
+	}
+
+	var b domain.OutboundBatch
+	if err := json.Unmarshal([]byte(data), &b); err != nil {
+		return nil, fmt.Errorf("failed to deserialize outbound batch: %w", err)
+	}
+
+	return &b, nil
+}
+
+func (s *Storage) DeleteOutboundBatch(ctx context.Context, id string) error {
+	return s.client.Del(ctx, outboxBatchKey(id), outboxDeliveriesKey(id)).Err()
+}
+
+func (s *Storage) SaveDelivery(ctx context.Context, batchID string, d *domain.Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to serialize delivery: %w", err)
+	}
+
+	return s.client.HSet(ctx, outboxDeliveriesKey(batchID), strconv.FormatInt(d.ChatID, 10), data).Err()
+}
+
+func (s *Storage) GetDeliveries(ctx context.Context, batchID string) ([]*domain.Delivery, error) {
+	items, err := s.client.HGetAll(ctx, outboxDeliveriesKey(batchID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+
+	deliveries := make([]*domain.Delivery, 0, len(items))
+	for _, item := range items {
+		var d domain.Delivery
+		if err := json.Unmarshal([]byte(item), &d); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, &d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ChatID < deliveries[j].ChatID })
+
+	return deliveries, nil
 }
 
 func (s *Storage) Close() error {
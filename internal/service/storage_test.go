@@ -0,0 +1,779 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"telegram-secret-santa/internal/domain"
+)
+
+// storageConformance проверяет поведение, на которое бот рассчитывает в любом хранилище.
+// Каждый подтест получает пустое хранилище от newStorage.
+func storageConformance(t *testing.T, newStorage func(t *testing.T) domain.StorageInterface) {
+	const gameID, otherGameID = int64(-1), int64(-10)
+	ctx := context.Background()
+
+	run := func(name string, fn func(t *testing.T, s domain.StorageInterface)) {
+		t.Run(name, func(t *testing.T) {
+			s := newStorage(t)
+			t.Cleanup(func() { s.Close() })
+			fn(t, s)
+		})
+	}
+
+	run("MissingValues", func(t *testing.T, s domain.StorageInterface) {
+		if u, err := s.GetUser(ctx, 1); err != nil || u != nil {
+			t.Fatalf("GetUser = %v, %v; want nil, nil", u, err)
+		}
+		if g, err := s.GetGame(ctx, gameID); err != nil || g != nil {
+			t.Fatalf("GetGame = %v, %v; want nil, nil", g, err)
+		}
+		if p, err := s.GetParticipant(ctx, gameID, 1); err != nil || p != nil {
+			t.Fatalf("GetParticipant = %v, %v; want nil, nil", p, err)
+		}
+		if id, err := s.GetAssignment(ctx, gameID, 1); err != nil || id != 0 {
+			t.Fatalf("GetAssignment = %d, %v; want 0, nil", id, err)
+		}
+		if id, err := s.GetSelectedGame(ctx, 1); err != nil || id != 0 {
+			t.Fatalf("GetSelectedGame = %d, %v; want 0, nil", id, err)
+		}
+		if w, err := s.GetWish(ctx, gameID, 1); err != nil || w != "" {
+			t.Fatalf("GetWish = %q, %v; want empty", w, err)
+		}
+		if st, err := s.GetGiftStatus(ctx, gameID, 1); err != nil || st != domain.GiftNotStarted {
+			t.Fatalf("GetGiftStatus = %q, %v; want not started", st, err)
+		}
+		if r, err := s.GetRelayRole(ctx, gameID, 1, 1); err != nil || r != "" {
+			t.Fatalf("GetRelayRole = %q, %v; want empty", r, err)
+		}
+		if active, started, err := s.GetGameState(ctx, gameID); err != nil || active || started {
+			t.Fatalf("GetGameState = %t, %t, %v; want false, false, nil", active, started, err)
+		}
+		if settings, err := s.GetGameSettings(ctx, gameID); err != nil || settings != nil {
+			t.Fatalf("GetGameSettings = %v, %v; want nil, nil", settings, err)
+		}
+		if r, err := s.GetDrawRecord(ctx, gameID); err != nil || r != nil {
+			t.Fatalf("GetDrawRecord = %v, %v; want nil, nil", r, err)
+		}
+		if r, err := s.GetRevealSettings(ctx, gameID); err != nil || r != nil {
+			t.Fatalf("GetRevealSettings = %v, %v; want nil, nil", r, err)
+		}
+		if r, err := s.GetReachability(ctx, 1); err != nil || r != nil {
+			t.Fatalf("GetReachability = %v, %v; want nil, nil", r, err)
+		}
+		if b, err := s.GetOutboundBatch(ctx, "missing"); err != nil || b != nil {
+			t.Fatalf("GetOutboundBatch = %v, %v; want nil, nil", b, err)
+		}
+		if m, err := s.GetTriggerMessages(ctx, "missing"); err != nil || len(m) != 0 {
+			t.Fatalf("GetTriggerMessages = %v, %v; want empty", m, err)
+		}
+	})
+
+	run("UsersAndGames", func(t *testing.T, s domain.StorageInterface) {
+		user := &domain.Participant{UserID: 1, Username: "alice", FullName: "Alice"}
+		mustDo(t, s.SaveUser(ctx, user))
+		mustDo(t, s.SaveGame(ctx, &domain.Game{ID: gameID, Title: "Офис"}))
+		mustDo(t, s.SaveGame(ctx, &domain.Game{ID: otherGameID, Title: "Семья"}))
+
+		got, err := s.GetUser(ctx, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetUser", got, user)
+
+		users, err := s.GetAllUsers(ctx)
+		mustDo(t, err)
+		assertEqual(t, "GetAllUsers", users, map[int64]*domain.Participant{1: user})
+
+		games, err := s.GetAllGames(ctx)
+		mustDo(t, err)
+		assertEqual(t, "GetAllGames", games, map[int64]*domain.Game{
+			gameID:      {ID: gameID, Title: "Офис"},
+			otherGameID: {ID: otherGameID, Title: "Семья"},
+		})
+
+		mustDo(t, s.SaveSelectedGame(ctx, 1, otherGameID))
+		selected, err := s.GetSelectedGame(ctx, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetSelectedGame", selected, otherGameID)
+
+		checked := time.Date(2026, 12, 1, 10, 0, 0, 0, time.UTC)
+		mustDo(t, s.SaveReachability(ctx, 1, &domain.Reachability{Reachable: false, ErrorCode: 403, Reason: "blocked", CheckedAt: checked}))
+		r, err := s.GetReachability(ctx, 1)
+		mustDo(t, err)
+		if r == nil || r.Reachable || r.ErrorCode != 403 || !r.CheckedAt.Equal(checked) {
+			t.Fatalf("GetReachability = %+v", r)
+		}
+	})
+
+	run("Participants", func(t *testing.T, s domain.StorageInterface) {
+		alice := &domain.Participant{UserID: 1, Username: "alice", FullName: "Alice"}
+		bob := &domain.Participant{UserID: 2, Username: "bob", FullName: "Bob"}
+		mustDo(t, s.SaveParticipant(ctx, gameID, alice))
+		mustDo(t, s.SaveParticipant(ctx, gameID, bob))
+		mustDo(t, s.SaveParticipant(ctx, otherGameID, alice))
+
+		participants, err := s.GetAllParticipants(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetAllParticipants", participants, map[int64]*domain.Participant{1: alice, 2: bob})
+
+		games, err := s.GetUserGames(ctx, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetUserGames", sortedIDs(games), []int64{otherGameID, gameID})
+
+		mustDo(t, s.DeleteParticipant(ctx, gameID, 1))
+		if p, _ := s.GetParticipant(ctx, gameID, 1); p != nil {
+			t.Fatalf("participant still present after DeleteParticipant")
+		}
+		games, err = s.GetUserGames(ctx, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetUserGames after delete", games, []int64{otherGameID})
+	})
+
+	run("RestrictionsAndPreferences", func(t *testing.T, s domain.StorageInterface) {
+		mustDo(t, s.SaveRestriction(ctx, gameID, 1, 2, 9))
+		mustDo(t, s.SaveRestriction(ctx, gameID, 1, 3, 9))
+		mustDo(t, s.SaveRestriction(ctx, gameID, 2, 1, 8))
+		mustDo(t, s.SaveRestriction(ctx, otherGameID, 1, 2, 9))
+
+		has, err := s.HasRestriction(ctx, gameID, 1, 2)
+		mustDo(t, err)
+		if !has {
+			t.Fatalf("HasRestriction = false; want true")
+		}
+		creator, err := s.GetRestrictionCreator(ctx, gameID, 2, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetRestrictionCreator", creator, int64(8))
+
+		restrictions, creators, err := s.GetAllRestrictions(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetAllRestrictions", restrictions, map[int64]map[int64]bool{1: {2: true, 3: true}, 2: {1: true}})
+		assertEqual(t, "restriction creators", creators, map[int64]map[int64]int64{1: {2: 9, 3: 9}, 2: {1: 8}})
+
+		mustDo(t, s.DeleteRestriction(ctx, gameID, 2, 1))
+		mustDo(t, s.DeleteAllRestrictionsForUser(ctx, gameID, 1))
+		restrictions, _, err = s.GetAllRestrictions(ctx, gameID)
+		mustDo(t, err)
+		if len(restrictions) != 0 {
+			t.Fatalf("restrictions left after delete: %v", restrictions)
+		}
+		if creator, _ := s.GetRestrictionCreator(ctx, gameID, 1, 2); creator != 0 {
+			t.Fatalf("restriction creator left after DeleteAllRestrictionsForUser")
+		}
+		if has, _ := s.HasRestriction(ctx, otherGameID, 1, 2); !has {
+			t.Fatalf("restriction in another game was deleted")
+		}
+
+		mustDo(t, s.SavePreference(ctx, gameID, 1, 2, domain.PreferenceLove))
+		mustDo(t, s.SavePreference(ctx, gameID, 1, 3, domain.PreferenceAvoid))
+		mustDo(t, s.DeletePreference(ctx, gameID, 1, 3))
+		preferences, err := s.GetAllPreferences(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetAllPreferences", preferences, map[int64]map[int64]domain.PreferenceKind{1: {2: domain.PreferenceLove}})
+	})
+
+	run("Groups", func(t *testing.T, s domain.StorageInterface) {
+		group := &domain.Group{Name: "Отдел", CreatorID: 1, Members: []int64{1, 2}}
+		mustDo(t, s.SaveGroup(ctx, gameID, group))
+
+		got, err := s.GetGroup(ctx, gameID, "отдел")
+		mustDo(t, err)
+		assertEqual(t, "GetGroup", got, group)
+
+		groups, err := s.GetAllGroups(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetAllGroups", groups, map[string]*domain.Group{"отдел": group})
+
+		mustDo(t, s.DeleteGroup(ctx, gameID, "ОТДЕЛ"))
+		if got, _ := s.GetGroup(ctx, gameID, "Отдел"); got != nil {
+			t.Fatalf("group still present after DeleteGroup")
+		}
+	})
+
+	run("AssignmentsResetGiftStatus", func(t *testing.T, s domain.StorageInterface) {
+		mustDo(t, s.SaveAssignment(ctx, gameID, 1, 2))
+		mustDo(t, s.SaveAssignment(ctx, gameID, 2, 3))
+		mustDo(t, s.SaveAssignment(ctx, gameID, 3, 1))
+		mustDo(t, s.SaveGiftStatus(ctx, gameID, 1, domain.GiftShipped))
+		mustDo(t, s.SaveGiftStatus(ctx, gameID, 2, domain.GiftBought))
+		mustDo(t, s.SaveGiftStatus(ctx, gameID, 3, domain.GiftDelivered))
+
+		assignments, err := s.GetAllAssignments(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetAllAssignments", assignments, map[int64]int64{1: 2, 2: 3, 3: 1})
+
+		mustDo(t, s.SaveAssignment(ctx, gameID, 1, 2))
+		mustDo(t, s.SaveAssignment(ctx, gameID, 2, 4))
+		statuses, err := s.GetAllGiftStatuses(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "gift statuses after reassignment", statuses, map[int64]domain.GiftStatus{1: domain.GiftShipped, 3: domain.GiftDelivered})
+
+		mustDo(t, s.DeleteAssignment(ctx, gameID, 3))
+		if st, _ := s.GetGiftStatus(ctx, gameID, 3); st != domain.GiftNotStarted {
+			t.Fatalf("gift status after DeleteAssignment = %q; want not started", st)
+		}
+
+		mustDo(t, s.DeleteAllAssignments(ctx, gameID))
+		assignments, err = s.GetAllAssignments(ctx, gameID)
+		mustDo(t, err)
+		statuses, err = s.GetAllGiftStatuses(ctx, gameID)
+		mustDo(t, err)
+		if len(assignments) != 0 || len(statuses) != 0 {
+			t.Fatalf("after DeleteAllAssignments: assignments %v, statuses %v", assignments, statuses)
+		}
+	})
+
+	run("GameStateAndSettings", func(t *testing.T, s domain.StorageInterface) {
+		mustDo(t, s.SaveGameState(ctx, gameID, true, false))
+		active, started, err := s.GetGameState(ctx, gameID)
+		mustDo(t, err)
+		if !active || started {
+			t.Fatalf("GetGameState = %t, %t; want true, false", active, started)
+		}
+		mustDo(t, s.ResetGameState(ctx, gameID))
+		if active, _, _ := s.GetGameState(ctx, gameID); active {
+			t.Fatalf("game still active after ResetGameState")
+		}
+
+		settings := &domain.GameSettings{Mode: domain.AssignmentModeChain, HistorySeasons: 2, HistoryHard: true}
+		mustDo(t, s.SaveGameSettings(ctx, gameID, settings))
+		gotSettings, err := s.GetGameSettings(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetGameSettings", gotSettings, settings)
+
+		created := time.Date(2026, 12, 1, 10, 0, 0, 0, time.UTC)
+		record := &domain.DrawRecord{Pairs: 3, Commitment: "abc", Salt: "salt", Seed: "seed", CreatedAt: created}
+		mustDo(t, s.SaveDrawRecord(ctx, gameID, record))
+		gotRecord, err := s.GetDrawRecord(ctx, gameID)
+		mustDo(t, err)
+		if gotRecord == nil || gotRecord.Commitment != "abc" || gotRecord.Seed != "seed" || !gotRecord.CreatedAt.Equal(created) {
+			t.Fatalf("GetDrawRecord = %+v", gotRecord)
+		}
+
+		mustDo(t, s.SaveEntropy(ctx, gameID, 1, &domain.Entropy{Nonce: "a", Text: "снег"}))
+		mustDo(t, s.SaveEntropy(ctx, gameID, 2, &domain.Entropy{Nonce: "b", Text: "мандарины"}))
+		mustDo(t, s.SaveEntropy(ctx, gameID, 1, &domain.Entropy{Nonce: "c", Text: "снег и елка"}))
+		entropy, err := s.GetAllEntropy(ctx, gameID)
+		mustDo(t, err)
+		assertEqual(t, "GetAllEntropy", entropy, map[int64]*domain.Entropy{1: {Nonce: "c", Text: "снег и елка"}, 2: {Nonce: "b", Text: "мандарины"}})
+
+		mustDo(t, s.SaveRevealSettings(ctx, gameID, &domain.RevealSettings{ScheduledAt: created, SantaLookup: true}))
+		reveal, err := s.GetRevealSettings(ctx, gameID)
+		mustDo(t, err)
+		if reveal == nil || !reveal.ScheduledAt.Equal(created) || !reveal.SantaLookup {
+			t.Fatalf("GetRevealSettings = %+v", reveal)
+		}
+	})
+
+	run("Seasons", func(t *testing.T, s domain.StorageInterface) {
+		for i := 1; i <= 3; i++ {
+			mustDo(t, s.SaveSeason(ctx, &domain.Season{GameID: gameID, Title: strconv.Itoa(i)}))
+		}
+		mustDo(t, s.SaveSeason(ctx, &domain.Season{GameID: otherGameID, Title: "other"}))
+
+		seasons, err := s.GetSeasons(ctx, gameID)
+		mustDo(t, err)
+		titles := make([]string, 0, len(seasons))
+		for _, season := range seasons {
+			titles = append(titles, season.Title)
+		}
+		assertEqual(t, "GetSeasons (newest first)", titles, []string{"3", "2", "1"})
+	})
+
+	run("WishesCommentsTriggers", func(t *testing.T, s domain.StorageInterface) {
+		mustDo(t, s.SaveWish(ctx, gameID, 1, "книга"))
+		wish, err := s.GetWish(ctx, gameID, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetWish", wish, "книга")
+		mustDo(t, s.DeleteWish(ctx, gameID, 1))
+		if wish, _ := s.GetWish(ctx, gameID, 1); wish != "" {
+			t.Fatalf("wish still present after DeleteWish")
+		}
+
+		mustDo(t, s.SaveComment(ctx, gameID, 1, 2, "любит кофе"))
+		mustDo(t, s.SaveComment(ctx, gameID, 1, 3, "любит чай"))
+		mustDo(t, s.SaveComment(ctx, gameID, 10, 2, "другой получатель"))
+		mustDo(t, s.DeleteComment(ctx, gameID, 1, 3))
+		comments, err := s.GetComments(ctx, gameID, 1)
+		mustDo(t, err)
+		assertEqual(t, "GetComments", comments, map[int64]string{2: "любит кофе"})
+
+		mustDo(t, s.SaveTriggerMessage(ctx, "мат", "Не ругайтесь!"))
+		mustDo(t, s.SaveTriggerMessage(ctx, "мат", "Не ругайтесь!"))
+		mustDo(t, s.SaveTriggerMessage(ctx, "мат", "Ай-ай-ай"))
+		mustDo(t, s.SaveTriggerMessage(ctx, "елка", "🎄"))
+		messages, err := s.GetTriggerMessages(ctx, "мат")
+		mustDo(t, err)
+		assertEqual(t, "GetTriggerMessages", messages, []string{"Не ругайтесь!", "Ай-ай-ай"})
+
+		mustDo(t, s.DeleteTriggerMessage(ctx, "елка", "🎄"))
+		words, err := s.GetAllTriggerWords(ctx)
+		mustDo(t, err)
+		assertEqual(t, "GetAllTriggerWords", words, []string{"мат"})
+	})
+
+	run("Outbox", func(t *testing.T, s domain.StorageInterface) {
+		now := time.Now()
+		for i, delay := range []time.Duration{2 * time.Second, -3 * time.Second, -time.Second, time.Hour} {
+			mustDo(t, s.EnqueueOutbound(ctx, &domain.OutboundMessage{
+				ID: "m" + strconv.Itoa(i), ChatID: int64(i), Text: "hi", NotBefore: now.Add(delay),
+				Relay: &domain.RelayRoute{GameID: gameID, Role: domain.RelayRoleSanta},
+			}))
+		}
+
+		due, err := s.GetDueOutbound(ctx, now, 10)
+		mustDo(t, err)
+		assertEqual(t, "due messages", outboundIDs(due), []string{"m1", "m2"})
+		if due[0].Relay == nil || due[0].Relay.Role != domain.RelayRoleSanta {
+			t.Fatalf("relay route not preserved: %+v", due[0].Relay)
+		}
+
+		due, err = s.GetDueOutbound(ctx, now, 1)
+		mustDo(t, err)
+		assertEqual(t, "due messages with limit", outboundIDs(due), []string{"m1"})
+
+		m1 := due[0]
+		m1.Attempts++
+		m1.NotBefore = now.Add(time.Minute)
+		mustDo(t, s.RescheduleOutbound(ctx, m1))
+		mustDo(t, s.DeleteOutbound(ctx, "m2"))
+
+		due, err = s.GetDueOutbound(ctx, now.Add(3*time.Second), 10)
+		mustDo(t, err)
+		assertEqual(t, "due messages after reschedule", outboundIDs(due), []string{"m0"})
+
+		due, err = s.GetDueOutbound(ctx, now.Add(2*time.Minute), 10)
+		mustDo(t, err)
+		assertEqual(t, "due messages later", outboundIDs(due), []string{"m0", "m1"})
+		if due[1].Attempts != 1 {
+			t.Fatalf("rescheduled attempts = %d; want 1", due[1].Attempts)
+		}
+	})
+
+	run("OutboundBatch", func(t *testing.T, s domain.StorageInterface) {
+		now := time.Now()
+		batch := &domain.OutboundBatch{ID: "b2", GameID: gameID, Title: "Офис", Total: 2}
+		mustDo(t, s.EnqueueOutboundBatch(ctx, batch,
+			[]*domain.Delivery{
+				{ChatID: 1, Recipient: "Аня", Status: domain.DeliveryPending},
+				{ChatID: 2, Recipient: "Борис", Status: domain.DeliveryPending},
+			},
+			[]*domain.OutboundMessage{
+				{ID: "b2m1", ChatID: 1, Text: "hi", BatchID: "b2", NotBefore: now},
+				{ID: "b2m2", ChatID: 2, Text: "hi", BatchID: "b2", NotBefore: now},
+			}))
+
+		got, err := s.GetOutboundBatch(ctx, "b2")
+		mustDo(t, err)
+		if got == nil || got.Total != 2 {
+			t.Fatalf("GetOutboundBatch = %+v", got)
+		}
+		deliveries, err := s.GetDeliveries(ctx, "b2")
+		mustDo(t, err)
+		if len(deliveries) != 2 || deliveries[0].Recipient != "Аня" || deliveries[1].Status != domain.DeliveryPending {
+			t.Fatalf("GetDeliveries = %+v", deliveries)
+		}
+		due, err := s.GetDueOutbound(ctx, now, 10)
+		mustDo(t, err)
+		assertEqual(t, "batch messages", outboundIDs(due), []string{"b2m1", "b2m2"})
+	})
+
+	run("Deliveries", func(t *testing.T, s domain.StorageInterface) {
+		batch := &domain.OutboundBatch{ID: "b1", GameID: gameID, Title: "Офис", ReportChatID: 7, Total: 2}
+		mustDo(t, s.SaveOutboundBatch(ctx, batch))
+		got, err := s.GetOutboundBatch(ctx, "b1")
+		mustDo(t, err)
+		if got == nil || got.Total != 2 || got.ReportChatID != 7 {
+			t.Fatalf("GetOutboundBatch = %+v", got)
+		}
+
+		mustDo(t, s.SaveDelivery(ctx, "b1", &domain.Delivery{ChatID: 1, Status: domain.DeliveryPending}))
+		mustDo(t, s.SaveDelivery(ctx, "b1", &domain.Delivery{ChatID: 2, Status: domain.DeliverySent}))
+		mustDo(t, s.SaveDelivery(ctx, "b1", &domain.Delivery{ChatID: 1, Status: domain.DeliveryFailed, ErrorCode: 403}))
+		deliveries, err := s.GetDeliveries(ctx, "b1")
+		mustDo(t, err)
+		statuses := make(map[int64]domain.DeliveryStatus)
+		for _, d := range deliveries {
+			statuses[d.ChatID] = d.Status
+		}
+		assertEqual(t, "GetDeliveries", statuses, map[int64]domain.DeliveryStatus{1: domain.DeliveryFailed, 2: domain.DeliverySent})
+
+		mustDo(t, s.DeleteOutboundBatch(ctx, "b1"))
+		if got, _ := s.GetOutboundBatch(ctx, "b1"); got != nil {
+			t.Fatalf("batch still present after DeleteOutboundBatch")
+		}
+		if deliveries, _ := s.GetDeliveries(ctx, "b1"); len(deliveries) != 0 {
+			t.Fatalf("deliveries left after DeleteOutboundBatch: %d", len(deliveries))
+		}
+	})
+
+	run("Relay", func(t *testing.T, s domain.StorageInterface) {
+		mustDo(t, s.SaveRelayRoute(ctx, 1, 100, &domain.RelayRoute{GameID: gameID, Role: domain.RelayRoleReceiver}))
+		role, err := s.GetRelayRole(ctx, gameID, 1, 100)
+		mustDo(t, err)
+		assertEqual(t, "GetRelayRole", role, domain.RelayRoleReceiver)
+		if role, _ := s.GetRelayRole(ctx, otherGameID, 1, 100); role != "" {
+			t.Fatalf("relay route leaked into another game")
+		}
+
+		for _, text := range []string{"первое", "второе", "третье"} {
+			mustDo(t, s.SaveRelayMessage(ctx, &domain.RelayMessage{GameID: gameID, FromID: 1, ToID: 2, FromRole: domain.RelayRoleSanta, Text: text}))
+		}
+		messages, err := s.GetRelayMessages(ctx, gameID)
+		mustDo(t, err)
+		texts := make([]string, 0, len(messages))
+		for _, m := range messages {
+			texts = append(texts, m.Text)
+		}
+		assertEqual(t, "GetRelayMessages", texts, []string{"первое", "второе", "третье"})
+	})
+
+	run("ConcurrentWrites", func(t *testing.T, s domain.StorageInterface) {
+		var wg sync.WaitGroup
+		for i := int64(1); i <= 20; i++ {
+			wg.Add(1)
+			go func(userID int64) {
+				defer wg.Done()
+				for _, err := range []error{
+					s.SaveParticipant(ctx, gameID, &domain.Participant{UserID: userID}),
+					s.SaveAssignment(ctx, gameID, userID, userID%20+1),
+					s.SaveGiftStatus(ctx, gameID, userID, domain.GiftBought),
+				} {
+					if err != nil {
+						t.Error(err)
+					}
+				}
+				if _, err := s.GetAllAssignments(ctx, gameID); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		participants, err := s.GetAllParticipants(ctx, gameID)
+		mustDo(t, err)
+		assignments, err := s.GetAllAssignments(ctx, gameID)
+		mustDo(t, err)
+		statuses, err := s.GetAllGiftStatuses(ctx, gameID)
+		mustDo(t, err)
+		if len(participants) != 20 || len(assignments) != 20 || len(statuses) != 20 {
+			t.Fatalf("after concurrent writes: %d participants, %d assignments, %d statuses; want 20 each",
+				len(participants), len(assignments), len(statuses))
+		}
+	})
+
+	run("ClearGame", func(t *testing.T, s domain.StorageInterface) {
+		for _, id := range []int64{gameID, otherGameID} {
+			mustDo(t, s.SaveGame(ctx, &domain.Game{ID: id, Title: "game"}))
+			mustDo(t, s.SaveGameSettings(ctx, id, &domain.GameSettings{Mode: domain.AssignmentModeNoMutual}))
+			mustDo(t, s.SaveParticipant(ctx, id, &domain.Participant{UserID: 1}))
+			mustDo(t, s.SaveParticipant(ctx, id, &domain.Participant{UserID: 2}))
+			mustDo(t, s.SaveRestriction(ctx, id, 1, 2, 1))
+			mustDo(t, s.SavePreference(ctx, id, 1, 2, domain.PreferenceAvoid))
+			mustDo(t, s.SaveGroup(ctx, id, &domain.Group{Name: "g"}))
+			mustDo(t, s.SaveAssignment(ctx, id, 1, 2))
+			mustDo(t, s.SaveGiftStatus(ctx, id, 1, domain.GiftBought))
+			mustDo(t, s.SaveGameState(ctx, id, true, true))
+			mustDo(t, s.SaveWish(ctx, id, 1, "wish"))
+			mustDo(t, s.SaveComment(ctx, id, 1, 2, "comment"))
+			mustDo(t, s.SaveDrawRecord(ctx, id, &domain.DrawRecord{Commitment: "c"}))
+			mustDo(t, s.SaveEntropy(ctx, id, 1, &domain.Entropy{Text: "e"}))
+			mustDo(t, s.SaveRevealSettings(ctx, id, &domain.RevealSettings{SantaLookup: true}))
+			mustDo(t, s.SaveRelayRoute(ctx, 1, 100, &domain.RelayRoute{GameID: id, Role: domain.RelayRoleSanta}))
+			mustDo(t, s.SaveRelayMessage(ctx, &domain.RelayMessage{GameID: id, Text: "hi"}))
+		}
+		mustDo(t, s.SaveSeason(ctx, &domain.Season{GameID: gameID, Title: "2025"}))
+
+		mustDo(t, s.ClearGame(ctx, gameID))
+
+		if g, _ := s.GetGame(ctx, gameID); g == nil {
+			t.Fatalf("game info was cleared")
+		}
+		if settings, _ := s.GetGameSettings(ctx, gameID); settings == nil || settings.Mode != domain.AssignmentModeNoMutual {
+			t.Fatalf("game settings were cleared: %+v", settings)
+		}
+		if seasons, _ := s.GetSeasons(ctx, gameID); len(seasons) != 1 {
+			t.Fatalf("history was cleared: %d seasons", len(seasons))
+		}
+		if games, _ := s.GetUserGames(ctx, 1); !reflect.DeepEqual(games, []int64{otherGameID}) {
+			t.Fatalf("GetUserGames after ClearGame = %v; want [%d]", games, otherGameID)
+		}
+
+		for id, cleared := range map[int64]bool{gameID: true, otherGameID: false} {
+			participants, _ := s.GetAllParticipants(ctx, id)
+			restrictions, _, _ := s.GetAllRestrictions(ctx, id)
+			preferences, _ := s.GetAllPreferences(ctx, id)
+			groups, _ := s.GetAllGroups(ctx, id)
+			assignments, _ := s.GetAllAssignments(ctx, id)
+			statuses, _ := s.GetAllGiftStatuses(ctx, id)
+			active, _, _ := s.GetGameState(ctx, id)
+			wish, _ := s.GetWish(ctx, id, 1)
+			comments, _ := s.GetComments(ctx, id, 1)
+			record, _ := s.GetDrawRecord(ctx, id)
+			entropy, _ := s.GetAllEntropy(ctx, id)
+			reveal, _ := s.GetRevealSettings(ctx, id)
+			role, _ := s.GetRelayRole(ctx, id, 1, 100)
+			relayLog, _ := s.GetRelayMessages(ctx, id)
+
+			present := map[string]bool{
+				"participants": len(participants) > 0,
+				"restrictions": len(restrictions) > 0,
+				"preferences":  len(preferences) > 0,
+				"groups":       len(groups) > 0,
+				"assignments":  len(assignments) > 0,
+				"gift status":  len(statuses) > 0,
+				"game state":   active,
+				"wish":         wish != "",
+				"comments":     len(comments) > 0,
+				"draw record":  record != nil,
+				"entropy":      len(entropy) > 0,
+				"reveal":       reveal != nil,
+				"relay route":  role != "",
+				"relay log":    len(relayLog) > 0,
+			}
+			for name, ok := range present {
+				if ok == cleared {
+					t.Errorf("game %d: %s present = %t after clearing game %d", id, name, ok, gameID)
+				}
+			}
+		}
+	})
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertEqual(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s = %#v; want %#v", what, got, want)
+	}
+}
+
+func sortedIDs(ids []int64) []int64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func outboundIDs(messages []*domain.OutboundMessage) []string {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storageConformance(t, func(t *testing.T) domain.StorageInterface {
+		return NewMemoryStorage()
+	})
+}
+
+func TestBoltStorage(t *testing.T) {
+	storageConformance(t, func(t *testing.T) domain.StorageInterface {
+		s, err := NewBoltStorage(filepath.Join(t.TempDir(), "santa.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// newRedisTestStorage подключается к REDIS_TEST_ADDR (host:port) и очищает базу
+// REDIS_TEST_DB (по умолчанию 15). Без REDIS_TEST_ADDR тест пропускается.
+func newRedisTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	host, port, _ := strings.Cut(addr, ":")
+	db := 15
+	if dbStr := os.Getenv("REDIS_TEST_DB"); dbStr != "" {
+		db, _ = strconv.Atoi(dbStr)
+	}
+
+	s, err := NewStorage(context.Background(), host, port, "", db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRedisStorage(t *testing.T) {
+	storageConformance(t, func(t *testing.T) domain.StorageInterface {
+		return newRedisTestStorage(t)
+	})
+}
+
+// TestRedisMigration записывает данные в раскладке с ключом на запись и проверяет,
+// что после миграции они читаются из хешей, а старые ключи удалены.
+func TestRedisMigration(t *testing.T) {
+	ctx := context.Background()
+	s := newRedisTestStorage(t)
+	defer s.Close()
+	const gameID = int64(-100)
+
+	old := map[string]string{
+		userKey(1):                          `{"UserID":1,"Username":"anya"}`,
+		gameInfoKey(gameID):                 `{"ID":-100,"Title":"Офис"}`,
+		participantKey(gameID, 1):           `{"UserID":1,"Username":"anya"}`,
+		participantKey(gameID, 2):           `{"UserID":2,"Username":"boris"}`,
+		restrictionKey(gameID, 1, 2):        "1",
+		restrictionCreatorKey(gameID, 1, 2): "7",
+		restrictionKey(gameID, 2, 1):        "1",
+		preferenceKey(gameID, 1, 2):         string(domain.PreferenceAvoid),
+		groupKey(gameID, "Семья"):           `{"Name":"Семья","Members":[1,2]}`,
+		assignmentKey(gameID, 1):            "2",
+		commentKey(gameID, 2, 1):            "любит чай",
+		triggerMessagesKey("мат"):           `["Не выражаться!"]`,
+		gameSettingsKey(gameID):             `{}`,
+	}
+	for key, value := range old {
+		mustDo(t, s.client.Set(ctx, key, value, 0).Err())
+	}
+	mustDo(t, s.client.Del(ctx, schemaVersionKey).Err())
+	mustDo(t, s.migrate(ctx, 0))
+
+	if u, err := s.GetUser(ctx, 1); err != nil || u == nil || u.Username != "anya" {
+		t.Fatalf("GetUser = %+v, %v", u, err)
+	}
+	if g, err := s.GetGame(ctx, gameID); err != nil || g == nil || g.Title != "Офис" {
+		t.Fatalf("GetGame = %+v, %v", g, err)
+	}
+	if participants, err := s.GetAllParticipants(ctx, gameID); err != nil || len(participants) != 2 {
+		t.Fatalf("GetAllParticipants = %v, %v", participants, err)
+	}
+	restrictions, creators, err := s.GetAllRestrictions(ctx, gameID)
+	mustDo(t, err)
+	if !restrictions[1][2] || !restrictions[2][1] || creators[1][2] != 7 || creators[2][1] != 0 {
+		t.Fatalf("GetAllRestrictions = %v, %v", restrictions, creators)
+	}
+	if prefs, err := s.GetAllPreferences(ctx, gameID); err != nil || prefs[1][2] != domain.PreferenceAvoid {
+		t.Fatalf("GetAllPreferences = %v, %v", prefs, err)
+	}
+	if group, err := s.GetGroup(ctx, gameID, "семья"); err != nil || group == nil || len(group.Members) != 2 {
+		t.Fatalf("GetGroup = %+v, %v", group, err)
+	}
+	if receiver, err := s.GetAssignment(ctx, gameID, 1); err != nil || receiver != 2 {
+		t.Fatalf("GetAssignment = %d, %v", receiver, err)
+	}
+	if comments, err := s.GetComments(ctx, gameID, 2); err != nil || comments[1] != "любит чай" {
+		t.Fatalf("GetComments = %v, %v", comments, err)
+	}
+	if words, err := s.GetAllTriggerWords(ctx); err != nil || !reflect.DeepEqual(words, []string{"мат"}) {
+		t.Fatalf("GetAllTriggerWords = %v, %v", words, err)
+	}
+
+	for key := range old {
+		if key == triggerMessagesKey("мат") || key == gameSettingsKey(gameID) {
+			continue
+		}
+		if n, _ := s.client.Exists(ctx, key).Result(); n != 0 {
+			t.Errorf("old key %s was not removed", key)
+		}
+	}
+	if n, _ := s.client.Exists(ctx, gameSettingsKey(gameID)).Result(); n != 1 {
+		t.Errorf("settings key was touched by the migration")
+	}
+
+	// Повторный запуск ничего не делает.
+	mustDo(t, s.client.Set(ctx, participantKey(gameID, 3), `{"UserID":3}`, 0).Err())
+	mustDo(t, s.migrate(ctx, 0))
+	if p, _ := s.GetParticipant(ctx, gameID, 3); p != nil {
+		t.Fatalf("migration ran twice")
+	}
+}
+
+// TestRedisMigrationFromSingleGame записывает данные версии с одной общей игрой и проверяет,
+// что обе ступени миграции переносят их в игру LEGACY_GAME_ID.
+func TestRedisMigrationFromSingleGame(t *testing.T) {
+	ctx := context.Background()
+	s := newRedisTestStorage(t)
+	defer s.Close()
+	const gameID = int64(-100)
+
+	old := map[string]string{
+		"participant:1":           `{"UserID":1,"Username":"anya","FullName":"Аня"}`,
+		"participant:2":           `{"UserID":2,"Username":"boris","FullName":"Борис"}`,
+		"restriction:1:2":         "1",
+		"restriction_creator:1:2": "7",
+		"assignment:1":            "2",
+		"assignment:2":            "1",
+		"game:state":              "true:true",
+		"wish:2":                  "книга",
+		"comment:2:1":             "любит чай",
+		"trigger_messages:мат":    `["Не выражаться!"]`,
+	}
+	for key, value := range old {
+		mustDo(t, s.client.Set(ctx, key, value, 0).Err())
+	}
+	mustDo(t, s.client.Del(ctx, schemaVersionKey).Err())
+
+	// Без LEGACY_GAME_ID бот не запускается и ничего не трогает.
+	if err := s.migrate(ctx, 0); err == nil || !strings.Contains(err.Error(), "LEGACY_GAME_ID") {
+		t.Fatalf("migrate without a legacy game = %v, want an error naming LEGACY_GAME_ID", err)
+	}
+	if n, _ := s.client.Exists(ctx, "participant:1", "game:state").Result(); n != 2 {
+		t.Fatalf("single-game keys were touched without a legacy game")
+	}
+	if n, _ := s.client.Exists(ctx, schemaVersionKey).Result(); n != 0 {
+		t.Fatalf("schema version saved after a failed migration")
+	}
+
+	mustDo(t, s.migrate(ctx, gameID))
+
+	if participants, err := s.GetAllParticipants(ctx, gameID); err != nil || len(participants) != 2 || participants[2].FullName != "Борис" {
+		t.Fatalf("GetAllParticipants = %v, %v", participants, err)
+	}
+	if games, err := s.GetUserGames(ctx, 1); err != nil || !reflect.DeepEqual(games, []int64{gameID}) {
+		t.Fatalf("GetUserGames = %v, %v", games, err)
+	}
+	if g, err := s.GetGame(ctx, gameID); err != nil || g == nil {
+		t.Fatalf("GetGame = %+v, %v", g, err)
+	}
+	restrictions, creators, err := s.GetAllRestrictions(ctx, gameID)
+	mustDo(t, err)
+	if !restrictions[1][2] || creators[1][2] != 7 {
+		t.Fatalf("GetAllRestrictions = %v, %v", restrictions, creators)
+	}
+	if assignments, err := s.GetAllAssignments(ctx, gameID); err != nil || !reflect.DeepEqual(assignments, map[int64]int64{1: 2, 2: 1}) {
+		t.Fatalf("GetAllAssignments = %v, %v", assignments, err)
+	}
+	if active, started, err := s.GetGameState(ctx, gameID); err != nil || !active || !started {
+		t.Fatalf("GetGameState = %v, %v, %v", active, started, err)
+	}
+	if wish, err := s.GetWish(ctx, gameID, 2); err != nil || wish != "книга" {
+		t.Fatalf("GetWish = %q, %v", wish, err)
+	}
+	if comments, err := s.GetComments(ctx, gameID, 2); err != nil || comments[1] != "любит чай" {
+		t.Fatalf("GetComments = %v, %v", comments, err)
+	}
+	if words, err := s.GetAllTriggerWords(ctx); err != nil || !reflect.DeepEqual(words, []string{"мат"}) {
+		t.Fatalf("GetAllTriggerWords = %v, %v", words, err)
+	}
+	for key := range old {
+		if key == "trigger_messages:мат" {
+			continue
+		}
+		if n, _ := s.client.Exists(ctx, key).Result(); n != 0 {
+			t.Errorf("old key %s was not removed", key)
+		}
+	}
+	if version, err := s.client.Get(ctx, schemaVersionKey).Int(); err != nil || version != 2 {
+		t.Fatalf("schema version = %d, %v", version, err)
+	}
+}
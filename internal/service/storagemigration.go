@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-secret-santa/internal/domain"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// migrate переносит данные из прежних раскладок Redis. Шаги выполняются по порядку и по
+// одному разу, номер последнего выполненного хранится в schema_version:
+//  1. данные версии с одной общей игрой переносятся в игру legacyGameID (ключ на запись);
+//  2. записи игр переносятся из ключей в хеши-индексы.
+func (s *Storage) migrate(ctx context.Context, legacyGameID int64) error {
+	version, err := s.client.Get(ctx, schemaVersionKey).Int()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	if version < 1 {
+		if err := s.migrateSingleGame(ctx, legacyGameID); err != nil {
+			return err
+		}
+		if err := s.client.Set(ctx, schemaVersionKey, 1, 0).Err(); err != nil {
+			return fmt.Errorf("failed to save schema version: %w", err)
+		}
+	}
+	if version < 2 {
+		if err := s.migrateToHashes(ctx); err != nil {
+			return err
+		}
+		if err := s.client.Set(ctx, schemaVersionKey, 2, 0).Err(); err != nil {
+			return fmt.Errorf("failed to save schema version: %w", err)
+		}
+	}
+	return nil
+}
+
+// migrateToHashes переносит записи, каждая из которых лежала под своим ключом, в хеши-индексы.
+func (s *Storage) migrateToHashes(ctx context.Context) error {
+	err := s.scanKeys(ctx, "user:*", func(keys []string) error {
+		for _, key := range keys {
+			userID, err := strconv.ParseInt(strings.TrimPrefix(key, "user:"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := s.moveToHash(ctx, key, usersKey, idField(userID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.scanKeys(ctx, "game:*", func(keys []string) error {
+		for _, key := range keys {
+			if err := s.migrateGameKey(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.scanKeys(ctx, "trigger_messages:*", func(keys []string) error {
+		words := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			if word := strings.TrimPrefix(key, "trigger_messages:"); word != "" {
+				words = append(words, word)
+			}
+		}
+		if len(words) == 0 {
+			return nil
+		}
+		return s.client.SAdd(ctx, triggerWordsKey, words...).Err()
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrateGameKey разбирает ключ вида game:<id>:<вид>:<аргументы>. Ключи, которые
+// остаются как есть (состояние, настройки, желания и т.п.), и уже новые хеши пропускаются.
+func (s *Storage) migrateGameKey(ctx context.Context, key string) error {
+	idStr, rest, ok := strings.Cut(strings.TrimPrefix(key, "game:"), ":")
+	if !ok {
+		return nil
+	}
+	gameID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil
+	}
+	kind, args, _ := strings.Cut(rest, ":")
+
+	switch kind {
+	case "info":
+		if args == "" {
+			return s.moveToHash(ctx, key, gamesKey, idField(gameID))
+		}
+	case "participant":
+		if userID, err := strconv.ParseInt(args, 10, 64); err == nil {
+			return s.moveToHash(ctx, key, participantsKey(gameID), idField(userID))
+		}
+	case "restriction":
+		if userID, forbiddenUserID, ok := parsePairField(args); ok {
+			return s.migrateRestriction(ctx, gameID, userID, forbiddenUserID)
+		}
+	case "preference":
+		if userID, targetID, ok := parsePairField(args); ok {
+			return s.moveToHash(ctx, key, preferencesKey(gameID), pairField(userID, targetID))
+		}
+	case "group":
+		if args != "" {
+			return s.moveToHash(ctx, key, groupsKey(gameID), args)
+		}
+	case "assignment":
+		if giverID, err := strconv.ParseInt(args, 10, 64); err == nil {
+			return s.moveToHash(ctx, key, assignmentsKey(gameID), idField(giverID))
+		}
+	case "comment":
+		if receiverID, authorID, ok := parsePairField(args); ok {
+			return s.moveToHash(ctx, key, commentsKey(gameID, receiverID), idField(authorID))
+		}
+	}
+	return nil
+}
+
+// moveToHash переносит строковый ключ в поле хеша и удаляет старый ключ.
+func (s *Storage) moveToHash(ctx context.Context, key, hashKey, field string) error {
+	data, err := s.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, hashKey, field, data)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to migrate %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Storage) migrateRestriction(ctx context.Context, gameID, userID, forbiddenUserID int64) error {
+	creatorKey := restrictionCreatorKey(gameID, userID, forbiddenUserID)
+	creator, err := s.client.Get(ctx, creatorKey).Result()
+	if err == redis.Nil {
+		creator = "0"
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", creatorKey, err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, restrictionsKey(gameID), pairField(userID, forbiddenUserID), creator)
+	pipe.Del(ctx, restrictionKey(gameID, userID, forbiddenUserID), creatorKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to migrate restriction: %w", err)
+	}
+	return nil
+}
+
+// singleGameKeyPatterns - ключи версии с одной общей игрой: участники, ограничения, пары,
+// желания и комментарии хранились без ID игры, а состояние - под ключом game:state.
+var singleGameKeyPatterns = []string{"participant:*", "restriction:*", "restriction_creator:*", "assignment:*", "wish:*", "comment:*"}
+
+const singleGameStateKey = "game:state"
+
+// migrateSingleGame переносит данные версии с одной общей игрой в игру gameID. Игру
+// нельзя угадать по самим данным, поэтому без gameID миграция отказывается запускаться,
+// а не оставляет участников и пары под ключами, которые бот больше не читает.
+func (s *Storage) migrateSingleGame(ctx context.Context, gameID int64) error {
+	moved := 0
+	move := func(key string) error {
+		newKey, userID, ok := singleGameKey(gameID, key)
+		if !ok {
+			return nil
+		}
+		if gameID == 0 {
+			return fmt.Errorf("found %s from the single-game version: set LEGACY_GAME_ID to the chat ID of the group where that game was played", key)
+		}
+
+		pipe := s.client.TxPipeline()
+		pipe.Rename(ctx, key, newKey)
+		if userID != 0 {
+			pipe.SAdd(ctx, userGamesKey(userID), idField(gameID))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", key, err)
+		}
+		moved++
+		return nil
+	}
+
+	for _, pattern := range singleGameKeyPatterns {
+		err := s.scanKeys(ctx, pattern, func(keys []string) error {
+			for _, key := range keys {
+				if err := move(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := s.client.Exists(ctx, singleGameStateKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", singleGameStateKey, err)
+	}
+	if n > 0 {
+		if err := move(singleGameStateKey); err != nil {
+			return err
+		}
+	}
+	if moved == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(&domain.Game{ID: gameID})
+	if err != nil {
+		return fmt.Errorf("failed to serialize game: %w", err)
+	}
+	if err := s.client.SetNX(ctx, gameInfoKey(gameID), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save game info: %w", err)
+	}
+	log.Printf("migrateSingleGame: moved %d keys of the single-game version into gameID=%d", moved, gameID)
+	return nil
+}
+
+// singleGameKey возвращает ключ записи в игре gameID и, для участника, его ID.
+func singleGameKey(gameID int64, key string) (string, int64, bool) {
+	if key == singleGameStateKey {
+		return gameStateKey(gameID), 0, true
+	}
+
+	kind, args, _ := strings.Cut(key, ":")
+	switch kind {
+	case "participant":
+		if userID, err := strconv.ParseInt(args, 10, 64); err == nil {
+			return participantKey(gameID, userID), userID, true
+		}
+	case "restriction":
+		if userID, forbiddenUserID, ok := parsePairField(args); ok {
+			return restrictionKey(gameID, userID, forbiddenUserID), 0, true
+		}
+	case "restriction_creator":
+		if userID, forbiddenUserID, ok := parsePairField(args); ok {
+			return restrictionCreatorKey(gameID, userID, forbiddenUserID), 0, true
+		}
+	case "assignment":
+		if giverID, err := strconv.ParseInt(args, 10, 64); err == nil {
+			return assignmentKey(gameID, giverID), 0, true
+		}
+	case "wish":
+		if userID, err := strconv.ParseInt(args, 10, 64); err == nil {
+			return wishKey(gameID, userID), 0, true
+		}
+	case "comment":
+		if receiverID, authorID, ok := parsePairField(args); ok {
+			return commentKey(gameID, receiverID, authorID), 0, true
+		}
+	}
+	return "", 0, false
+}
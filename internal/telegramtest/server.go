@@ -0,0 +1,378 @@
+// Package telegramtest - поддельный Telegram Bot API сервер для тестов. Он понимает методы,
+// которыми пользуется бот, записывает отправленные сообщения и отдает заранее
+// подготовленные обновления через getUpdates. Настоящий клиент подключается к нему
+// через service.NewTelegramAPI(token, server.URL()) или TELEGRAM_API_URL.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxPollWait = time.Second
+
+// SentMessage - сообщение, которое бот отправил через sendMessage.
+type SentMessage struct {
+	MessageID   int
+	ChatID      int64
+	Text        string
+	ParseMode   string
+	ReplyMarkup string
+}
+
+// Request - вызов метода Bot API с параметрами формы.
+type Request struct {
+	Method string
+	Params map[string]string
+}
+
+type Server struct {
+	Bot tgbotapi.User
+
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	sent          []SentMessage
+	requests      []Request
+	updates       []tgbotapi.Update
+	nextUpdateID  int
+	nextMessageID int
+	blocked       map[int64]bool
+	admins        map[int64][]tgbotapi.ChatMember
+	memberCounts  map[int64]int
+	notify        chan struct{}
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewServer запускает сервер на случайном локальном порту. Токен бота не проверяется.
+func NewServer() *Server {
+	s := &Server{
+		Bot:           tgbotapi.User{ID: 1000, IsBot: true, FirstName: "Santa", UserName: "test_santa_bot"},
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		blocked:       make(map[int64]bool),
+		admins:        make(map[int64][]tgbotapi.ChatMember),
+		memberCounts:  make(map[int64]int),
+		notify:        make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL - адрес сервера для TELEGRAM_API_URL.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.srv.Close()
+}
+
+// Sent возвращает все отправленные сообщения в порядке отправки.
+func (s *Server) Sent() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SentMessage(nil), s.sent...)
+}
+
+// SentTo возвращает сообщения, отправленные в один чат.
+func (s *Server) SentTo(chatID int64) []SentMessage {
+	var result []SentMessage
+	for _, m := range s.Sent() {
+		if m.ChatID == chatID {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// Requests возвращает вызовы метода method; пустая строка - все вызовы.
+func (s *Server) Requests(method string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []Request
+	for _, r := range s.requests {
+		if method == "" || r.Method == method {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// Block имитирует пользователя, который заблокировал бота или не начинал с ним диалог:
+// отправка в этот чат завершается ошибкой 403, а getChat - ошибкой «chat not found».
+func (s *Server) Block(chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked[chatID] = true
+}
+
+func (s *Server) Unblock(chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocked, chatID)
+}
+
+// SetAdministrators задает ответ getChatAdministrators для группы.
+func (s *Server) SetAdministrators(chatID int64, users ...tgbotapi.User) {
+	members := make([]tgbotapi.ChatMember, 0, len(users))
+	for i := range users {
+		members = append(members, tgbotapi.ChatMember{User: &users[i], Status: "administrator"})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.admins[chatID] = members
+}
+
+// SetMemberCount задает ответ getChatMembersCount для группы.
+func (s *Server) SetMemberCount(chatID int64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memberCounts[chatID] = count
+}
+
+// PushUpdate ставит обновление в очередь getUpdates, присваивая ему UpdateID.
+// Возвращает обновление, чтобы его можно было передать боту и напрямую.
+func (s *Server) PushUpdate(update tgbotapi.Update) tgbotapi.Update {
+	s.mu.Lock()
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	notify := s.notify
+	s.notify = make(chan struct{})
+	s.mu.Unlock()
+	close(notify)
+	return update
+}
+
+// PushMessage ставит в очередь текстовое сообщение от пользователя. Команда в начале
+// текста размечается сущностью bot_command, как это делает Telegram.
+func (s *Server) PushMessage(chat tgbotapi.Chat, from tgbotapi.User, text string) tgbotapi.Update {
+	s.mu.Lock()
+	messageID := s.nextMessageID
+	s.nextMessageID++
+	s.mu.Unlock()
+
+	msg := &tgbotapi.Message{
+		MessageID: messageID,
+		From:      &from,
+		Chat:      &chat,
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command := strings.Fields(text)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len([]rune(command))}}
+	}
+	return s.PushUpdate(tgbotapi.Update{Message: msg})
+}
+
+// PushCallback ставит в очередь нажатие инлайн-кнопки под сообщением бота.
+func (s *Server) PushCallback(from tgbotapi.User, message SentMessage, data string) tgbotapi.Update {
+	s.mu.Lock()
+	id := strconv.Itoa(s.nextUpdateID)
+	s.mu.Unlock()
+
+	return s.PushUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   id,
+		From: &from,
+		Message: &tgbotapi.Message{
+			MessageID: message.MessageID,
+			From:      &s.Bot,
+			Chat:      &tgbotapi.Chat{ID: message.ChatID, Type: chatType(message.ChatID)},
+			Text:      message.Text,
+		},
+		Data: data,
+	}})
+}
+
+// PrivateChat и GroupChat - чаты в том виде, в котором их присылает Telegram.
+func PrivateChat(user tgbotapi.User) tgbotapi.Chat {
+	return tgbotapi.Chat{ID: user.ID, Type: "private", UserName: user.UserName, FirstName: user.FirstName}
+}
+
+func GroupChat(chatID int64, title string) tgbotapi.Chat {
+	return tgbotapi.Chat{ID: chatID, Type: "supergroup", Title: title}
+}
+
+func chatType(chatID int64) string {
+	if chatID < 0 {
+		return "supergroup"
+	}
+	return "private"
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		http.NotFound(w, r)
+		return
+	}
+	method := parts[1]
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, 400, "Bad Request: "+err.Error())
+		return
+	}
+	params := make(map[string]string, len(r.PostForm))
+	for key := range r.PostForm {
+		params[key] = r.PostForm.Get(key)
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: method, Params: params})
+	s.mu.Unlock()
+
+	switch method {
+	case "getMe":
+		writeResult(w, s.Bot)
+	case "sendMessage":
+		s.sendMessage(w, params)
+	case "sendChatAction":
+		if s.isBlocked(params["chat_id"]) {
+			writeError(w, 403, "Forbidden: bot was blocked by the user")
+			return
+		}
+		writeResult(w, true)
+	case "getChat":
+		// Для недоступного чата getChat отвечает так же, как Telegram пользователю,
+		// который не начинал диалог с ботом.
+		if s.isBlocked(params["chat_id"]) {
+			writeError(w, 400, "Bad Request: chat not found")
+			return
+		}
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		writeResult(w, tgbotapi.Chat{ID: chatID, Type: chatType(chatID)})
+	case "answerCallbackQuery", "deleteWebhook", "setWebhook":
+		writeResult(w, true)
+	case "getChatAdministrators":
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		s.mu.Lock()
+		admins := s.admins[chatID]
+		s.mu.Unlock()
+		if admins == nil {
+			admins = []tgbotapi.ChatMember{}
+		}
+		writeResult(w, admins)
+	case "getChatMembersCount", "getChatMemberCount":
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		s.mu.Lock()
+		count := s.memberCounts[chatID]
+		s.mu.Unlock()
+		writeResult(w, count)
+	case "getUpdates":
+		s.getUpdates(w, params)
+	default:
+		writeError(w, 404, fmt.Sprintf("Not Found: method %s is not supported by the fake server", method))
+	}
+}
+
+func (s *Server) isBlocked(chatIDParam string) bool {
+	chatID, _ := strconv.ParseInt(chatIDParam, 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blocked[chatID]
+}
+
+func (s *Server) sendMessage(w http.ResponseWriter, params map[string]string) {
+	chatID, err := strconv.ParseInt(params["chat_id"], 10, 64)
+	if err != nil {
+		writeError(w, 400, "Bad Request: chat not found")
+		return
+	}
+	if s.isBlocked(params["chat_id"]) {
+		writeError(w, 403, "Forbidden: bot was blocked by the user")
+		return
+	}
+	if params["text"] == "" {
+		writeError(w, 400, "Bad Request: message text is empty")
+		return
+	}
+
+	s.mu.Lock()
+	m := SentMessage{
+		MessageID:   s.nextMessageID,
+		ChatID:      chatID,
+		Text:        params["text"],
+		ParseMode:   params["parse_mode"],
+		ReplyMarkup: params["reply_markup"],
+	}
+	s.nextMessageID++
+	s.sent = append(s.sent, m)
+	s.mu.Unlock()
+
+	writeResult(w, tgbotapi.Message{
+		MessageID: m.MessageID,
+		From:      &s.Bot,
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType(chatID)},
+		Date:      int(time.Now().Unix()),
+		Text:      m.Text,
+	})
+}
+
+// getUpdates отдает обновления начиная с offset. Если их нет, запрос ждет нового
+// обновления, но не дольше timeout и maxPollWait, чтобы тесты не зависали.
+func (s *Server) getUpdates(w http.ResponseWriter, params map[string]string) {
+	offset, _ := strconv.Atoi(params["offset"])
+	timeout, _ := strconv.Atoi(params["timeout"])
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	deadline := time.After(wait)
+
+	for {
+		s.mu.Lock()
+		var pending []tgbotapi.Update
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				pending = append(pending, u)
+			}
+		}
+		notify := s.notify
+		s.mu.Unlock()
+
+		if len(pending) > 0 || wait <= 0 {
+			if pending == nil {
+				pending = []tgbotapi.Update{}
+			}
+			writeResult(w, pending)
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			wait = 0
+		case <-s.closed:
+			wait = 0
+		}
+	}
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, 500, "Internal Server Error: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
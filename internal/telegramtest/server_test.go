@@ -0,0 +1,70 @@
+package telegramtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newClient(t *testing.T, server *Server) *tgbotapi.BotAPI {
+	t.Helper()
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("test-token", server.URL()+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestPollingReceivesScriptedUpdates(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := newClient(t, server)
+
+	user := tgbotapi.User{ID: 7, FirstName: "Аня"}
+	server.PushMessage(PrivateChat(user), user, "/start")
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 5
+	updates := api.GetUpdatesChan(u)
+	defer api.StopReceivingUpdates()
+
+	server.PushMessage(PrivateChat(user), user, "/help")
+	for _, want := range []string{"start", "help"} {
+		select {
+		case update := <-updates:
+			if got := update.Message.Command(); got != want {
+				t.Fatalf("command = %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("update /%s was not delivered", want)
+		}
+	}
+}
+
+func TestSendRecordsMessagesAndBlockedChatsFail(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := newClient(t, server)
+
+	msg := tgbotapi.NewMessage(7, "привет")
+	msg.ParseMode = "Markdown"
+	if _, err := api.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	sent := server.SentTo(7)
+	if len(sent) != 1 || sent[0].Text != "привет" || sent[0].ParseMode != "Markdown" {
+		t.Fatalf("sent = %+v", sent)
+	}
+
+	server.Block(8)
+	_, err := api.Send(tgbotapi.NewMessage(8, "привет"))
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 403 {
+		t.Fatalf("send to blocked chat: err = %v, want a 403 API error", err)
+	}
+	if len(server.SentTo(8)) != 0 {
+		t.Fatalf("message to blocked chat was recorded")
+	}
+}